@@ -0,0 +1,350 @@
+package api
+
+//OpenAPI documents the API, it is served at /v1/openapi.json
+const OpenAPI = `{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "Factory",
+    "description": "Control plane of the factory scheduler",
+    "version": "1"
+  },
+  "security": [{"bearer": []}, {"mtls": []}],
+  "paths": {
+    "/v1/tasks": {
+      "post": {
+        "summary": "Submit a task for scheduling",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SubmitInput"}}}
+        },
+        "responses": {
+          "201": {"description": "The submitted task", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Task"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "429": {"description": "The task doesn't fit the quota of its pool or submitter", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }
+      },
+      "get": {
+        "summary": "List the tasks of a pool, most recent first",
+        "parameters": [
+          {"name": "pool_id", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "default": 100}}
+        ],
+        "responses": {
+          "200": {"description": "The tasks", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Task"}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/tasks/{task_id}": {
+      "parameters": [{"name": "task_id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "Get a task",
+        "responses": {
+          "200": {"description": "The task", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Task"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/tasks/{task_id}/cancel": {
+      "parameters": [{"name": "task_id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "post": {
+        "summary": "Cancel a task, its claim is removed and its container stopped",
+        "responses": {
+          "200": {"description": "The cancelled task", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Task"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/tasks/{task_id}/logs": {
+      "parameters": [{"name": "task_id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "Get the tail of the logs of a task that succeeded or failed",
+        "responses": {
+          "200": {"description": "The logs", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/batches": {
+      "post": {
+        "summary": "Submit up to 100 tasks at once, each task succeeds or fails on its own",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BatchInput"}}}
+        },
+        "responses": {
+          "200": {"description": "A result for every task in the order they were given", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/BatchResult"}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/gangs": {
+      "post": {
+        "summary": "Submit a gang of tasks that are placed all together or not at all",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GangInput"}}}
+        },
+        "responses": {
+          "201": {"description": "The submitted gang", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Gang"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "429": {"description": "The gang doesn't fit the quota of its pool or submitter", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }
+      }
+    },
+    "/v1/gangs/{gang_id}": {
+      "parameters": [{"name": "gang_id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "Get a gang and its tasks",
+        "responses": {
+          "200": {"description": "The gang", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Gang"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/workflows": {
+      "post": {
+        "summary": "Submit a workflow of tasks that are scheduled once the tasks they depend on succeeded",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/WorkflowInput"}}}
+        },
+        "responses": {
+          "201": {"description": "The submitted workflow", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Workflow"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "429": {"description": "A task doesn't fit the quota of its pool or submitter", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }
+      }
+    },
+    "/v1/workflows/{workflow_id}": {
+      "parameters": [{"name": "workflow_id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "Get a workflow and the state of its tasks",
+        "responses": {
+          "200": {"description": "The workflow", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Workflow"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/nodes": {
+      "get": {
+        "summary": "List the nodes of the pool, or of every pool the caller may read",
+        "parameters": [{"name": "pool_id", "in": "query", "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "The nodes", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Node"}}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/nodes/{node_id}": {
+      "parameters": [{"name": "node_id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "Get a node",
+        "responses": {
+          "200": {"description": "The node", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Node"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/nodes/{node_id}/claims": {
+      "parameters": [{"name": "node_id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "List the claims on a node",
+        "responses": {
+          "200": {"description": "The claims", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Claim"}}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/nodes/{node_id}/drain": {
+      "parameters": [{"name": "node_id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "post": {
+        "summary": "Stop placing new claims on a node",
+        "responses": {
+          "200": {"description": "The drained node", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Node"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/nodes/{node_id}/evict": {
+      "parameters": [{"name": "node_id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "post": {
+        "summary": "Release all claims of a node and resubmit their tasks",
+        "responses": {
+          "204": {"description": "The claims were released"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer", "description": "A token of an identity in the policy file"},
+      "mtls": {"type": "mutualTLS", "description": "A client certificate whose common name is that of an identity in the policy file"}
+    },
+    "responses": {
+      "Error": {"description": "The request failed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "properties": {"message": {"type": "string"}}
+      },
+      "SubmitInput": {
+        "type": "object",
+        "required": ["pool_id", "size"],
+        "properties": {
+          "pool_id": {"type": "string"},
+          "size": {"type": "integer", "minimum": 1},
+          "priority": {"type": "string", "enum": ["high", "normal", "low"], "default": "normal", "description": "High priority tasks are scheduled first and may preempt the claims of lower priority tasks"},
+          "not_before": {"type": "string", "format": "date-time", "description": "The task is not scheduled before this moment, it is scheduled right away if omitted"},
+          "idempotency_key": {"type": "string", "description": "Submitting again with the same key within a day returns the task of the first submission"}
+        }
+      },
+      "Task": {
+        "type": "object",
+        "properties": {
+          "task_id": {"type": "string"},
+          "pool_id": {"type": "string"},
+          "size": {"type": "integer"},
+          "state": {"type": "string", "enum": ["waiting", "pending", "scheduled", "cancelled", "succeeded", "failed", "skipped"]},
+          "node_id": {"type": "string"},
+          "claim_id": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "submitter": {"type": "string", "description": "The identity that submitted the task"},
+          "priority": {"type": "string", "enum": ["high", "normal", "low"]},
+          "gang_id": {"type": "string", "description": "Set if the task is part of a gang"},
+          "workflow_id": {"type": "string", "description": "Set if the task is part of a workflow"},
+          "not_before": {"type": "string", "format": "date-time", "description": "Set if the task is not scheduled before this moment"},
+          "exit_code": {"type": "integer", "description": "Set once the task succeeded or failed"}
+        }
+      },
+      "BatchInput": {
+        "type": "object",
+        "required": ["tasks"],
+        "properties": {
+          "tasks": {"type": "array", "minItems": 1, "maxItems": 100, "items": {"$ref": "#/components/schemas/SubmitInput"}}
+        }
+      },
+      "BatchResult": {
+        "type": "object",
+        "properties": {
+          "task_id": {"type": "string", "description": "Set if the task was stored, even if it failed to be scheduled"},
+          "error": {"type": "string", "description": "Set if the task failed"}
+        }
+      },
+      "GangInput": {
+        "type": "object",
+        "required": ["pool_id", "size", "tasks"],
+        "properties": {
+          "pool_id": {"type": "string"},
+          "size": {"type": "integer", "minimum": 1, "description": "The size of each task"},
+          "tasks": {"type": "integer", "minimum": 1, "maximum": 25},
+          "priority": {"type": "string", "enum": ["high", "normal", "low"], "default": "normal"}
+        }
+      },
+      "Gang": {
+        "type": "object",
+        "properties": {
+          "gang_id": {"type": "string"},
+          "pool_id": {"type": "string"},
+          "size": {"type": "integer", "description": "The size of each task"},
+          "state": {"type": "string", "enum": ["pending", "scheduled"]},
+          "created_at": {"type": "string", "format": "date-time"},
+          "submitter": {"type": "string"},
+          "priority": {"type": "string", "enum": ["high", "normal", "low"]},
+          "tasks": {"type": "array", "items": {"$ref": "#/components/schemas/Task"}}
+        }
+      },
+      "WorkflowInput": {
+        "type": "object",
+        "required": ["tasks"],
+        "properties": {
+          "tasks": {"type": "array", "maxItems": 40, "items": {
+            "type": "object",
+            "required": ["name", "pool_id", "size"],
+            "properties": {
+              "name": {"type": "string"},
+              "pool_id": {"type": "string"},
+              "size": {"type": "integer", "minimum": 1},
+              "depends_on": {"type": "array", "items": {"type": "string"}, "description": "Names of the tasks that must succeed before this one is scheduled"}
+            }
+          }},
+          "priority": {"type": "string", "enum": ["high", "normal", "low"], "default": "normal"},
+          "on_failure": {"type": "string", "enum": ["fail", "skip"], "default": "fail", "description": "Whether the downstream tasks of a task that did not succeed are failed or skipped"}
+        }
+      },
+      "Workflow": {
+        "type": "object",
+        "properties": {
+          "workflow_id": {"type": "string"},
+          "state": {"type": "string", "enum": ["running", "succeeded", "failed"]},
+          "on_failure": {"type": "string", "enum": ["fail", "skip"]},
+          "created_at": {"type": "string", "format": "date-time"},
+          "submitter": {"type": "string"},
+          "tasks": {"type": "array", "items": {"allOf": [
+            {"type": "object", "properties": {"name": {"type": "string"}, "depends_on": {"type": "array", "items": {"type": "string"}}}},
+            {"$ref": "#/components/schemas/Task"}
+          ]}}
+        }
+      },
+      "Node": {
+        "type": "object",
+        "properties": {
+          "node_id": {"type": "string"},
+          "pool_id": {"type": "string"},
+          "host": {"type": "string"},
+          "cap": {"type": "integer"},
+          "max": {"type": "integer"},
+          "drain": {"type": "boolean"},
+          "expires_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Claim": {
+        "type": "object",
+        "properties": {
+          "claim_id": {"type": "string"},
+          "task_id": {"type": "string"},
+          "pool_id": {"type": "string"},
+          "node_id": {"type": "string"},
+          "size": {"type": "integer"},
+          "submitter": {"type": "string"},
+          "priority": {"type": "string", "enum": ["high", "normal", "low"]},
+          "state": {"type": "string", "enum": ["dispatched", "started"], "description": "A claim is started once its node accepted the run message"},
+          "expires_at": {"type": "string", "format": "date-time"}
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,770 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/advanderveer/factory/auth"
+	"github.com/advanderveer/factory/engine"
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	//DefaultTaskListLimit is the number of tasks listed when no limit is given
+	DefaultTaskListLimit = int64(100)
+
+	//MaxRequestBodySize limits the size of request bodies
+	MaxRequestBodySize = int64(1 << 20)
+)
+
+//Server serves the control plane API over HTTP with JSON bodies
+type Server struct {
+	logs   engine.Logger
+	eng    *engine.Engine
+	db     model.DB
+	policy *auth.Policy
+	mux    *http.ServeMux
+}
+
+//NewServer creates a server that wraps the engine and queries the database
+//directly. Callers are authenticated and authorized with the policy, if it is
+//nil every caller may do everything.
+func NewServer(logs engine.Logger, eng *engine.Engine, db model.DB, policy *auth.Policy) *Server {
+	s := &Server{
+		logs:   logs,
+		eng:    eng,
+		db:     db,
+		policy: policy,
+		mux:    http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/v1/openapi.json", s.handleOpenAPI)
+	s.mux.HandleFunc("/v1/tasks", s.handleTasks)
+	s.mux.HandleFunc("/v1/tasks/", s.handleTask)
+	s.mux.HandleFunc("/v1/batches", s.handleBatches)
+	s.mux.HandleFunc("/v1/gangs", s.handleGangs)
+	s.mux.HandleFunc("/v1/gangs/", s.handleGang)
+	s.mux.HandleFunc("/v1/workflows", s.handleWorkflows)
+	s.mux.HandleFunc("/v1/workflows/", s.handleWorkflow)
+	s.mux.HandleFunc("/v1/nodes", s.handleNodes)
+	s.mux.HandleFunc("/v1/nodes/", s.handleNode)
+	return s
+}
+
+//ServeHTTP authenticates the caller with a bearer token or a verified client
+//certificate, only the OpenAPI document is served to anyone
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/openapi.json" {
+		s.mux.ServeHTTP(w, r)
+		return
+	}
+
+	var chains [][]*x509.Certificate
+	if r.TLS != nil {
+		chains = r.TLS.VerifiedChains
+	}
+
+	token := ""
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+
+	id, err := s.policy.Authenticate(token, chains)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	s.mux.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+}
+
+//Error is the body of every response that is not successful
+type Error struct {
+	Message string `json:"message"`
+}
+
+func (s *Server) respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logs.Printf("[ERROR] Failed to encode response: %v", err)
+	}
+}
+
+//fail responds with an error, the status follows from its cause
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch errors.Cause(err) {
+	case model.ErrTaskNotExists, model.ErrNodeNotExists, model.ErrClaimNotExists, model.ErrGangNotExists, model.ErrWorkflowNotExists:
+		status = http.StatusNotFound
+	case model.ErrTaskCancelled, model.ErrTaskNotFinished:
+		status = http.StatusConflict
+	case context.Canceled, context.DeadlineExceeded:
+		status = http.StatusServiceUnavailable
+	case auth.ErrUnauthenticated:
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	case auth.ErrForbidden:
+		status = http.StatusForbidden
+	case model.ErrQuotaExceeded:
+		status = http.StatusTooManyRequests
+	}
+
+	if status == http.StatusInternalServerError {
+		s.logs.Printf("[ERROR] Failed to handle %s %s: %v", r.Method, r.URL.Path, err)
+	}
+
+	s.respond(w, status, Error{Message: err.Error()})
+}
+
+func (s *Server) badRequest(w http.ResponseWriter, msg string) {
+	s.respond(w, http.StatusBadRequest, Error{Message: msg})
+}
+
+func (s *Server) notAllowed(w http.ResponseWriter, allow ...string) {
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	s.respond(w, http.StatusMethodNotAllowed, Error{Message: "method not allowed"})
+}
+
+//splitPath returns the path segments after the prefix, e.g: "/v1/tasks/abc/cancel" => ["abc", "cancel"]
+func splitPath(path, prefix string) []string {
+	rest := strings.Trim(strings.TrimPrefix(path, prefix), "/")
+	if rest == "" {
+		return nil
+	}
+
+	return strings.Split(rest, "/")
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.notAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(OpenAPI))
+}
+
+//SubmitInput is the body of a task submission
+type SubmitInput struct {
+	PoolID    string     `json:"pool_id"`
+	Size      int64      `json:"size"`
+	Priority  string     `json:"priority,omitempty"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+
+	//IdempotencyKey makes retrying the submission safe, a key that was used
+	//before returns the task it submitted
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		in := SubmitInput{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxRequestBodySize)).Decode(&in); err != nil {
+			s.badRequest(w, "failed to decode body: "+err.Error())
+			return
+		}
+
+		if in.PoolID == "" || in.Size < 1 {
+			s.badRequest(w, "pool_id and a size of at least 1 are required")
+			return
+		}
+
+		if !model.ValidPriority(in.Priority) {
+			s.badRequest(w, "priority must be one of: "+strings.Join(model.Priorities, ", "))
+			return
+		}
+
+		if err := auth.Authorize(r.Context(), auth.ActionSubmit, in.PoolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		id, _ := auth.FromContext(r.Context())
+		spec := engine.TaskSpec{PoolID: in.PoolID, Size: in.Size, Submitter: id.Name, Priority: in.Priority, IdempotencyKey: in.IdempotencyKey}
+		if in.NotBefore != nil {
+			spec.NotBefore = *in.NotBefore
+		}
+
+		taskID, err := s.eng.Submit(r.Context(), spec)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		task, err := model.GetTask(r.Context(), s.db, model.TaskPK{TaskID: taskID})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		s.respond(w, http.StatusCreated, taskView(task))
+
+	case http.MethodGet:
+		poolID := r.URL.Query().Get("pool_id")
+		if poolID == "" {
+			s.badRequest(w, "the pool_id query parameter is required")
+			return
+		}
+
+		limit := DefaultTaskListLimit
+		if l := r.URL.Query().Get("limit"); l != "" {
+			var err error
+			if limit, err = strconv.ParseInt(l, 10, 64); err != nil || limit < 1 {
+				s.badRequest(w, "limit must be a positive number")
+				return
+			}
+		}
+
+		if err := auth.Authorize(r.Context(), auth.ActionRead, poolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		tasks, err := model.PoolTasks(r.Context(), s.db, poolID, limit)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		views := []Task{}
+		for _, task := range tasks {
+			views = append(views, taskView(task))
+		}
+
+		s.respond(w, http.StatusOK, views)
+
+	default:
+		s.notAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *Server) handleTask(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(r.URL.Path, "/v1/tasks/")
+	switch {
+	case len(parts) == 1:
+		if r.Method != http.MethodGet {
+			s.notAllowed(w, http.MethodGet)
+			return
+		}
+
+		task, err := s.eng.TaskStatus(r.Context(), parts[0])
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err = auth.Authorize(r.Context(), auth.ActionRead, task.PoolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		s.respond(w, http.StatusOK, taskView(task))
+
+	case len(parts) == 2 && parts[1] == "cancel":
+		if r.Method != http.MethodPost {
+			s.notAllowed(w, http.MethodPost)
+			return
+		}
+
+		task, err := model.GetTask(r.Context(), s.db, model.TaskPK{TaskID: parts[0]})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err = auth.Authorize(r.Context(), auth.ActionCancel, task.PoolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		task, err = s.eng.Cancel(r.Context(), parts[0])
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		s.respond(w, http.StatusOK, taskView(task))
+
+	case len(parts) == 2 && parts[1] == "logs":
+		if r.Method != http.MethodGet {
+			s.notAllowed(w, http.MethodGet)
+			return
+		}
+
+		task, err := model.GetTask(r.Context(), s.db, model.TaskPK{TaskID: parts[0]})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err = auth.Authorize(r.Context(), auth.ActionRead, task.PoolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		logs, err := s.eng.TaskLogs(r.Context(), parts[0])
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(logs))
+
+	default:
+		s.respond(w, http.StatusNotFound, Error{Message: "not found"})
+	}
+}
+
+//BatchInput is the body of a batch submission
+type BatchInput struct {
+	Tasks []SubmitInput `json:"tasks"`
+}
+
+//BatchResult is the outcome of submitting one task of a batch
+type BatchResult struct {
+	TaskID string `json:"task_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (s *Server) handleBatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.notAllowed(w, http.MethodPost)
+		return
+	}
+
+	in := BatchInput{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxRequestBodySize)).Decode(&in); err != nil {
+		s.badRequest(w, "failed to decode body: "+err.Error())
+		return
+	}
+
+	if len(in.Tasks) < 1 || len(in.Tasks) > engine.MaxSubmitBatchSize {
+		s.badRequest(w, fmt.Sprintf("a batch must have between 1 and %d tasks", engine.MaxSubmitBatchSize))
+		return
+	}
+
+	//tasks that are invalid or not allowed fail on their own, the others are
+	//submitted together
+	id, _ := auth.FromContext(r.Context())
+	results, specs, idxs := make([]BatchResult, len(in.Tasks)), []engine.TaskSpec{}, []int{}
+	for i, t := range in.Tasks {
+		switch {
+		case t.PoolID == "" || t.Size < 1:
+			results[i].Error = "pool_id and a size of at least 1 are required"
+			continue
+		case !model.ValidPriority(t.Priority):
+			results[i].Error = "priority must be one of: " + strings.Join(model.Priorities, ", ")
+			continue
+		}
+
+		if err := auth.Authorize(r.Context(), auth.ActionSubmit, t.PoolID); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		spec := engine.TaskSpec{PoolID: t.PoolID, Size: t.Size, Submitter: id.Name, Priority: t.Priority, IdempotencyKey: t.IdempotencyKey}
+		if t.NotBefore != nil {
+			spec.NotBefore = *t.NotBefore
+		}
+
+		specs, idxs = append(specs, spec), append(idxs, i)
+	}
+
+	if len(specs) > 0 {
+		submitted, err := s.eng.SubmitBatch(r.Context(), specs)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		for j, res := range submitted {
+			results[idxs[j]].TaskID = res.TaskID
+			if res.Err != nil {
+				results[idxs[j]].Error = res.Err.Error()
+			}
+		}
+	}
+
+	s.respond(w, http.StatusOK, results)
+}
+
+//GangInput is the body of a gang submission
+type GangInput struct {
+	SubmitInput
+	Tasks int64 `json:"tasks"`
+}
+
+func (s *Server) handleGangs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.notAllowed(w, http.MethodPost)
+		return
+	}
+
+	in := GangInput{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxRequestBodySize)).Decode(&in); err != nil {
+		s.badRequest(w, "failed to decode body: "+err.Error())
+		return
+	}
+
+	if in.PoolID == "" || in.Size < 1 {
+		s.badRequest(w, "pool_id and a size of at least 1 are required")
+		return
+	}
+
+	if in.NotBefore != nil || in.IdempotencyKey != "" {
+		s.badRequest(w, "not_before and idempotency_key are not supported for gangs")
+		return
+	}
+
+	if in.Tasks < 1 || in.Tasks > engine.MaxGangSize {
+		s.badRequest(w, fmt.Sprintf("tasks must be between 1 and %d", engine.MaxGangSize))
+		return
+	}
+
+	if !model.ValidPriority(in.Priority) {
+		s.badRequest(w, "priority must be one of: "+strings.Join(model.Priorities, ", "))
+		return
+	}
+
+	if err := auth.Authorize(r.Context(), auth.ActionSubmit, in.PoolID); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	id, _ := auth.FromContext(r.Context())
+	gangID, _, err := s.eng.SubmitGang(r.Context(), engine.TaskSpec{PoolID: in.PoolID, Size: in.Size, Submitter: id.Name, Priority: in.Priority}, in.Tasks)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	gang, err := model.GetGang(r.Context(), s.db, model.GangPK{GangID: gangID})
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	view, err := s.gangView(r.Context(), gang)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	s.respond(w, http.StatusCreated, view)
+}
+
+func (s *Server) handleGang(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(r.URL.Path, "/v1/gangs/")
+	if len(parts) != 1 {
+		s.respond(w, http.StatusNotFound, Error{Message: "not found"})
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		s.notAllowed(w, http.MethodGet)
+		return
+	}
+
+	gang, err := model.GetGang(r.Context(), s.db, model.GangPK{GangID: parts[0]})
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	if err = auth.Authorize(r.Context(), auth.ActionRead, gang.PoolID); err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	view, err := s.gangView(r.Context(), gang)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	s.respond(w, http.StatusOK, view)
+}
+
+//gangView reads the tasks of the gang
+func (s *Server) gangView(ctx context.Context, gang *model.Gang) (view Gang, err error) {
+	tasks := []*model.Task{}
+	for _, taskID := range gang.Tasks {
+		task, err := model.GetTask(ctx, s.db, model.TaskPK{TaskID: taskID})
+		if err != nil {
+			return view, errors.Wrapf(err, "failed to get task '%s'", taskID)
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return gangView(gang, tasks), nil
+}
+
+//WorkflowTaskInput is a named task of a workflow submission
+type WorkflowTaskInput struct {
+	Name      string   `json:"name"`
+	PoolID    string   `json:"pool_id"`
+	Size      int64    `json:"size"`
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+//WorkflowInput is the body of a workflow submission
+type WorkflowInput struct {
+	Tasks     []WorkflowTaskInput `json:"tasks"`
+	Priority  string              `json:"priority,omitempty"`
+	OnFailure string              `json:"on_failure,omitempty"`
+}
+
+func (s *Server) handleWorkflows(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.notAllowed(w, http.MethodPost)
+		return
+	}
+
+	in := WorkflowInput{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxRequestBodySize)).Decode(&in); err != nil {
+		s.badRequest(w, "failed to decode body: "+err.Error())
+		return
+	}
+
+	if len(in.Tasks) < 1 || len(in.Tasks) > engine.MaxWorkflowTasks {
+		s.badRequest(w, fmt.Sprintf("a workflow must have between 1 and %d tasks", engine.MaxWorkflowTasks))
+		return
+	}
+
+	if !model.ValidPriority(in.Priority) {
+		s.badRequest(w, "priority must be one of: "+strings.Join(model.Priorities, ", "))
+		return
+	}
+
+	if in.OnFailure != "" && in.OnFailure != model.OnFailureFail && in.OnFailure != model.OnFailureSkip {
+		s.badRequest(w, "on_failure must be one of: "+model.OnFailureFail+", "+model.OnFailureSkip)
+		return
+	}
+
+	spec := engine.WorkflowSpec{Priority: in.Priority, OnFailure: in.OnFailure}
+	graph, names := &model.Workflow{}, map[string]bool{}
+	for _, t := range in.Tasks {
+		if t.Name == "" || names[t.Name] || t.PoolID == "" || t.Size < 1 {
+			s.badRequest(w, "every task requires a unique name, a pool_id and a size of at least 1")
+			return
+		}
+
+		names[t.Name] = true
+		graph.Tasks = append(graph.Tasks, &model.WorkflowTask{Name: t.Name, DependsOn: t.DependsOn})
+		spec.Tasks = append(spec.Tasks, engine.WorkflowTaskSpec{Name: t.Name, PoolID: t.PoolID, Size: t.Size, DependsOn: t.DependsOn})
+	}
+
+	if _, err := graph.Sorted(); err != nil {
+		s.badRequest(w, "invalid depends_on: "+err.Error())
+		return
+	}
+
+	for _, t := range in.Tasks {
+		if err := auth.Authorize(r.Context(), auth.ActionSubmit, t.PoolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+	}
+
+	id, _ := auth.FromContext(r.Context())
+	spec.Submitter = id.Name
+	workflowID, err := s.eng.SubmitWorkflow(r.Context(), spec)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	wf, tasks, err := s.eng.WorkflowStatus(r.Context(), workflowID)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	s.respond(w, http.StatusCreated, workflowView(wf, tasks))
+}
+
+func (s *Server) handleWorkflow(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(r.URL.Path, "/v1/workflows/")
+	if len(parts) != 1 {
+		s.respond(w, http.StatusNotFound, Error{Message: "not found"})
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		s.notAllowed(w, http.MethodGet)
+		return
+	}
+
+	wf, tasks, err := s.eng.WorkflowStatus(r.Context(), parts[0])
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	//a workflow may span pools, each of them must be readable
+	for _, wt := range wf.Tasks {
+		if err = auth.Authorize(r.Context(), auth.ActionRead, tasks[wt.Name].PoolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+	}
+
+	s.respond(w, http.StatusOK, workflowView(wf, tasks))
+}
+
+func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.notAllowed(w, http.MethodGet)
+		return
+	}
+
+	poolID := r.URL.Query().Get("pool_id")
+	if poolID != "" {
+		if err := auth.Authorize(r.Context(), auth.ActionRead, poolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+	}
+
+	nodes, err := model.ListNodes(r.Context(), s.db)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	//without a pool only the nodes of pools the caller may read are listed
+	id, _ := auth.FromContext(r.Context())
+	views := []Node{}
+	for _, node := range nodes {
+		if (poolID != "" && node.PoolID != poolID) || !id.Allowed(auth.ActionRead, node.PoolID) {
+			continue
+		}
+
+		views = append(views, nodeView(node))
+	}
+
+	s.respond(w, http.StatusOK, views)
+}
+
+func (s *Server) handleNode(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(r.URL.Path, "/v1/nodes/")
+	switch {
+	case len(parts) == 1:
+		if r.Method != http.MethodGet {
+			s.notAllowed(w, http.MethodGet)
+			return
+		}
+
+		node, err := model.GetNode(r.Context(), s.db, model.NodePK{NodeID: parts[0]})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err = auth.Authorize(r.Context(), auth.ActionRead, node.PoolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		s.respond(w, http.StatusOK, nodeView(node))
+
+	case len(parts) == 2 && parts[1] == "claims":
+		if r.Method != http.MethodGet {
+			s.notAllowed(w, http.MethodGet)
+			return
+		}
+
+		node, err := model.GetNode(r.Context(), s.db, model.NodePK{NodeID: parts[0]})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err = auth.Authorize(r.Context(), auth.ActionRead, node.PoolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		claims, err := model.NodeClaims(r.Context(), s.db, parts[0])
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		views := []Claim{}
+		for _, claim := range claims {
+			views = append(views, claimView(claim))
+		}
+
+		s.respond(w, http.StatusOK, views)
+
+	case len(parts) == 2 && parts[1] == "drain":
+		if r.Method != http.MethodPost {
+			s.notAllowed(w, http.MethodPost)
+			return
+		}
+
+		node, err := model.GetNode(r.Context(), s.db, model.NodePK{NodeID: parts[0]})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err = auth.Authorize(r.Context(), auth.ActionDrain, node.PoolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err = s.eng.Drain(r.Context(), parts[0]); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		node, err = model.GetNode(r.Context(), s.db, model.NodePK{NodeID: parts[0]})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		s.respond(w, http.StatusOK, nodeView(node))
+
+	case len(parts) == 2 && parts[1] == "evict":
+		if r.Method != http.MethodPost {
+			s.notAllowed(w, http.MethodPost)
+			return
+		}
+
+		node, err := model.GetNode(r.Context(), s.db, model.NodePK{NodeID: parts[0]})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err = auth.Authorize(r.Context(), auth.ActionEvict, node.PoolID); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err = s.eng.Evict(r.Context(), parts[0]); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		s.respond(w, http.StatusNotFound, Error{Message: "not found"})
+	}
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"time"
+
+	"github.com/advanderveer/factory/model"
+)
+
+//Task as it is presented by the API
+type Task struct {
+	TaskID     string     `json:"task_id"`
+	PoolID     string     `json:"pool_id"`
+	Size       int64      `json:"size"`
+	State      string     `json:"state"`
+	NodeID     string     `json:"node_id,omitempty"`
+	ClaimID    string     `json:"claim_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Submitter  string     `json:"submitter"`
+	Priority   string     `json:"priority"`
+	GangID     string     `json:"gang_id,omitempty"`
+	WorkflowID string     `json:"workflow_id,omitempty"`
+	NotBefore  *time.Time `json:"not_before,omitempty"`
+	ExitCode   *int64     `json:"exit_code,omitempty"`
+}
+
+func taskView(task *model.Task) Task {
+	view := Task{
+		TaskID:     task.TaskID,
+		PoolID:     task.PoolID,
+		Size:       task.Size,
+		State:      task.State,
+		NodeID:     task.NodeID,
+		ClaimID:    task.ClaimID,
+		CreatedAt:  time.Unix(task.Created, 0).UTC(),
+		Submitter:  task.Submitter,
+		Priority:   priority(task.Priority),
+		GangID:     task.GangID,
+		WorkflowID: task.WorkflowID,
+	}
+
+	if task.NotBefore > 0 {
+		notBefore := time.Unix(task.NotBefore, 0).UTC()
+		view.NotBefore = &notBefore
+	}
+
+	if task.State == model.TaskStateSucceeded || task.State == model.TaskStateFailed {
+		exit := task.Exit
+		view.ExitCode = &exit
+	}
+
+	return view
+}
+
+//priority presents tasks and claims that were recorded without one as normal
+func priority(p string) string {
+	if p == "" {
+		return model.PriorityNormal
+	}
+
+	return p
+}
+
+//Gang as it is presented by the API
+type Gang struct {
+	GangID    string    `json:"gang_id"`
+	PoolID    string    `json:"pool_id"`
+	Size      int64     `json:"size"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+	Submitter string    `json:"submitter"`
+	Priority  string    `json:"priority"`
+	Tasks     []Task    `json:"tasks"`
+}
+
+func gangView(gang *model.Gang, tasks []*model.Task) Gang {
+	view := Gang{
+		GangID:    gang.GangID,
+		PoolID:    gang.PoolID,
+		Size:      gang.Size,
+		State:     gang.State,
+		CreatedAt: time.Unix(gang.Created, 0).UTC(),
+		Submitter: gang.Submitter,
+		Priority:  priority(gang.Priority),
+		Tasks:     []Task{},
+	}
+
+	for _, task := range tasks {
+		view.Tasks = append(view.Tasks, taskView(task))
+	}
+
+	return view
+}
+
+//WorkflowTask is a named task of a workflow as it is presented by the API
+type WorkflowTask struct {
+	Name      string   `json:"name"`
+	DependsOn []string `json:"depends_on"`
+	Task
+}
+
+//Workflow as it is presented by the API, its tasks are ordered such that
+//every task comes after the tasks it depends on
+type Workflow struct {
+	WorkflowID string         `json:"workflow_id"`
+	State      string         `json:"state"`
+	OnFailure  string         `json:"on_failure"`
+	CreatedAt  time.Time      `json:"created_at"`
+	Submitter  string         `json:"submitter"`
+	Tasks      []WorkflowTask `json:"tasks"`
+}
+
+func workflowView(wf *model.Workflow, tasks map[string]*model.Task) Workflow {
+	view := Workflow{
+		WorkflowID: wf.WorkflowID,
+		State:      wf.State,
+		OnFailure:  wf.OnFailure,
+		CreatedAt:  time.Unix(wf.Created, 0).UTC(),
+		Submitter:  wf.Submitter,
+		Tasks:      []WorkflowTask{},
+	}
+
+	sorted, err := wf.Sorted()
+	if err != nil {
+		sorted = wf.Tasks
+	}
+
+	for _, wt := range sorted {
+		deps := wt.DependsOn
+		if deps == nil {
+			deps = []string{}
+		}
+
+		view.Tasks = append(view.Tasks, WorkflowTask{Name: wt.Name, DependsOn: deps, Task: taskView(tasks[wt.Name])})
+	}
+
+	return view
+}
+
+//Node as it is presented by the API
+type Node struct {
+	NodeID    string    `json:"node_id"`
+	PoolID    string    `json:"pool_id"`
+	Host      string    `json:"host"`
+	Cap       int64     `json:"cap"`
+	Max       int64     `json:"max"`
+	Drain     bool      `json:"drain"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func nodeView(node *model.Node) Node {
+	return Node{
+		NodeID:    node.NodeID,
+		PoolID:    node.PoolID,
+		Host:      node.Host,
+		Cap:       node.Cap,
+		Max:       node.Max,
+		Drain:     node.Drain,
+		ExpiresAt: time.Unix(node.TTL, 0).UTC(),
+	}
+}
+
+//Claim as it is presented by the API
+type Claim struct {
+	ClaimID   string    `json:"claim_id"`
+	TaskID    string    `json:"task_id"`
+	PoolID    string    `json:"pool_id"`
+	NodeID    string    `json:"node_id"`
+	Size      int64     `json:"size"`
+	Submitter string    `json:"submitter"`
+	Priority  string    `json:"priority"`
+	State     string    `json:"state"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func claimView(claim *model.Claim) Claim {
+	state := claim.State
+	if state == "" {
+		state = model.ClaimStateDispatched
+	}
+
+	return Claim{
+		ClaimID:   claim.ClaimID,
+		TaskID:    claim.TaskID,
+		PoolID:    claim.PoolID,
+		NodeID:    claim.NodeID,
+		Size:      claim.Size,
+		Submitter: claim.Submitter,
+		Priority:  priority(claim.Priority),
+		State:     state,
+		ExpiresAt: time.Unix(claim.TTL, 0).UTC(),
+	}
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	//ActionSubmit allows submitting tasks to a pool
+	ActionSubmit = "submit"
+
+	//ActionCancel allows cancelling the tasks of a pool
+	ActionCancel = "cancel"
+
+	//ActionDrain allows draining the nodes of a pool
+	ActionDrain = "drain"
+
+	//ActionEvict allows evicting the claims of the nodes of a pool
+	ActionEvict = "evict"
+
+	//ActionRead allows reading the tasks, logs, nodes and claims of a pool
+	ActionRead = "read"
+
+	//AnyPool grants an action on every pool
+	AnyPool = "*"
+
+	//Anonymous is the identity of callers when the API is served without a policy
+	Anonymous = "anonymous"
+)
+
+var (
+	//ErrUnauthenticated is returned when the caller presented no known token or certificate
+	ErrUnauthenticated = errors.New("unauthenticated")
+
+	//ErrForbidden is returned when the caller's identity is not granted the action on the pool
+	ErrForbidden = errors.New("forbidden")
+)
+
+//Grant allows the actions on the pools
+type Grant struct {
+	Pools   []string `json:"pools"`
+	Actions []string `json:"actions"`
+}
+
+//Identity is a caller of the API. It authenticates with a bearer token, of
+//which only the hex encoded SHA-256 hash is configured, or with a client
+//certificate whose subject common name matches.
+type Identity struct {
+	Name        string  `json:"name"`
+	TokenSHA256 string  `json:"token_sha256,omitempty"`
+	CertCN      string  `json:"cert_cn,omitempty"`
+	Grants      []Grant `json:"grants"`
+}
+
+//Allowed returns whether the identity is granted the action on the pool, a
+//nil identity is granted nothing
+func (id *Identity) Allowed(action, poolID string) bool {
+	if id == nil {
+		return false
+	}
+
+	for _, g := range id.Grants {
+		if contains(g.Actions, action) && (contains(g.Pools, poolID) || contains(g.Pools, AnyPool)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Policy holds the identities that may call the API and what they are granted
+type Policy struct {
+	Identities []*Identity `json:"identities"`
+}
+
+//LoadPolicy reads a policy from a JSON file
+func LoadPolicy(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open policy file")
+	}
+
+	defer f.Close()
+	p := &Policy{}
+	if err = json.NewDecoder(f).Decode(p); err != nil {
+		return nil, errors.Wrap(err, "failed to decode policy")
+	}
+
+	for _, id := range p.Identities {
+		if id.Name == "" {
+			return nil, errors.New("policy has an identity without a name")
+		}
+
+		if id.TokenSHA256 == "" && id.CertCN == "" {
+			return nil, errors.Errorf("identity '%s' has neither a token hash nor a certificate common name", id.Name)
+		}
+
+		if id.TokenSHA256 != "" {
+			if h, err := hex.DecodeString(id.TokenSHA256); err != nil || len(h) != sha256.Size {
+				return nil, errors.Errorf("identity '%s' has a token hash that is not a hex encoded SHA-256", id.Name)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+//Authenticate returns the identity of the caller that presented the bearer
+//token or, if it has no token, the verified certificate chains. A nil policy
+//is only used when the API is explicitly served without authentication, it
+//enforces nothing and every caller is granted everything as Anonymous.
+func (p *Policy) Authenticate(token string, chains [][]*x509.Certificate) (*Identity, error) {
+	if p == nil {
+		return &Identity{Name: Anonymous, Grants: []Grant{{
+			Pools:   []string{AnyPool},
+			Actions: []string{ActionSubmit, ActionCancel, ActionDrain, ActionEvict, ActionRead},
+		}}}, nil
+	}
+
+	if token != "" {
+		sum := sha256.Sum256([]byte(token))
+		hash := hex.EncodeToString(sum[:])
+		for _, id := range p.Identities {
+			if id.TokenSHA256 != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(id.TokenSHA256)) == 1 {
+				return id, nil
+			}
+		}
+
+		return nil, ErrUnauthenticated
+	}
+
+	for _, chain := range chains {
+		if len(chain) < 1 {
+			continue
+		}
+
+		for _, id := range p.Identities {
+			if id.CertCN != "" && id.CertCN == chain[0].Subject.CommonName {
+				return id, nil
+			}
+		}
+	}
+
+	return nil, ErrUnauthenticated
+}
+
+type ctxKey struct{}
+
+//WithIdentity returns a context that carries the authenticated identity
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+//FromContext returns the authenticated identity carried by the context, if any
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(ctxKey{}).(*Identity)
+	return id, ok
+}
+
+//Authorize returns an error unless the identity in the context is granted
+//the action on the pool
+func Authorize(ctx context.Context, action, poolID string) error {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	if !id.Allowed(action, poolID) {
+		return errors.Wrapf(ErrForbidden, "'%s' may not %s on pool '%s'", id.Name, action, poolID)
+	}
+
+	return nil
+}
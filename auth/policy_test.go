@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"testing"
+)
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func certChain(cn string) []*x509.Certificate {
+	return []*x509.Certificate{{Subject: pkix.Name{CommonName: cn}}}
+}
+
+func TestAuthenticate(t *testing.T) {
+	alice := &Identity{Name: "alice", TokenSHA256: hashToken("s3cret")}
+	bob := &Identity{Name: "bob", CertCN: "bob.example.com"}
+	carol := &Identity{Name: "carol", TokenSHA256: hashToken("other"), CertCN: "carol.example.com"}
+	policy := &Policy{Identities: []*Identity{alice, bob, carol}}
+
+	for _, c := range []struct {
+		name   string
+		policy *Policy
+		token  string
+		chains [][]*x509.Certificate
+		expID  string
+		expErr error
+	}{
+		{name: "token matches hash", policy: policy, token: "s3cret", expID: "alice"},
+		{name: "token of identity with both", policy: policy, token: "other", expID: "carol"},
+		{name: "unknown token", policy: policy, token: "guess", expErr: ErrUnauthenticated},
+		{name: "token is not compared to the hash itself", policy: policy, token: hashToken("s3cret"), expErr: ErrUnauthenticated},
+		{name: "token takes precedence over cert", policy: policy, token: "guess", chains: [][]*x509.Certificate{certChain("bob.example.com")}, expErr: ErrUnauthenticated},
+		{name: "cert cn matches", policy: policy, chains: [][]*x509.Certificate{certChain("bob.example.com")}, expID: "bob"},
+		{name: "cert cn of a later chain matches", policy: policy, chains: [][]*x509.Certificate{{}, certChain("nobody"), certChain("carol.example.com")}, expID: "carol"},
+		{name: "cert cn must match exactly", policy: policy, chains: [][]*x509.Certificate{certChain("BOB.example.com")}, expErr: ErrUnauthenticated},
+		{name: "identity without cn isn't matched by an empty cn", policy: policy, chains: [][]*x509.Certificate{certChain("")}, expErr: ErrUnauthenticated},
+		{name: "no credentials", policy: policy, expErr: ErrUnauthenticated},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			id, err := c.policy.Authenticate(c.token, c.chains)
+			if err != c.expErr {
+				t.Fatalf("expected error %v, got: %v", c.expErr, err)
+			}
+
+			if c.expErr != nil {
+				return
+			}
+
+			if id.Name != c.expID {
+				t.Fatalf("expected identity '%s', got: '%s'", c.expID, id.Name)
+			}
+		})
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	scoped := &Identity{Name: "scoped", Grants: []Grant{
+		{Pools: []string{"pool-a", "pool-b"}, Actions: []string{ActionSubmit}},
+		{Pools: []string{"pool-b"}, Actions: []string{ActionCancel}},
+	}}
+
+	operator := &Identity{Name: "operator", Grants: []Grant{
+		{Pools: []string{AnyPool}, Actions: []string{ActionDrain, ActionEvict}},
+	}}
+
+	reader := &Identity{Name: "reader", Grants: []Grant{
+		{Pools: []string{"pool-a"}, Actions: []string{ActionRead}},
+	}}
+
+	for _, c := range []struct {
+		name   string
+		id     *Identity
+		action string
+		poolID string
+		exp    bool
+	}{
+		{"granted action on listed pool", scoped, ActionSubmit, "pool-a", true},
+		{"granted action on other listed pool", scoped, ActionSubmit, "pool-b", true},
+		{"granted action on unlisted pool", scoped, ActionSubmit, "pool-c", false},
+		{"action of another grant on its pool", scoped, ActionCancel, "pool-b", true},
+		{"grants don't combine across pools", scoped, ActionCancel, "pool-a", false},
+		{"action that is never granted", scoped, ActionDrain, "pool-a", false},
+		{"any pool", operator, ActionDrain, "pool-z", true},
+		{"any pool is limited to its actions", operator, ActionSubmit, "pool-z", false},
+		{"no grants", &Identity{Name: "none"}, ActionSubmit, "pool-a", false},
+		{"unknown action", operator, "delete", "pool-a", false},
+		{"read on its pool", reader, ActionRead, "pool-a", true},
+		{"read on another pool", reader, ActionRead, "pool-b", false},
+		{"read doesn't grant submit", reader, ActionSubmit, "pool-a", false},
+		{"submit doesn't grant read", scoped, ActionRead, "pool-a", false},
+		{"nil identity", nil, ActionRead, "pool-a", false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if act := c.id.Allowed(c.action, c.poolID); act != c.exp {
+				t.Fatalf("expected allowed to be %v, got: %v", c.exp, act)
+			}
+		})
+	}
+}
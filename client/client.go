@@ -0,0 +1,155 @@
+//Package client submits tasks to the factory and tracks them until they
+//finish. It talks either directly to the backing tables and queues or to
+//the API server ('factory api'):
+//
+//	c := client.NewHTTP("http://factory.internal:8080", nil)
+//	h, err := c.Submit(ctx, client.TaskSpec{PoolID: "my-pool", Size: 1})
+//	...
+//	task, err := h.Wait(ctx)
+//
+//Errors can be checked against their cause in the model and auth packages,
+//e.g: errors.Cause(err) == model.ErrTaskNotExists
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	//WaitPollInterval determines how often a handle polls the task while waiting for it to finish
+	WaitPollInterval = time.Second * 2
+)
+
+//TaskSpec describes a task that is to be submitted
+type TaskSpec struct {
+	PoolID   string `json:"pool_id"`
+	Size     int64  `json:"size"`
+	Priority string `json:"priority,omitempty"`
+
+	//Submitter is recorded on the task by the direct client, the API server
+	//records the identity the client authenticated as instead
+	Submitter string `json:"-"`
+
+	//IdempotencyKey is optional, submitting again with the same key returns
+	//the task of the first submission so that retries are safe
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+//Task is the status of a submitted task
+type Task struct {
+	TaskID    string    `json:"task_id"`
+	PoolID    string    `json:"pool_id"`
+	Size      int64     `json:"size"`
+	State     string    `json:"state"`
+	NodeID    string    `json:"node_id,omitempty"`
+	ClaimID   string    `json:"claim_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Submitter string    `json:"submitter"`
+	Priority  string    `json:"priority"`
+	ExitCode  *int64    `json:"exit_code,omitempty"`
+}
+
+//Finished returns whether the task reached a state it won't leave
+func (t *Task) Finished() bool {
+	return (&model.Task{State: t.State}).Finished()
+}
+
+//backend is what the client talks to
+type backend interface {
+	submit(ctx context.Context, spec TaskSpec) (*Task, error)
+	get(ctx context.Context, taskID string) (*Task, error)
+	cancel(ctx context.Context, taskID string) (*Task, error)
+	logs(ctx context.Context, taskID string) (string, error)
+}
+
+//Client submits tasks and returns handles to track them
+type Client struct {
+	b backend
+}
+
+//Submit submits a task and returns a handle to it
+func (c *Client) Submit(ctx context.Context, spec TaskSpec) (*Handle, error) {
+	if spec.PoolID == "" || spec.Size < 1 {
+		return nil, errors.New("a pool id and a size of at least 1 are required")
+	}
+
+	task, err := c.b.submit(ctx, spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to submit task")
+	}
+
+	return &Handle{c: c, taskID: task.TaskID}, nil
+}
+
+//Task returns a handle to a task that was submitted earlier
+func (c *Client) Task(taskID string) *Handle {
+	return &Handle{c: c, taskID: taskID}
+}
+
+//Handle tracks a submitted task
+type Handle struct {
+	c      *Client
+	taskID string
+}
+
+//ID returns the id of the task
+func (h *Handle) ID() string {
+	return h.taskID
+}
+
+//Status returns the task as it currently stands
+func (h *Handle) Status(ctx context.Context) (*Task, error) {
+	task, err := h.c.b.get(ctx, h.taskID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get task")
+	}
+
+	return task, nil
+}
+
+//Wait blocks until the task has finished, i.e: it succeeded, failed or was
+//cancelled, and returns it. It returns early when ctx is done.
+func (h *Handle) Wait(ctx context.Context) (*Task, error) {
+	ticker := time.NewTicker(WaitPollInterval)
+	defer ticker.Stop()
+	for {
+		task, err := h.Status(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if task.Finished() {
+			return task, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+//Cancel stops the task from being (re)scheduled and stops its container
+func (h *Handle) Cancel(ctx context.Context) (*Task, error) {
+	task, err := h.c.b.cancel(ctx, h.taskID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to cancel task")
+	}
+
+	return task, nil
+}
+
+//Logs returns the tail of the logs of the task once it succeeded or failed
+func (h *Handle) Logs(ctx context.Context) (string, error) {
+	logs, err := h.c.b.logs(ctx, h.taskID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get task logs")
+	}
+
+	return logs, nil
+}
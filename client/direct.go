@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/advanderveer/factory/engine"
+	"github.com/advanderveer/factory/model"
+)
+
+//NewDirect creates a client that uses the tables and queues directly, this
+//requires credentials that can write to them
+func NewDirect(logs engine.Logger, db model.DB, q engine.Q) *Client {
+	return &Client{b: &direct{eng: engine.New(logs, db, q)}}
+}
+
+//direct runs the engine in-process
+type direct struct {
+	eng *engine.Engine
+}
+
+func taskStatus(task *model.Task) *Task {
+	status := &Task{
+		TaskID:    task.TaskID,
+		PoolID:    task.PoolID,
+		Size:      task.Size,
+		State:     task.State,
+		NodeID:    task.NodeID,
+		ClaimID:   task.ClaimID,
+		CreatedAt: time.Unix(task.Created, 0).UTC(),
+		Submitter: task.Submitter,
+		Priority:  task.Priority,
+	}
+
+	if task.State == model.TaskStateSucceeded || task.State == model.TaskStateFailed {
+		exit := task.Exit
+		status.ExitCode = &exit
+	}
+
+	return status
+}
+
+func (d *direct) submit(ctx context.Context, spec TaskSpec) (*Task, error) {
+	taskID, err := d.eng.Submit(ctx, engine.TaskSpec{PoolID: spec.PoolID, Size: spec.Size, Submitter: spec.Submitter, Priority: spec.Priority, IdempotencyKey: spec.IdempotencyKey})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.get(ctx, taskID)
+}
+
+func (d *direct) get(ctx context.Context, taskID string) (*Task, error) {
+	task, err := d.eng.TaskStatus(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	return taskStatus(task), nil
+}
+
+func (d *direct) cancel(ctx context.Context, taskID string) (*Task, error) {
+	task, err := d.eng.Cancel(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	return taskStatus(task), nil
+}
+
+func (d *direct) logs(ctx context.Context, taskID string) (string, error) {
+	return d.eng.TaskLogs(ctx, taskID)
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/advanderveer/factory/auth"
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+//NewHTTP creates a client that talks to the API server at the endpoint, e.g:
+//"http://localhost:8080". If hc is nil the default http client is used, a
+//client certificate can be configured on its transport.
+func NewHTTP(endpoint string, hc *http.Client) *Client {
+	return NewHTTPWithToken(endpoint, "", hc)
+}
+
+//NewHTTPWithToken creates a client that authenticates to the API server with
+//a bearer token
+func NewHTTPWithToken(endpoint, token string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	return &Client{b: &remote{endpoint: strings.TrimRight(endpoint, "/"), token: token, hc: hc}}
+}
+
+//remote talks to the API server
+type remote struct {
+	endpoint string
+	token    string
+	hc       *http.Client
+}
+
+//apiError is the body of responses that are not successful
+type apiError struct {
+	Message string `json:"message"`
+}
+
+//do sends a request and returns the body of a successful response, the
+//error of an unsuccessful one is mapped back onto the model's errors
+func (r *remote) do(ctx context.Context, method, path string, in interface{}, notFound, conflict error) (io.ReadCloser, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal request")
+		}
+
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, r.endpoint+path, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	resp, err := r.hc.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send request")
+	}
+
+	if resp.StatusCode < 300 {
+		return resp.Body, nil
+	}
+
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound && notFound != nil:
+		return nil, notFound
+	case resp.StatusCode == http.StatusConflict && conflict != nil:
+		return nil, conflict
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, auth.ErrUnauthenticated
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, auth.ErrForbidden
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, model.ErrQuotaExceeded
+	}
+
+	aerr := apiError{}
+	data, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, &aerr); err != nil || aerr.Message == "" {
+		aerr.Message = strings.TrimSpace(string(data))
+	}
+
+	return nil, errors.Errorf("unexpected response '%s': %s", resp.Status, aerr.Message)
+}
+
+func (r *remote) task(ctx context.Context, method, path string, in interface{}, conflict error) (*Task, error) {
+	body, err := r.do(ctx, method, path, in, model.ErrTaskNotExists, conflict)
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+	task := &Task{}
+	if err = json.NewDecoder(body).Decode(task); err != nil {
+		return nil, errors.Wrap(err, "failed to decode task")
+	}
+
+	return task, nil
+}
+
+func (r *remote) submit(ctx context.Context, spec TaskSpec) (*Task, error) {
+	return r.task(ctx, http.MethodPost, "/v1/tasks", spec, nil)
+}
+
+func (r *remote) get(ctx context.Context, taskID string) (*Task, error) {
+	return r.task(ctx, http.MethodGet, "/v1/tasks/"+url.PathEscape(taskID), nil, nil)
+}
+
+func (r *remote) cancel(ctx context.Context, taskID string) (*Task, error) {
+	return r.task(ctx, http.MethodPost, "/v1/tasks/"+url.PathEscape(taskID)+"/cancel", nil, model.ErrTaskCancelled)
+}
+
+func (r *remote) logs(ctx context.Context, taskID string) (string, error) {
+	body, err := r.do(ctx, http.MethodGet, "/v1/tasks/"+url.PathEscape(taskID)+"/logs", nil, model.ErrTaskNotExists, model.ErrTaskNotFinished)
+	if err != nil {
+		return "", err
+	}
+
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read logs")
+	}
+
+	return string(data), nil
+}
@@ -14,20 +14,33 @@ import (
 	"github.com/pkg/errors"
 )
 
+//AgentFlags configure the node agent
+type AgentFlags struct {
+	NodeID   string `long:"node-id" description:"Register as this node, taking back its record if it expired or belongs to this host"`
+	HostID   string `long:"host-id" description:"Identity of this host, defaults to the hostname"`
+	StateDir string `long:"state-dir" description:"Directory in which the agent persists its node id to take it back after a restart"`
+}
+
 //Agent command
 type Agent struct {
 	*command
 
-	awsFlags   AWSFlags
-	debugFlags DebugFlags
+	agentFlags   AgentFlags
+	awsFlags     AWSFlags
+	debugFlags   DebugFlags
+	metricsFlags MetricsFlags
+	traceFlags   TraceFlags
 }
 
 //AgentFactory creates the command
 func AgentFactory() cli.CommandFactory {
 	cmd := &Agent{}
 	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("Agent Flags", "Agent Flags", &cmd.agentFlags)
 	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
 	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+	cmd.command.flagParser.AddGroup("Trace Flags", "Trace Flags", &cmd.traceFlags)
+	cmd.command.flagParser.AddGroup("Metrics Flags", "Metrics Flags", &cmd.metricsFlags)
 
 	return func() (cli.Command, error) {
 		return cmd, nil
@@ -55,6 +68,21 @@ func (cmd *Agent) Execute(args []string) (err error) {
 	}
 
 	logs := cmd.debugFlags.Logger()
+	shutdownTracing, err := cmd.traceFlags.Setup("agent")
+	if err != nil {
+		return errors.Wrap(err, "failed to setup tracing")
+	}
+
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logs.Printf("[ERROR] Failed to flush traces: %v", err)
+		}
+	}()
+
+	if err = cmd.metricsFlags.Serve(logs); err != nil {
+		return errors.Wrap(err, "failed to serve metrics")
+	}
+
 	sigCh := make(chan os.Signal)
 	signal.Notify(sigCh, os.Interrupt)
 	ctx := context.Background()
@@ -67,10 +95,16 @@ func (cmd *Agent) Execute(args []string) (err error) {
 		}
 	}()
 
+	conf := engine.AgentConfig{
+		NodeID:   cmd.agentFlags.NodeID,
+		HostID:   cmd.agentFlags.HostID,
+		StateDir: cmd.agentFlags.StateDir,
+	}
+
 	db := dynamodb.New(awss)
 	q := sqs.New(awss)
 	engine := engine.New(logs, db, q)
-	if err = engine.Agent(ctx, args[0]); err != nil {
+	if err = engine.Agent(ctx, args[0], conf); err != nil {
 		return errors.Wrap(err, "failed to run agent")
 	}
 
@@ -0,0 +1,236 @@
+package command
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/advanderveer/factory/api"
+	"github.com/advanderveer/factory/auth"
+	"github.com/advanderveer/factory/engine"
+	"github.com/advanderveer/factory/rpc"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	//MaxAPIShutdownTime determines how long in-flight requests get to complete on shutdown
+	MaxAPIShutdownTime = time.Second * 10
+)
+
+//APIFlags configure the api server
+type APIFlags struct {
+	Addr     string `long:"api-addr" default:":8080" description:"Address on which the HTTP API is served"`
+	GRPCAddr string `long:"grpc-addr" description:"Address on which the gRPC API is served, e.g: ':9090'"`
+
+	PolicyFile  string `long:"policy-file" description:"JSON file with the identities that may call the API and what they are granted, required unless --insecure is set"`
+	Insecure    bool   `long:"insecure" description:"Serve the APIs without a policy file, every caller may then submit to and manage every pool"`
+	TLSCert     string `long:"tls-cert" description:"Certificate file to serve the APIs over TLS with"`
+	TLSKey      string `long:"tls-key" description:"Key file of the TLS certificate"`
+	TLSClientCA string `long:"tls-client-ca" description:"CA file to verify client certificates with, clients may then authenticate with a certificate"`
+}
+
+//TLSConfig returns the configuration to serve the APIs with, it is nil if no
+//certificate is configured
+func (f APIFlags) TLSConfig() (*tls.Config, error) {
+	if f.TLSCert == "" && f.TLSKey == "" {
+		if f.TLSClientCA != "" {
+			return nil, errors.New("a client CA requires a TLS certificate and key")
+		}
+
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.TLSCert, f.TLSKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load tls certificate")
+	}
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if f.TLSClientCA != "" {
+		data, err := ioutil.ReadFile(f.TLSClientCA)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read client ca")
+		}
+
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, errors.New("client ca file contains no certificates")
+		}
+
+		//tokens remain an option for clients without a certificate
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return cfg, nil
+}
+
+//API command
+type API struct {
+	*command
+
+	apiFlags     APIFlags
+	awsFlags     AWSFlags
+	debugFlags   DebugFlags
+	metricsFlags MetricsFlags
+	traceFlags   TraceFlags
+}
+
+//APIFactory creates the command
+func APIFactory() cli.CommandFactory {
+	cmd := &API{}
+	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("API Flags", "API Flags", &cmd.apiFlags)
+	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
+	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+	cmd.command.flagParser.AddGroup("Trace Flags", "Trace Flags", &cmd.traceFlags)
+	cmd.command.flagParser.AddGroup("Metrics Flags", "Metrics Flags", &cmd.metricsFlags)
+
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *API) Execute(args []string) (err error) {
+	awsopts := session.Options{}
+	if cmd.awsFlags.Profile != "" {
+		awsopts.Profile = cmd.awsFlags.Profile
+	}
+
+	if cmd.awsFlags.Region != "" {
+		awsopts.Config = aws.Config{Region: aws.String(cmd.awsFlags.Region)}
+	}
+
+	var awss *session.Session
+	if awss, err = session.NewSessionWithOptions(awsopts); err != nil {
+		return errors.Wrap(err, "failed to create aws session")
+	}
+
+	logs := cmd.debugFlags.Logger()
+	shutdownTracing, err := cmd.traceFlags.Setup("api")
+	if err != nil {
+		return errors.Wrap(err, "failed to setup tracing")
+	}
+
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logs.Printf("[ERROR] Failed to flush traces: %v", err)
+		}
+	}()
+
+	if err = cmd.metricsFlags.Serve(logs); err != nil {
+		return errors.Wrap(err, "failed to serve metrics")
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	ctx := context.Background()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		for s := range sigCh {
+			logs.Printf("[INFO] Received %s, shutting down", s)
+			stop()
+		}
+	}()
+
+	var policy *auth.Policy
+	switch {
+	case cmd.apiFlags.PolicyFile != "" && cmd.apiFlags.Insecure:
+		return errors.New("a policy file can't be combined with --insecure")
+	case cmd.apiFlags.PolicyFile != "":
+		if policy, err = auth.LoadPolicy(cmd.apiFlags.PolicyFile); err != nil {
+			return errors.Wrap(err, "failed to load policy")
+		}
+	case cmd.apiFlags.Insecure:
+		logs.Printf("[WARN] Serving without a policy as --insecure is set, every caller may submit to and manage every pool")
+	default:
+		return errors.New("a policy file is required to serve the API, set --insecure to serve it without authentication")
+	}
+
+	tlsConfig, err := cmd.apiFlags.TLSConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to configure tls")
+	}
+
+	db := dynamodb.New(awss)
+	q := sqs.New(awss)
+	ln, err := net.Listen("tcp", cmd.apiFlags.Addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for api")
+	}
+
+	eng := engine.New(logs, db, q)
+	if cmd.apiFlags.GRPCAddr != "" {
+		gln, err := net.Listen("tcp", cmd.apiFlags.GRPCAddr)
+		if err != nil {
+			return errors.Wrap(err, "failed to listen for grpc")
+		}
+
+		rsrv := rpc.NewServer(logs, eng, db, policy)
+		gopts := []grpc.ServerOption{
+			grpc.UnaryInterceptor(rsrv.UnaryInterceptor()),
+			grpc.StreamInterceptor(rsrv.StreamInterceptor()),
+		}
+
+		if tlsConfig != nil {
+			gopts = append(gopts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+
+		gsrv := grpc.NewServer(gopts...)
+		rpc.RegisterFactoryServer(gsrv, rsrv)
+		go func() {
+			logs.Printf("[INFO] Serving grpc on '%s'", gln.Addr())
+			if err := gsrv.Serve(gln); err != nil {
+				logs.Printf("[ERROR] Failed to serve grpc: %v", err)
+			}
+		}()
+
+		defer gsrv.Stop() //watch streams only end when their client leaves
+	}
+
+	srv := &http.Server{Handler: api.NewServer(logs, eng, db, policy), TLSConfig: tlsConfig}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), MaxAPIShutdownTime)
+		defer cancel()
+		if err := srv.Shutdown(sctx); err != nil {
+			logs.Printf("[ERROR] Failed to shutdown api server: %v", err)
+		}
+	}()
+
+	logs.Printf("[INFO] Serving api on '%s'", ln.Addr())
+	if tlsConfig != nil {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		return errors.Wrap(err, "failed to serve api")
+	}
+
+	return nil
+}
+
+// Description returns long-form help text
+func (cmd *API) Description() string { return "<help>" }
+
+// Synopsis returns a one-line
+func (cmd *API) Synopsis() string { return "<synopsis>" }
+
+// Usage shows usage
+func (cmd *API) Usage() string { return "factory api" }
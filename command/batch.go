@@ -0,0 +1,218 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/factory/engine"
+	"github.com/pkg/errors"
+)
+
+//BatchFlags configure the submission of a file of tasks
+type BatchFlags struct {
+	Batch       string `long:"batch" description:"Path to a JSONL file with a task per line that are submitted in batches, e.g: '{\"pool_id\": \"my-pool\", \"size\": 1}'"`
+	Concurrency int    `long:"concurrency" default:"4" description:"Max number of batches that are submitted at the same time"`
+	RetryFile   string `long:"retry-file" description:"Path the lines of tasks that failed or were not submitted before an interrupt are written to, defaults to the batch file with a '.retry' suffix"`
+}
+
+//BatchTask is a line of a batch file
+type BatchTask struct {
+	PoolID         string     `json:"pool_id"`
+	Size           int64      `json:"size"`
+	Priority       string     `json:"priority,omitempty"`
+	NotBefore      *time.Time `json:"not_before,omitempty"`
+	IdempotencyKey string     `json:"idempotency_key,omitempty"`
+}
+
+//batchLine is a line of the batch file and its number
+type batchLine struct {
+	nr   int
+	text string
+}
+
+//Run streams the batch file and submits its tasks in batches of at most
+//engine.MaxSubmitBatchSize. The id of every submitted task is printed with the
+//number of its line, the lines of tasks that failed are written to the retry
+//file so that it can be submitted again. When interrupted, the lines that were
+//not yet handed to a worker are written to it as well.
+func (f BatchFlags) Run(ctx context.Context, eng *engine.Engine, submitter string) (err error) {
+	if f.Concurrency < 1 {
+		return errors.New("--concurrency must be at least 1")
+	}
+
+	file, err := os.Open(f.Batch)
+	if err != nil {
+		return errors.Wrap(err, "failed to open batch file")
+	}
+
+	defer file.Close()
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		submitted int
+		failed    []batchLine
+		batches   = make(chan []batchLine)
+		start     = time.Now()
+		progress  = time.NewTicker(time.Second)
+	)
+
+	defer progress.Stop()
+	for i := 0; i < f.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lines := range batches {
+				ok, bad := f.submit(ctx, eng, submitter, lines)
+
+				mu.Lock()
+				submitted += len(ok)
+				failed = append(failed, bad...)
+				for _, line := range ok {
+					fmt.Printf("%d\t%s\n", line.nr, line.text)
+				}
+
+				mu.Unlock()
+			}
+		}()
+	}
+
+	//handed is the number of the last line that was handed to a worker
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lines, nr, handed := []batchLine{}, 0, 0
+	for ctx.Err() == nil && scanner.Scan() {
+		nr++
+		text := strings.TrimSpace(scanner.Text())
+		if text != "" {
+			lines = append(lines, batchLine{nr: nr, text: text})
+		}
+
+		if len(lines) < engine.MaxSubmitBatchSize {
+			continue
+		}
+
+		select {
+		case batches <- lines:
+			lines, handed = []batchLine{}, nr
+		case <-ctx.Done():
+			continue
+		}
+
+		select {
+		case <-progress.C:
+			mu.Lock()
+			fmt.Fprintf(os.Stderr, "read %d lines, submitted %d tasks, %d failed (%s)\n", nr, submitted, len(failed), time.Since(start).Round(time.Second))
+			mu.Unlock()
+		default:
+		}
+	}
+
+	//when interrupted, the lines that were read but not handed to a worker
+	//and those that follow them are retried so the retry file finishes the job
+	var unhanded []batchLine
+	if ctx.Err() != nil {
+		unhanded = lines
+		for scanner.Scan() {
+			nr++
+			if text := strings.TrimSpace(scanner.Text()); text != "" {
+				unhanded = append(unhanded, batchLine{nr: nr, text: text})
+			}
+		}
+	} else if len(lines) > 0 {
+		batches <- lines
+		handed = nr
+	}
+
+	close(batches)
+	wg.Wait()
+	if err = scanner.Err(); err != nil {
+		return errors.Wrap(err, "failed to read batch file")
+	}
+
+	fmt.Fprintf(os.Stderr, "submitted %d tasks, %d failed (%s)\n", submitted, len(failed), time.Since(start).Round(time.Second))
+	if retry := append(failed, unhanded...); len(retry) > 0 {
+		retryFile := f.RetryFile
+		if retryFile == "" {
+			retryFile = f.Batch + ".retry"
+		}
+
+		sort.Slice(retry, func(i, j int) bool { return retry[i].nr < retry[j].nr })
+		buf := bytes.NewBuffer(nil)
+		for _, line := range retry {
+			fmt.Fprintln(buf, line.text)
+		}
+
+		if err = ioutil.WriteFile(retryFile, buf.Bytes(), 0644); err != nil {
+			return errors.Wrap(err, "failed to write retry file")
+		}
+
+		fmt.Fprintf(os.Stderr, "the lines of the %d tasks that failed or were not submitted were written to '%s'\n", len(retry), retryFile)
+	}
+
+	if ctx.Err() != nil {
+		return errors.Wrapf(ctx.Err(), "interrupted after line %d was handed out, the %d lines that were not submitted are in the retry file", handed, len(unhanded))
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("%d tasks failed", len(failed))
+	}
+
+	return nil
+}
+
+//submit decodes the lines and submits them as one batch, it returns the
+//lines that were submitted with their text replaced by the task id and the
+//lines that failed as they were
+func (f BatchFlags) submit(ctx context.Context, eng *engine.Engine, submitter string, lines []batchLine) (ok, failed []batchLine) {
+	specs, specLines := []engine.TaskSpec{}, []batchLine{}
+	for _, line := range lines {
+		bt := BatchTask{Size: 1}
+		dec := json.NewDecoder(strings.NewReader(line.text))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&bt); err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: failed to decode task: %v\n", line.nr, err)
+			failed = append(failed, line)
+			continue
+		}
+
+		spec := engine.TaskSpec{PoolID: bt.PoolID, Size: bt.Size, Priority: bt.Priority, Submitter: submitter, IdempotencyKey: bt.IdempotencyKey}
+		if bt.NotBefore != nil {
+			spec.NotBefore = *bt.NotBefore
+		}
+
+		specs, specLines = append(specs, spec), append(specLines, line)
+	}
+
+	if len(specs) < 1 {
+		return ok, failed
+	}
+
+	results, err := eng.SubmitBatch(ctx, specs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lines %d-%d: failed to submit batch: %v\n", specLines[0].nr, specLines[len(specLines)-1].nr, err)
+		return ok, append(failed, specLines...)
+	}
+
+	for i, res := range results {
+		line := specLines[i]
+		switch {
+		case res.Err != nil:
+			fmt.Fprintf(os.Stderr, "line %d: failed to submit task: %v\n", line.nr, res.Err)
+			failed = append(failed, line)
+		default:
+			ok = append(ok, batchLine{nr: line.nr, text: res.TaskID})
+		}
+	}
+
+	return ok, failed
+}
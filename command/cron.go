@@ -0,0 +1,303 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"os/user"
+	"text/tabwriter"
+	"time"
+
+	"github.com/advanderveer/factory/engine"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//CronFlags configure a cron
+type CronFlags struct {
+	Schedule    string `long:"schedule" required:"true" description:"Standard cron expression of five fields at which runs are due, e.g: '*/5 * * * *'"`
+	Spec        string `long:"spec" required:"true" description:"Path to a yaml file that describes the task of each run"`
+	Concurrency string `long:"concurrency" default:"allow" choice:"allow" choice:"forbid" choice:"replace" description:"Whether a run is submitted, skipped or replaces earlier runs while those have not finished"`
+	CatchUp     int64  `long:"catch-up" default:"1" description:"Max number of missed runs that are submitted at once, e.g. after no pump was leader for a while"`
+}
+
+//TaskFile describes a task in yaml, e.g: "pool_id: my-pool"
+type TaskFile struct {
+	PoolID   string `yaml:"pool_id"`
+	Size     int64  `yaml:"size"`
+	Priority string `yaml:"priority"`
+}
+
+//readTaskFile reads a task spec from a yaml file, the size defaults to 1
+func readTaskFile(path string) (spec engine.TaskSpec, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return spec, errors.Wrap(err, "failed to read spec file")
+	}
+
+	tf := TaskFile{Size: 1}
+	if err = yaml.UnmarshalStrict(data, &tf); err != nil {
+		return spec, errors.Wrap(err, "failed to decode spec file")
+	}
+
+	return engine.TaskSpec{PoolID: tf.PoolID, Size: tf.Size, Priority: tf.Priority}, nil
+}
+
+//CronCreate command
+type CronCreate struct {
+	*command
+
+	cronFlags  CronFlags
+	awsFlags   AWSFlags
+	debugFlags DebugFlags
+}
+
+//CronCreateFactory creates the command
+func CronCreateFactory() cli.CommandFactory {
+	cmd := &CronCreate{}
+	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("Cron Flags", "Cron Flags", &cmd.cronFlags)
+	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
+	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *CronCreate) Execute(args []string) (err error) {
+	spec, err := readTaskFile(cmd.cronFlags.Spec)
+	if err != nil {
+		return err
+	}
+
+	if u, err := user.Current(); err == nil {
+		spec.Submitter = u.Username
+	}
+
+	cronSpec := engine.CronSpec{
+		TaskSpec:    spec,
+		Schedule:    cmd.cronFlags.Schedule,
+		Concurrency: cmd.cronFlags.Concurrency,
+		CatchUp:     cmd.cronFlags.CatchUp,
+	}
+
+	awsopts := session.Options{}
+	if cmd.awsFlags.Profile != "" {
+		awsopts.Profile = cmd.awsFlags.Profile
+	}
+
+	if cmd.awsFlags.Region != "" {
+		awsopts.Config = aws.Config{Region: aws.String(cmd.awsFlags.Region)}
+	}
+
+	var awss *session.Session
+	if awss, err = session.NewSessionWithOptions(awsopts); err != nil {
+		return errors.Wrap(err, "failed to create aws session")
+	}
+
+	logs := cmd.debugFlags.Logger()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	ctx := context.Background()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		for s := range sigCh {
+			logs.Printf("[INFO] Received %s, shutting down", s)
+			stop()
+		}
+	}()
+
+	db := dynamodb.New(awss)
+	q := sqs.New(awss)
+	engine := engine.New(logs, db, q)
+	cronID, err := engine.CreateCron(ctx, cronSpec)
+	if err != nil {
+		return errors.Wrap(err, "failed to create cron")
+	}
+
+	fmt.Println(cronID)
+
+	return nil
+}
+
+// Description returns long-form help text
+func (cmd *CronCreate) Description() string {
+	return "Stores a task that the pump leader submits each time the schedule is due. The task is described by a yaml file with a pool_id, a size and optionally a priority. Runs are submitted as the user that created the cron."
+}
+
+// Synopsis returns a one-line
+func (cmd *CronCreate) Synopsis() string { return "create a recurring task" }
+
+// Usage shows usage
+func (cmd *CronCreate) Usage() string {
+	return "factory cron create --schedule=<expr> --spec=<file> [--concurrency=allow|forbid|replace] [--catch-up=N]"
+}
+
+//CronList command
+type CronList struct {
+	*command
+
+	awsFlags   AWSFlags
+	debugFlags DebugFlags
+}
+
+//CronListFactory creates the command
+func CronListFactory() cli.CommandFactory {
+	cmd := &CronList{}
+	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
+	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *CronList) Execute(args []string) (err error) {
+	awsopts := session.Options{}
+	if cmd.awsFlags.Profile != "" {
+		awsopts.Profile = cmd.awsFlags.Profile
+	}
+
+	if cmd.awsFlags.Region != "" {
+		awsopts.Config = aws.Config{Region: aws.String(cmd.awsFlags.Region)}
+	}
+
+	var awss *session.Session
+	if awss, err = session.NewSessionWithOptions(awsopts); err != nil {
+		return errors.Wrap(err, "failed to create aws session")
+	}
+
+	logs := cmd.debugFlags.Logger()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	ctx := context.Background()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		for s := range sigCh {
+			logs.Printf("[INFO] Received %s, shutting down", s)
+			stop()
+		}
+	}()
+
+	db := dynamodb.New(awss)
+	q := sqs.New(awss)
+	engine := engine.New(logs, db, q)
+	crons, err := engine.Crons(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list crons")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CRON\tSCHEDULE\tPOOL\tSIZE\tCONCURRENCY\tNEXT RUN\tACTIVE")
+	for _, c := range crons {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%d\n", c.CronID, c.Schedule, c.PoolID, c.Size, c.Concurrency, time.Unix(c.Next, 0).Format(time.RFC3339), len(c.Active))
+	}
+
+	if err = w.Flush(); err != nil {
+		return errors.Wrap(err, "failed to write crons")
+	}
+
+	return nil
+}
+
+// Description returns long-form help text
+func (cmd *CronList) Description() string {
+	return "Lists all crons, when their next run is due and how many of their runs may not have finished."
+}
+
+// Synopsis returns a one-line
+func (cmd *CronList) Synopsis() string { return "list recurring tasks" }
+
+// Usage shows usage
+func (cmd *CronList) Usage() string {
+	return "factory cron list"
+}
+
+//CronDelete command
+type CronDelete struct {
+	*command
+
+	awsFlags   AWSFlags
+	debugFlags DebugFlags
+}
+
+//CronDeleteFactory creates the command
+func CronDeleteFactory() cli.CommandFactory {
+	cmd := &CronDelete{}
+	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
+	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *CronDelete) Execute(args []string) (err error) {
+	if len(args) < 1 {
+		return errors.New("not enough arguments, see --help")
+	}
+
+	awsopts := session.Options{}
+	if cmd.awsFlags.Profile != "" {
+		awsopts.Profile = cmd.awsFlags.Profile
+	}
+
+	if cmd.awsFlags.Region != "" {
+		awsopts.Config = aws.Config{Region: aws.String(cmd.awsFlags.Region)}
+	}
+
+	var awss *session.Session
+	if awss, err = session.NewSessionWithOptions(awsopts); err != nil {
+		return errors.Wrap(err, "failed to create aws session")
+	}
+
+	logs := cmd.debugFlags.Logger()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	ctx := context.Background()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		for s := range sigCh {
+			logs.Printf("[INFO] Received %s, shutting down", s)
+			stop()
+		}
+	}()
+
+	db := dynamodb.New(awss)
+	q := sqs.New(awss)
+	engine := engine.New(logs, db, q)
+	if err = engine.DeleteCron(ctx, args[0]); err != nil {
+		return errors.Wrap(err, "failed to delete cron")
+	}
+
+	return nil
+}
+
+// Description returns long-form help text
+func (cmd *CronDelete) Description() string {
+	return "Deletes a cron so no further runs are submitted, runs that were submitted already are left as is."
+}
+
+// Synopsis returns a one-line
+func (cmd *CronDelete) Synopsis() string { return "delete a recurring task" }
+
+// Usage shows usage
+func (cmd *CronDelete) Usage() string {
+	return "factory cron delete <id>"
+}
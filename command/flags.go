@@ -1,10 +1,21 @@
 package command
 
 import (
-	"log"
+	"context"
+	"net"
+	"net/http"
 	"os"
 
-	"github.com/hashicorp/logutils"
+	"github.com/advanderveer/factory/engine"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 //AWSFlags holds options that configure aws
@@ -17,16 +28,96 @@ type AWSFlags struct {
 type DebugFlags struct {
 	Debug     bool   `long:"debug" description:"Debug mode enable extra information"`
 	Verbosity string `short:"v" long:"verbosity" default:"DEBUG"  description:"Show information at various levels: DEBUG, INFO, WARN, ERROR"`
+	LogFormat string `long:"log-format" default:"text" choice:"text" choice:"json" description:"Format of the logs: text or json"`
 }
 
 //Logger returns a logger that filters based on verbosity flags
-func (f DebugFlags) Logger() (logs *log.Logger) {
-	logs = log.New(os.Stderr, "factory/", log.Lshortfile|log.Lmicroseconds)
-	logs.SetOutput(&logutils.LevelFilter{
-		Levels:   []logutils.LogLevel{"DEBUG", "INFO", "WARN", "ERROR"},
-		MinLevel: logutils.LogLevel(f.Verbosity),
-		Writer:   os.Stderr,
-	})
-
-	return logs
+func (f DebugFlags) Logger() (logs engine.Logger) {
+	if f.LogFormat == "json" {
+		return engine.NewJSONLogger(os.Stderr, f.Verbosity)
+	}
+
+	return engine.NewTextLogger(os.Stderr, f.Verbosity)
+}
+
+//MetricsFlags configure how metrics are exposed
+type MetricsFlags struct {
+	Addr string `long:"metrics-addr" description:"Address on which Prometheus metrics are served at /metrics, e.g: ':9100'"`
+}
+
+//Serve exposes the metrics in the background if an address is configured
+func (f MetricsFlags) Serve(logs engine.Logger) error {
+	if f.Addr == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", f.Addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for metrics")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		logs.Printf("[INFO] Serving metrics on '%s'", ln.Addr())
+		if err := http.Serve(ln, mux); err != nil {
+			logs.Printf("[ERROR] Failed to serve metrics: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+//TraceFlags configure where traces are exported to
+type TraceFlags struct {
+	Exporter string `long:"trace-exporter" default:"none" choice:"none" choice:"otlp" choice:"file" description:"Where to export traces to: none, otlp or file"`
+	Endpoint string `long:"trace-endpoint" description:"Host and port of the OTLP/HTTP collector, defaults to the OTEL_EXPORTER_OTLP_ENDPOINT environment"`
+	File     string `long:"trace-file" default:"factory-traces.json" description:"File that traces are appended to when exporting to a file"`
+}
+
+//Setup installs a tracer provider for the service that exports spans as
+//configured. The returned function flushes and stops the exporter.
+func (f TraceFlags) Setup(service string) (shutdown func(ctx context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var exp sdktrace.SpanExporter
+	var file *os.File
+	switch f.Exporter {
+	case "otlp":
+		opts := []otlptracehttp.Option{}
+		if f.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(f.Endpoint), otlptracehttp.WithInsecure())
+		}
+
+		if exp, err = otlptracehttp.New(context.Background(), opts...); err != nil {
+			return nil, errors.Wrap(err, "failed to create otlp exporter")
+		}
+
+	case "file":
+		if file, err = os.OpenFile(f.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return nil, errors.Wrap(err, "failed to open trace file")
+		}
+
+		if exp, err = stdouttrace.New(stdouttrace.WithWriter(file)); err != nil {
+			file.Close()
+			return nil, errors.Wrap(err, "failed to create file exporter")
+		}
+
+	default:
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "factory-"+service))),
+	)
+
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		if file != nil {
+			defer file.Close()
+		}
+
+		return provider.Shutdown(ctx)
+	}, nil
 }
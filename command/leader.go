@@ -0,0 +1,99 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/advanderveer/factory/engine"
+	"github.com/advanderveer/factory/model"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+)
+
+//Leader command
+type Leader struct {
+	*command
+
+	awsFlags   AWSFlags
+	debugFlags DebugFlags
+}
+
+//LeaderFactory creates the command
+func LeaderFactory() cli.CommandFactory {
+	cmd := &Leader{}
+	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
+	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *Leader) Execute(args []string) (err error) {
+	awsopts := session.Options{}
+	if cmd.awsFlags.Profile != "" {
+		awsopts.Profile = cmd.awsFlags.Profile
+	}
+
+	if cmd.awsFlags.Region != "" {
+		awsopts.Config = aws.Config{Region: aws.String(cmd.awsFlags.Region)}
+	}
+
+	var awss *session.Session
+	if awss, err = session.NewSessionWithOptions(awsopts); err != nil {
+		return errors.Wrap(err, "failed to create aws session")
+	}
+
+	logs := cmd.debugFlags.Logger()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	ctx := context.Background()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		for s := range sigCh {
+			logs.Printf("[INFO] Received %s, shutting down", s)
+			stop()
+		}
+	}()
+
+	db := dynamodb.New(awss)
+	q := sqs.New(awss)
+	engine := engine.New(logs, db, q)
+	lease, err := engine.Leader(ctx)
+	if err != nil {
+		if errors.Cause(err) == model.ErrLeaseNotExists {
+			fmt.Println("no leader, no pump was ever elected")
+			return nil
+		}
+
+		return errors.Wrap(err, "failed to get leader")
+	}
+
+	if lease.Expired() {
+		fmt.Printf("no leader, last leader was '%s'\n", lease.Holder)
+		return nil
+	}
+
+	fmt.Printf("%s (until %s)\n", lease.Holder, time.Unix(lease.TTL, 0).Format(time.RFC3339))
+
+	return nil
+}
+
+// Description returns long-form help text
+func (cmd *Leader) Description() string { return "<help>" }
+
+// Synopsis returns a one-line
+func (cmd *Leader) Synopsis() string { return "<synopsis>" }
+
+// Usage shows usage
+func (cmd *Leader) Usage() string { return "factory leader" }
@@ -2,6 +2,8 @@ package command
 
 import (
 	"context"
+	"encoding/json"
+	"io/ioutil"
 	"os"
 	"os/signal"
 
@@ -14,20 +16,57 @@ import (
 	"github.com/pkg/errors"
 )
 
+//PumpFlags configure how a pump schedules
+type PumpFlags struct {
+	ScheduleWorkers int `long:"schedule-workers" default:"4" description:"Number of rounds of schedule messages that are received and scheduled at the same time"`
+
+	AutoscalePolicies string `long:"autoscale-policies" description:"JSON file with the bounds of the pools that are autoscaled, e.g: '[{\"pool_id\": \"my-pool\", \"min\": 1, \"max\": 10, \"node_capacity\": 4}]'"`
+	AutoscaleHook     string `long:"autoscale-hook" description:"Command that is run as '<hook> up <pool> <n>' and '<hook> down <pool> <node-id>...' to scale the pools"`
+}
+
+//LoadAutoscalePolicies loads the policies of the pools that are autoscaled, it
+//returns none if autoscaling is not configured
+func (f PumpFlags) LoadAutoscalePolicies() (policies []engine.AutoscalePolicy, err error) {
+	if f.AutoscalePolicies == "" && f.AutoscaleHook == "" {
+		return nil, nil
+	}
+
+	if f.AutoscalePolicies == "" || f.AutoscaleHook == "" {
+		return nil, errors.New("--autoscale-policies and --autoscale-hook are to be set together")
+	}
+
+	data, err := ioutil.ReadFile(f.AutoscalePolicies)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read autoscale policies")
+	}
+
+	if err = json.Unmarshal(data, &policies); err != nil {
+		return nil, errors.Wrap(err, "failed to decode autoscale policies")
+	}
+
+	return policies, nil
+}
+
 //Pump command
 type Pump struct {
 	*command
 
-	awsFlags   AWSFlags
-	debugFlags DebugFlags
+	pumpFlags    PumpFlags
+	awsFlags     AWSFlags
+	debugFlags   DebugFlags
+	metricsFlags MetricsFlags
+	traceFlags   TraceFlags
 }
 
 //PumpFactory creates the command
 func PumpFactory() cli.CommandFactory {
 	cmd := &Pump{}
 	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("Pump Flags", "Pump Flags", &cmd.pumpFlags)
 	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
 	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+	cmd.command.flagParser.AddGroup("Trace Flags", "Trace Flags", &cmd.traceFlags)
+	cmd.command.flagParser.AddGroup("Metrics Flags", "Metrics Flags", &cmd.metricsFlags)
 
 	return func() (cli.Command, error) {
 		return cmd, nil
@@ -40,6 +79,15 @@ func (cmd *Pump) Execute(args []string) (err error) {
 		return errors.New("not enough arguments, see --help")
 	}
 
+	if cmd.pumpFlags.ScheduleWorkers < 1 {
+		return errors.New("--schedule-workers must be at least 1")
+	}
+
+	policies, err := cmd.pumpFlags.LoadAutoscalePolicies()
+	if err != nil {
+		return err
+	}
+
 	awsopts := session.Options{}
 	if cmd.awsFlags.Profile != "" {
 		awsopts.Profile = cmd.awsFlags.Profile
@@ -55,6 +103,21 @@ func (cmd *Pump) Execute(args []string) (err error) {
 	}
 
 	logs := cmd.debugFlags.Logger()
+	shutdownTracing, err := cmd.traceFlags.Setup("pump")
+	if err != nil {
+		return errors.Wrap(err, "failed to setup tracing")
+	}
+
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logs.Printf("[ERROR] Failed to flush traces: %v", err)
+		}
+	}()
+
+	if err = cmd.metricsFlags.Serve(logs); err != nil {
+		return errors.Wrap(err, "failed to serve metrics")
+	}
+
 	sigCh := make(chan os.Signal)
 	signal.Notify(sigCh, os.Interrupt)
 	ctx := context.Background()
@@ -69,7 +132,15 @@ func (cmd *Pump) Execute(args []string) (err error) {
 
 	db := dynamodb.New(awss)
 	q := sqs.New(awss)
+	engine.ScheduleWorkers = cmd.pumpFlags.ScheduleWorkers
+	hook := engine.NewCommandAutoscaler(logs, cmd.pumpFlags.AutoscaleHook)
 	engine := engine.New(logs, db, q)
+	if policies != nil {
+		if err = engine.EnableAutoscaling(hook, policies); err != nil {
+			return errors.Wrap(err, "failed to enable autoscaling")
+		}
+	}
+
 	if err = engine.Pump(ctx); err != nil {
 		return errors.Wrap(err, "failed to pump")
 	}
@@ -84,4 +155,6 @@ func (cmd *Pump) Description() string { return "<help>" }
 func (cmd *Pump) Synopsis() string { return "<synopsis>" }
 
 // Usage shows usage
-func (cmd *Pump) Usage() string { return "factory pump" }
+func (cmd *Pump) Usage() string {
+	return "factory pump [--schedule-workers <n>] [--autoscale-policies <file> --autoscale-hook <command>]"
+}
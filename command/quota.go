@@ -0,0 +1,217 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/advanderveer/factory/engine"
+	"github.com/advanderveer/factory/model"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+)
+
+//QuotaFlags configure the limits of a quota
+type QuotaFlags struct {
+	MaxCapacity int64 `long:"max-capacity" description:"Max capacity that the claims of the pool or tenant may use at once, 0 means unlimited"`
+	MaxQueued   int64 `long:"max-queued" description:"Max number of tasks of the pool or tenant that wait to be placed, 0 means unlimited"`
+	MaxSize     int64 `long:"max-size" description:"Max size of a task submitted to the pool or by the tenant, 0 means unlimited"`
+	Weight      int64 `long:"weight" default:"1" description:"Weight of a tenant's share of the capacity while tenants compete for it"`
+}
+
+//quotaPK returns the key of the quota named by the arguments, e.g: "pool my-pool"
+func quotaPK(args []string) (model.QuotaPK, error) {
+	if len(args) < 2 {
+		return model.QuotaPK{}, errors.New("not enough arguments, see --help")
+	}
+
+	switch args[0] {
+	case model.QuotaKindPool:
+		return model.PoolQuotaPK(args[1]), nil
+	case model.QuotaKindTenant:
+		return model.TenantQuotaPK(args[1]), nil
+	default:
+		return model.QuotaPK{}, errors.Errorf("unknown kind of quota '%s', expected '%s' or '%s'", args[0], model.QuotaKindPool, model.QuotaKindTenant)
+	}
+}
+
+func limit(max int64) string {
+	if max < 1 {
+		return "unlimited"
+	}
+
+	return fmt.Sprintf("%d", max)
+}
+
+//QuotaGet command
+type QuotaGet struct {
+	*command
+
+	awsFlags   AWSFlags
+	debugFlags DebugFlags
+}
+
+//QuotaGetFactory creates the command
+func QuotaGetFactory() cli.CommandFactory {
+	cmd := &QuotaGet{}
+	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
+	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *QuotaGet) Execute(args []string) (err error) {
+	pk, err := quotaPK(args)
+	if err != nil {
+		return err
+	}
+
+	awsopts := session.Options{}
+	if cmd.awsFlags.Profile != "" {
+		awsopts.Profile = cmd.awsFlags.Profile
+	}
+
+	if cmd.awsFlags.Region != "" {
+		awsopts.Config = aws.Config{Region: aws.String(cmd.awsFlags.Region)}
+	}
+
+	var awss *session.Session
+	if awss, err = session.NewSessionWithOptions(awsopts); err != nil {
+		return errors.Wrap(err, "failed to create aws session")
+	}
+
+	logs := cmd.debugFlags.Logger()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	ctx := context.Background()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		for s := range sigCh {
+			logs.Printf("[INFO] Received %s, shutting down", s)
+			stop()
+		}
+	}()
+
+	db := dynamodb.New(awss)
+	q := sqs.New(awss)
+	engine := engine.New(logs, db, q)
+	quota, err := engine.Quota(ctx, pk)
+	if err != nil {
+		return errors.Wrap(err, "failed to get quota")
+	}
+
+	fmt.Printf("quota:        %s\n", quota.QuotaPK)
+	fmt.Printf("max capacity: %s (in use: %d)\n", limit(quota.MaxCapacity), quota.Used)
+	fmt.Printf("max queued:   %s (queued: %d)\n", limit(quota.MaxQueued), quota.Queued)
+	fmt.Printf("max size:     %s\n", limit(quota.MaxSize))
+	if pk.Kind() == model.QuotaKindTenant {
+		fmt.Printf("weight:       %d\n", quota.ShareWeight())
+	}
+
+	return nil
+}
+
+// Description returns long-form help text
+func (cmd *QuotaGet) Description() string {
+	return "Shows the limits of a pool's or tenant's quota and the usage that counts towards them. The tenant of a task is the identity that submitted it."
+}
+
+// Synopsis returns a one-line
+func (cmd *QuotaGet) Synopsis() string { return "show a quota and its usage" }
+
+// Usage shows usage
+func (cmd *QuotaGet) Usage() string { return "factory quota get pool|tenant <id>" }
+
+//QuotaSet command
+type QuotaSet struct {
+	*command
+
+	quotaFlags QuotaFlags
+	awsFlags   AWSFlags
+	debugFlags DebugFlags
+}
+
+//QuotaSetFactory creates the command
+func QuotaSetFactory() cli.CommandFactory {
+	cmd := &QuotaSet{}
+	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("Quota Flags", "Quota Flags", &cmd.quotaFlags)
+	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
+	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *QuotaSet) Execute(args []string) (err error) {
+	pk, err := quotaPK(args)
+	if err != nil {
+		return err
+	}
+
+	awsopts := session.Options{}
+	if cmd.awsFlags.Profile != "" {
+		awsopts.Profile = cmd.awsFlags.Profile
+	}
+
+	if cmd.awsFlags.Region != "" {
+		awsopts.Config = aws.Config{Region: aws.String(cmd.awsFlags.Region)}
+	}
+
+	var awss *session.Session
+	if awss, err = session.NewSessionWithOptions(awsopts); err != nil {
+		return errors.Wrap(err, "failed to create aws session")
+	}
+
+	logs := cmd.debugFlags.Logger()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	ctx := context.Background()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		for s := range sigCh {
+			logs.Printf("[INFO] Received %s, shutting down", s)
+			stop()
+		}
+	}()
+
+	db := dynamodb.New(awss)
+	q := sqs.New(awss)
+	engine := engine.New(logs, db, q)
+	if err = engine.SetQuota(ctx, pk, model.QuotaLimits{
+		MaxCapacity: cmd.quotaFlags.MaxCapacity,
+		MaxQueued:   cmd.quotaFlags.MaxQueued,
+		MaxSize:     cmd.quotaFlags.MaxSize,
+		Weight:      cmd.quotaFlags.Weight,
+	}); err != nil {
+		return errors.Wrap(err, "failed to set quota")
+	}
+
+	return nil
+}
+
+// Description returns long-form help text
+func (cmd *QuotaSet) Description() string {
+	return "Sets all limits of a pool's or tenant's quota, limits that are not given become unlimited. Submissions over the limits are rejected, tasks wait to be scheduled while the capacity in use is at its max."
+}
+
+// Synopsis returns a one-line
+func (cmd *QuotaSet) Synopsis() string { return "set the limits of a quota" }
+
+// Usage shows usage
+func (cmd *QuotaSet) Usage() string {
+	return "factory quota set [--max-capacity=N] [--max-queued=N] [--max-size=N] [--weight=N] pool|tenant <id>"
+}
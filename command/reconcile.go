@@ -0,0 +1,89 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/advanderveer/factory/engine"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+)
+
+//Reconcile command
+type Reconcile struct {
+	*command
+
+	awsFlags   AWSFlags
+	debugFlags DebugFlags
+}
+
+//ReconcileFactory creates the command
+func ReconcileFactory() cli.CommandFactory {
+	cmd := &Reconcile{}
+	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
+	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *Reconcile) Execute(args []string) (err error) {
+	awsopts := session.Options{}
+	if cmd.awsFlags.Profile != "" {
+		awsopts.Profile = cmd.awsFlags.Profile
+	}
+
+	if cmd.awsFlags.Region != "" {
+		awsopts.Config = aws.Config{Region: aws.String(cmd.awsFlags.Region)}
+	}
+
+	var awss *session.Session
+	if awss, err = session.NewSessionWithOptions(awsopts); err != nil {
+		return errors.Wrap(err, "failed to create aws session")
+	}
+
+	logs := cmd.debugFlags.Logger()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	ctx := context.Background()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		for s := range sigCh {
+			logs.Printf("[INFO] Received %s, shutting down", s)
+			stop()
+		}
+	}()
+
+	db := dynamodb.New(awss)
+	q := sqs.New(awss)
+	engine := engine.New(logs, db, q)
+	corrections, err := engine.Reconcile(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to reconcile")
+	}
+
+	for _, c := range corrections {
+		fmt.Printf("corrected node '%s' in pool '%s': capacity %d -> %d\n", c.NodeID, c.PoolID, c.Cap, c.Expected)
+	}
+
+	return nil
+}
+
+// Description returns long-form help text
+func (cmd *Reconcile) Description() string { return "<help>" }
+
+// Synopsis returns a one-line
+func (cmd *Reconcile) Synopsis() string { return "<synopsis>" }
+
+// Usage shows usage
+func (cmd *Reconcile) Usage() string { return "factory reconcile" }
@@ -2,11 +2,14 @@ package command
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"os/user"
 	"time"
 
 	"github.com/advanderveer/factory/engine"
+	"github.com/advanderveer/factory/model"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -15,20 +18,36 @@ import (
 	"github.com/pkg/errors"
 )
 
+//RunFlags configure a task that is run
+type RunFlags struct {
+	NotBefore string        `long:"not-before" description:"RFC3339 time before which the task is not scheduled, e.g: '2006-01-02T15:04:05Z'"`
+	Delay     time.Duration `long:"delay" description:"Duration after which the task is scheduled, e.g: '10m'"`
+	Priority  string        `long:"priority" description:"Priority class of the task: 'high', 'normal' or 'low', defaults to 'normal'"`
+
+	//IdempotencyKey makes it safe to run the command again after it timed out
+	IdempotencyKey string `long:"idempotency-key" description:"Key that makes retries return the task of the first run instead of submitting another, e.g: 'nightly-2006-01-02'"`
+}
+
 //Run command
 type Run struct {
 	*command
 
+	runFlags   RunFlags
+	batchFlags BatchFlags
 	awsFlags   AWSFlags
 	debugFlags DebugFlags
+	traceFlags TraceFlags
 }
 
 //RunFactory creates the command
 func RunFactory() cli.CommandFactory {
 	cmd := &Run{}
 	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("Run Flags", "Run Flags", &cmd.runFlags)
+	cmd.command.flagParser.AddGroup("Batch Flags", "Batch Flags", &cmd.batchFlags)
 	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
 	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+	cmd.command.flagParser.AddGroup("Trace Flags", "Trace Flags", &cmd.traceFlags)
 
 	return func() (cli.Command, error) {
 		return cmd, nil
@@ -37,10 +56,38 @@ func RunFactory() cli.CommandFactory {
 
 //Execute runs the command
 func (cmd *Run) Execute(args []string) (err error) {
-	if len(args) < 1 {
+	batch := cmd.batchFlags.Batch != ""
+	if len(args) < 1 && !batch {
 		return errors.New("not enough arguments, see --help")
 	}
 
+	if batch && (len(args) > 0 || cmd.runFlags != RunFlags{}) {
+		return errors.New("a batch file can't be combined with a pool or run flags, those are set per line")
+	}
+
+	if !model.ValidPriority(cmd.runFlags.Priority) {
+		return errors.Errorf("invalid --priority '%s', expected one of %v", cmd.runFlags.Priority, model.Priorities)
+	}
+
+	spec := engine.TaskSpec{Size: 1, Priority: cmd.runFlags.Priority, IdempotencyKey: cmd.runFlags.IdempotencyKey}
+	if !batch {
+		spec.PoolID = args[0]
+	}
+
+	switch {
+	case cmd.runFlags.NotBefore != "" && cmd.runFlags.Delay != 0:
+		return errors.New("--not-before and --delay can't be combined")
+	case cmd.runFlags.NotBefore != "":
+		if spec.NotBefore, err = time.Parse(time.RFC3339, cmd.runFlags.NotBefore); err != nil {
+			return errors.Wrap(err, "invalid --not-before")
+		}
+
+	case cmd.runFlags.Delay < 0:
+		return errors.New("--delay can't be negative")
+	case cmd.runFlags.Delay > 0:
+		spec.NotBefore = time.Now().Add(cmd.runFlags.Delay)
+	}
+
 	awsopts := session.Options{}
 	if cmd.awsFlags.Profile != "" {
 		awsopts.Profile = cmd.awsFlags.Profile
@@ -56,10 +103,28 @@ func (cmd *Run) Execute(args []string) (err error) {
 	}
 
 	logs := cmd.debugFlags.Logger()
+	shutdownTracing, err := cmd.traceFlags.Setup("run")
+	if err != nil {
+		return errors.Wrap(err, "failed to setup tracing")
+	}
+
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logs.Printf("[ERROR] Failed to flush traces: %v", err)
+		}
+	}()
+
 	sigCh := make(chan os.Signal)
 	signal.Notify(sigCh, os.Interrupt)
-	ctx := context.Background()
-	ctx, stop := context.WithTimeout(ctx, time.Second*30)
+	//a batch runs until all lines were submitted, a single task times out
+	var ctx context.Context
+	var stop context.CancelFunc
+	if batch {
+		ctx, stop = context.WithCancel(context.Background())
+	} else {
+		ctx, stop = context.WithTimeout(context.Background(), time.Second*30)
+	}
+
 	defer stop()
 	go func() {
 		for s := range sigCh {
@@ -70,12 +135,22 @@ func (cmd *Run) Execute(args []string) (err error) {
 
 	db := dynamodb.New(awss)
 	q := sqs.New(awss)
+	if u, err := user.Current(); err == nil {
+		spec.Submitter = u.Username
+	}
+
 	engine := engine.New(logs, db, q)
-	err = engine.Submit(ctx, args[0], 1)
+	if batch {
+		return cmd.batchFlags.Run(ctx, engine, spec.Submitter)
+	}
+
+	taskID, err := engine.Submit(ctx, spec)
 	if err != nil {
 		return errors.Wrap(err, "failed to run process")
 	}
 
+	fmt.Println(taskID)
+
 	return nil
 }
 
@@ -86,4 +161,6 @@ func (cmd *Run) Description() string { return "<help>" }
 func (cmd *Run) Synopsis() string { return "<synopsis>" }
 
 // Usage shows usage
-func (cmd *Run) Usage() string { return "factory run <pool_id>" }
+func (cmd *Run) Usage() string {
+	return "factory run [--not-before <time> | --delay <duration>] [--priority <class>] [--idempotency-key <key>] <pool_id> | --batch <tasks.jsonl> [--concurrency <n>] [--retry-file <path>]"
+}
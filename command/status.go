@@ -0,0 +1,100 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+
+	"github.com/advanderveer/factory/engine"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+)
+
+//Status command
+type Status struct {
+	*command
+
+	awsFlags   AWSFlags
+	debugFlags DebugFlags
+}
+
+//StatusFactory creates the command
+func StatusFactory() cli.CommandFactory {
+	cmd := &Status{}
+	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
+	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *Status) Execute(args []string) (err error) {
+	awsopts := session.Options{}
+	if cmd.awsFlags.Profile != "" {
+		awsopts.Profile = cmd.awsFlags.Profile
+	}
+
+	if cmd.awsFlags.Region != "" {
+		awsopts.Config = aws.Config{Region: aws.String(cmd.awsFlags.Region)}
+	}
+
+	var awss *session.Session
+	if awss, err = session.NewSessionWithOptions(awsopts); err != nil {
+		return errors.Wrap(err, "failed to create aws session")
+	}
+
+	logs := cmd.debugFlags.Logger()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	ctx := context.Background()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		for s := range sigCh {
+			logs.Printf("[INFO] Received %s, shutting down", s)
+			stop()
+		}
+	}()
+
+	db := dynamodb.New(awss)
+	q := sqs.New(awss)
+	engine := engine.New(logs, db, q)
+	capacity, shares, err := engine.Shares(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get tenant shares")
+	}
+
+	fmt.Printf("total capacity: %d\n\n", capacity)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TENANT\tWEIGHT\tUSED\tQUEUED\tENTITLED\tSHARE")
+	for _, share := range shares {
+		ratio := "-"
+		if share.Entitled > 0 {
+			ratio = fmt.Sprintf("%.0f%%", float64(share.Used)/share.Entitled*100)
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f\t%s\n", share.Tenant, share.Weight, share.Used, share.Queued, share.Entitled, ratio)
+	}
+
+	return w.Flush()
+}
+
+// Description returns long-form help text
+func (cmd *Status) Description() string {
+	return "Shows the capacity each tenant uses versus the share it is entitled to. Tenants that use or wait for capacity share all of it in proportion to their weight, idle tenants are entitled to nothing."
+}
+
+// Synopsis returns a one-line
+func (cmd *Status) Synopsis() string { return "show tenant usage versus entitled share" }
+
+// Usage shows usage
+func (cmd *Status) Usage() string { return "factory status" }
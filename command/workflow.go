@@ -0,0 +1,112 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/advanderveer/factory/engine"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/mitchellh/cli"
+	"github.com/pkg/errors"
+)
+
+//WorkflowStatus command
+type WorkflowStatus struct {
+	*command
+
+	awsFlags   AWSFlags
+	debugFlags DebugFlags
+}
+
+//WorkflowStatusFactory creates the command
+func WorkflowStatusFactory() cli.CommandFactory {
+	cmd := &WorkflowStatus{}
+	cmd.command = createCommand(cmd.Execute, cmd.Description, cmd.Usage)
+	cmd.command.flagParser.AddGroup("AWS Flags", "AWS Flags", &cmd.awsFlags)
+	cmd.command.flagParser.AddGroup("Debug Flags", "Debug Flags", &cmd.debugFlags)
+
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *WorkflowStatus) Execute(args []string) (err error) {
+	if len(args) < 1 {
+		return errors.New("not enough arguments, see --help")
+	}
+
+	awsopts := session.Options{}
+	if cmd.awsFlags.Profile != "" {
+		awsopts.Profile = cmd.awsFlags.Profile
+	}
+
+	if cmd.awsFlags.Region != "" {
+		awsopts.Config = aws.Config{Region: aws.String(cmd.awsFlags.Region)}
+	}
+
+	var awss *session.Session
+	if awss, err = session.NewSessionWithOptions(awsopts); err != nil {
+		return errors.Wrap(err, "failed to create aws session")
+	}
+
+	logs := cmd.debugFlags.Logger()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	ctx := context.Background()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		for s := range sigCh {
+			logs.Printf("[INFO] Received %s, shutting down", s)
+			stop()
+		}
+	}()
+
+	db := dynamodb.New(awss)
+	q := sqs.New(awss)
+	engine := engine.New(logs, db, q)
+	wf, tasks, err := engine.WorkflowStatus(ctx, args[0])
+	if err != nil {
+		return errors.Wrap(err, "failed to get workflow")
+	}
+
+	sorted, err := wf.Sorted()
+	if err != nil {
+		return errors.Wrap(err, "invalid dependencies")
+	}
+
+	fmt.Printf("workflow:   %s\n", wf.WorkflowPK)
+	fmt.Printf("state:      %s\n", wf.State)
+	fmt.Printf("on failure: %s\n\n", wf.OnFailure)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tDEPENDS ON\tTASK")
+	for _, wt := range sorted {
+		deps := "-"
+		if len(wt.DependsOn) > 0 {
+			deps = strings.Join(wt.DependsOn, ", ")
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", wt.Name, tasks[wt.Name].State, deps, wt.TaskID)
+	}
+
+	return w.Flush()
+}
+
+// Description returns long-form help text
+func (cmd *WorkflowStatus) Description() string {
+	return "Shows the state of a workflow and its tasks, upstream tasks are listed before the tasks that depend on them. Waiting tasks are scheduled once all tasks they depend on succeeded."
+}
+
+// Synopsis returns a one-line
+func (cmd *WorkflowStatus) Synopsis() string { return "show the state of a workflow's tasks" }
+
+// Usage shows usage
+func (cmd *WorkflowStatus) Usage() string { return "factory workflow status <id>" }
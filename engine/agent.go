@@ -3,12 +3,14 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"os"
 	"time"
 
 	"github.com/advanderveer/factory/model"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -20,28 +22,78 @@ var (
 
 	//ExecutorRunTimeout determines how long the message handler waits for the executor to accept a run message
 	ExecutorRunTimeout = DockerRunExecTimeout + (5 * time.Second)
+
+	//AgentRestartGracePeriod determines how long the node record of an agent
+	//with a stable identity is kept after shutdown, so it can be taken back
+	AgentRestartGracePeriod = time.Minute
 )
 
-//HandleNodeMessage will start handling node messages
+//AgentConfig configures a node agent
+type AgentConfig struct {
+	//NodeID is the node the agent registers as, if empty the id persisted in
+	//the state dir is used or, if there is none, a new one is generated
+	NodeID string
+
+	//HostID identifies the host the agent runs on, an agent may take back a
+	//node record of the same host without waiting for it to expire. It
+	//defaults to the hostname.
+	HostID string
+
+	//StateDir is where the agent persists its node id so that it can take
+	//back its node record after a restart, nothing is persisted when empty
+	StateDir string
+}
+
+//stable returns whether the agent keeps its node identity across restarts
+func (conf AgentConfig) stable() bool {
+	return conf.NodeID != "" || conf.StateDir != ""
+}
+
+//HandleNodeMessage will start handling node messages, a run message is only
+//passed to the executor if its claim could be moved from dispatched to started
 func (e *Engine) HandleNodeMessage(ctx context.Context, nodePK model.NodePK, doneCh chan<- struct{}, runCh chan<- RunMsg) {
-	e.logs.Printf("[INFO] Start handling messages for node '%s'", nodePK)
-	defer e.logs.Printf("[INFO] Stopped handling messages for node '%s'", nodePK)
+	logs := e.logs.With(Fields{FieldNodeID: nodePK.NodeID})
+	logs.Printf("[INFO] Start handling messages for node '%s'", nodePK)
+	defer logs.Printf("[INFO] Stopped handling messages for node '%s'", nodePK)
 	defer close(doneCh)
 
 	for {
-		if err := NextNodeMessage(ctx, e.q, nodePK, func(nextMsg string) bool {
+		if err := NextNodeMessage(ctx, e.q, nodePK, func(msgCtx context.Context, nextMsg string) bool {
 
-			e.logs.Printf("[DEBUG] Received run message: '%s'", nextMsg)
+			logs.Printf("[DEBUG] Received run message: '%s'", nextMsg)
 			msg := RunMsg{}
 			err := json.Unmarshal([]byte(nextMsg), &msg)
 			if err != nil {
-				e.logs.Printf("[ERROR] Failed to unmarshal run message: %v", err)
+				logs.Printf("[ERROR] Failed to unmarshal run message: %v", err)
+				return false
+			}
+
+			msgLogs := logs.With(Fields{FieldClaimID: msg.ClaimID, FieldTaskID: msg.TaskID})
+			_, span := startSpan(msgCtx, "factory.dispatch", trace.SpanKindConsumer, Fields{FieldNodeID: nodePK.NodeID, FieldClaimID: msg.ClaimID, FieldTaskID: msg.TaskID})
+			defer span.End()
+
+			//only the first delivery of a run message moves the claim to started,
+			//others are dropped so the claim doesn't run twice
+			claimPK := model.ClaimPK{ClaimID: msg.ClaimID}
+			if err = model.StartClaim(msgCtx, e.db, claimPK, nodePK.NodeID); err != nil {
+				if errors.Cause(err) == model.ErrClaimNotDispatched {
+					DuplicateRuns.Inc()
+					msgLogs.Printf("[WARN] Dropping run message of claim '%s': %v", claimPK, err)
+					return true
+				}
+
+				msgLogs.Printf("[ERROR] Failed to start claim '%s': %v", claimPK, err)
 				return false
 			}
 
+			msg.spanCtx = span.SpanContext()
 			select {
 			case <-time.After(ExecutorRunTimeout):
-				e.logs.Printf("[ERROR] Timed out waiting for executor to accept message '%s'", nextMsg)
+				msgLogs.Printf("[ERROR] Timed out waiting for executor to accept message '%s'", nextMsg)
+				if err = model.UnstartClaim(msgCtx, e.db, claimPK, nodePK.NodeID); err != nil {
+					msgLogs.Printf("[WARN] Failed to mark claim '%s' as dispatched again, it is left to expire: %v", claimPK, err)
+				}
+
 				return false
 			case runCh <- msg:
 			}
@@ -49,65 +101,170 @@ func (e *Engine) HandleNodeMessage(ctx context.Context, nodePK model.NodePK, don
 			return true
 		}); err != nil {
 			if aerr, ok := errors.Cause(err).(awserr.Error); ok && aerr.Code() == request.CanceledErrorCode {
-				e.logs.Printf("[INFO] Mext node message receive was cancelled")
+				logs.Printf("[INFO] Mext node message receive was cancelled")
 				return
 			}
 
-			e.logs.Printf("[ERROR] Failed to receive next node message: %v", err)
+			QueueReceiveErrors.WithLabelValues("node").Inc()
+			logs.Printf("[ERROR] Failed to receive next node message: %v", err)
 			return
 		}
 
 	}
 }
 
-func (e *Engine) shutdownAgent(node *model.Node, handleDoneCh chan struct{}, execDoneCh chan struct{}) error {
+func (e *Engine) shutdownAgent(conf AgentConfig, node *model.Node, handleDoneCh chan struct{}, execDoneCh chan struct{}) error {
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, MaxAgentShutdownTime)
 	defer cancel()
 
-	err := e.deleteNode(ctx, node.NodePK)
-	if err != nil {
-		return errors.Wrap(err, "failed to delete node")
+	logs := e.logs.With(Fields{FieldNodeID: node.NodeID, FieldPoolID: node.PoolID})
+	if conf.stable() {
+		logs.Printf("[INFO] Keeping node '%s' for %s to allow a restart to take it back", node.NodePK, AgentRestartGracePeriod)
+		err := model.IncrementNodeTTL(ctx, e.db, node.NodePK, AgentRestartGracePeriod)
+		if err != nil {
+			return errors.Wrap(err, "failed to increment node ttl")
+		}
+	} else {
+		err := e.deleteNode(ctx, node.NodePK, false)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete node")
+		}
 	}
 
-	e.logs.Printf("[INFO] Waiting for handling routine to exit")
+	logs.Printf("[INFO] Waiting for handling routine to exit")
 	select {
 	case <-handleDoneCh:
 	case <-ctx.Done():
-		return errors.Wrap(err, "handling routine didn't exit in time")
+		return errors.New("handling routine didn't exit in time")
 	}
 
-	e.logs.Printf("[INFO] Waiting for executor routine to exit")
+	logs.Printf("[INFO] Waiting for executor routine to exit")
 	select {
 	case <-handleDoneCh:
 	case <-ctx.Done():
-		return errors.Wrap(err, "executor routine didn't exit in time")
+		return errors.New("executor routine didn't exit in time")
+	}
+
+	return nil
+}
+
+//register takes back the configured or persisted node record, or registers a
+//new node if there is none. It returns the node id that was persisted before.
+func (e *Engine) register(ctx context.Context, poolID string, conf AgentConfig) (node *model.Node, prevNodeID string, err error) {
+	prevNodeID, err = readNodeID(conf.StateDir)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to read persisted node id")
+	}
+
+	host := conf.HostID
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			return nil, "", errors.Wrap(err, "failed to get hostname")
+		}
+	}
+
+	nodeID := conf.NodeID
+	if nodeID == "" {
+		nodeID = prevNodeID
+	}
+
+	ttl := time.Now().Add(2 * AgentHeartbeatInterval)
+	if nodeID != "" {
+		e.logs.With(Fields{FieldNodeID: nodeID, FieldPoolID: poolID}).Printf("[INFO] Reclaiming node '%s' as host '%s'", nodeID, host)
+		node, err = model.ReclaimNode(ctx, e.db, model.NodePK{NodeID: nodeID}, poolID, host, ttl)
+		if err != nil {
+			if errors.Cause(err) != model.ErrNodeHeld {
+				return nil, "", errors.Wrap(err, "failed to reclaim node")
+			}
+
+			existing, gerr := model.GetNode(ctx, e.db, model.NodePK{NodeID: nodeID})
+			if gerr == nil {
+				return nil, "", errors.Errorf("node '%s' is held by host '%s' in pool '%s'", nodeID, existing.Host, existing.PoolID)
+			} else if errors.Cause(gerr) != model.ErrNodeNotExists {
+				return nil, "", errors.Wrap(gerr, "failed to get node")
+			}
+
+			node = nil
+		}
+	}
+
+	if node == nil {
+		node, err = model.RegisterNode(ctx, e.db, nodeID, poolID, host, ttl)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to register node")
+		}
 	}
 
+	if nodeID != "" {
+		err = e.restoreCapacity(ctx, node)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to restore node capacity")
+		}
+	}
+
+	err = writeNodeID(conf.StateDir, node.NodeID)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to persist node id")
+	}
+
+	return node, prevNodeID, nil
+}
+
+//restoreCapacity sets the capacity of a node that was taken back to what its claims leave
+func (e *Engine) restoreCapacity(ctx context.Context, node *model.Node) error {
+	expected, err := e.expectedCapacity(ctx, node)
+	if err != nil {
+		return errors.Wrap(err, "failed to determine expected capacity")
+	}
+
+	if expected == node.Cap {
+		return nil
+	}
+
+	logs := e.logs.With(Fields{FieldNodeID: node.NodeID, FieldPoolID: node.PoolID})
+	logs.Printf("[INFO] Restoring capacity of node '%s' from %d to %d", node.NodePK, node.Cap, expected)
+	err = model.RepairNodeCapacity(ctx, e.db, node.NodePK, node.Cap, expected)
+	if err != nil {
+		if errors.Cause(err) == model.ErrNodeCapacityChanged {
+			logs.Printf("[WARN] Capacity of node '%s' changed while restoring, leaving it to reconciliation", node.NodePK)
+			return nil
+		}
+
+		return errors.Wrap(err, "failed to repair node capacity")
+	}
+
+	node.Cap = expected
 	return nil
 }
 
 //Agent will start the node agent
-func (e *Engine) Agent(ctx context.Context, poolID string) (err error) {
-	e.logs.Printf("[INFO] Starting node agent for pool '%s'", poolID)
-	defer e.logs.Printf("[INFO] Exited node agent")
+func (e *Engine) Agent(ctx context.Context, poolID string, conf AgentConfig) (err error) {
+	e.logs.With(Fields{FieldPoolID: poolID}).Printf("[INFO] Starting node agent for pool '%s'", poolID)
+	defer e.logs.With(Fields{FieldPoolID: poolID}).Printf("[INFO] Exited node agent")
 
-	node, err := model.RegisterNode(ctx, e.db, poolID, time.Now().Add(2*AgentHeartbeatInterval))
+	node, prevNodeID, err := e.register(ctx, poolID, conf)
 	if err != nil {
 		return errors.Wrap(err, "failed to register node")
 	}
 
-	e.logs.Printf("[DEBUG] Creating queue for node '%s'", node.NodePK)
+	logs := e.logs.With(Fields{FieldNodeID: node.NodeID, FieldPoolID: node.PoolID})
+	logs.Printf("[DEBUG] Creating queue for node '%s'", node.NodePK)
 	err = CreateNodeQueue(ctx, e.q, node.NodePK)
 	if err != nil {
 		return errors.Wrap(err, "failed to create node queue")
 	}
 
-	exec, err := NewDockerExec(e.logs, e.db)
+	exec, err := NewDockerExec(logs, e.db)
 	if err != nil {
 		return errors.Wrap(err, "failed to create docker executer")
 	}
 
+	err = e.collectOrphans(ctx, exec, node, prevNodeID)
+	if err != nil {
+		return errors.Wrap(err, "failed to collect orphaned containers")
+	}
+
 	go exec.Start(ctx, node.NodeID)
 
 	handleMsgDoneCh := make(chan struct{})
@@ -117,19 +274,23 @@ func (e *Engine) Agent(ctx context.Context, poolID string) (err error) {
 	for {
 		select {
 		case <-ctx.Done():
-			return e.shutdownAgent(node, handleMsgDoneCh, exec.Done)
+			return e.shutdownAgent(conf, node, handleMsgDoneCh, exec.Done)
 		case <-ticker.C:
 			t := 2 * AgentHeartbeatInterval
-			e.logs.Printf("[DEBUG] Incrementing node Heartbeat (+%s)", t)
+			logs.Printf("[DEBUG] Incrementing node Heartbeat (+%s)", t)
 			err := model.IncrementNodeTTL(ctx, e.db, node.NodePK, t)
 			if err != nil {
 				if errors.Cause(err) == model.ErrNodeNotExists {
-					e.logs.Printf("[INFO] Node entry removed, shutting down")
+					logs.Printf("[INFO] Node entry removed, shutting down")
 					return nil
 				}
 
 				return errors.Wrap(err, "failed to increment node ttl")
 			}
+
+			if err = e.completeExited(ctx, exec, node); err != nil {
+				logs.Printf("[ERROR] Failed to complete exited containers: %v", err)
+			}
 		}
 	}
 }
@@ -0,0 +1,389 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	//AutoscaleInterval determines how often the leader publishes the demand
+	//and idle capacity of pools and scales them
+	AutoscaleInterval = time.Second * 30
+
+	//AutoscaleCooldown determines how long a pool is not scaled again after
+	//nodes were added to it, so that they have time to register
+	AutoscaleCooldown = time.Minute * 5
+
+	//DemandWindow determines how long a task that could not be placed counts
+	//as demand, it must be well above the visibility timeout of schedule
+	//messages as the demand is recorded again every time it is received
+	DemandWindow = time.Minute * 2
+
+	//MaxDemandPerPool is the max number of tasks and gangs that are read for
+	//the demand of a pool
+	MaxDemandPerPool = int64(1000)
+
+	//AutoscaleLeasePrefix prefixes the leases that keep the cooldown of
+	//pools, they are stored so that a pump that takes over leadership
+	//doesn't scale a pool again
+	AutoscaleLeasePrefix = "autoscale/"
+)
+
+//Autoscaler is a provider of nodes that the pump calls to scale pools
+type Autoscaler interface {
+	//ScaleUp adds n nodes to the pool
+	ScaleUp(ctx context.Context, poolID string, n int) error
+
+	//ScaleDown removes the nodes from the pool, they are drained and empty.
+	//Every node is handed to it once.
+	ScaleDown(ctx context.Context, poolID string, nodeIDs []string) error
+}
+
+//AutoscalePolicy bounds the number of nodes a pool is scaled to
+type AutoscalePolicy struct {
+	PoolID string `json:"pool_id"`
+	Min    int    `json:"min"`
+	Max    int    `json:"max"`
+
+	//NodeCapacity is the capacity a node of the pool registers with, it
+	//determines how many nodes are added for the demand
+	NodeCapacity int64 `json:"node_capacity"`
+}
+
+//autoscaling holds the provider and policies that pools are scaled with
+type autoscaling struct {
+	provider Autoscaler
+	policies map[string]AutoscalePolicy
+}
+
+//PoolSignals are what the pools are scaled on
+type PoolSignals struct {
+	PoolID string
+
+	//Demand is the size of the tasks and gangs that did not fit any node
+	Demand int64
+
+	//IdleCapacity is the free capacity of the nodes that are not draining
+	IdleCapacity int64
+
+	//Active nodes take new claims, drained nodes don't
+	Active  []*model.Node
+	Drained []*model.Node
+}
+
+//empty returns the nodes without claims
+func empty(nodes []*model.Node) (ids []string) {
+	for _, node := range nodes {
+		if node.Cap == node.Max {
+			ids = append(ids, node.NodeID)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+//scaledIn returns the empty nodes that the autoscaler drained, nodes that
+//were drained by an operator are left alone
+func scaledIn(nodes []*model.Node) (ids []string) {
+	for _, node := range nodes {
+		if node.ScaleIn && node.Cap == node.Max {
+			ids = append(ids, node.NodeID)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+//EnableAutoscaling makes the pump scale the pools of the policies with the
+//provider, pools without a policy are left alone
+func (e *Engine) EnableAutoscaling(provider Autoscaler, policies []AutoscalePolicy) error {
+	as := &autoscaling{provider: provider, policies: map[string]AutoscalePolicy{}}
+	for _, p := range policies {
+		switch {
+		case p.PoolID == "":
+			return errors.New("autoscale policy without a pool")
+		case p.Min < 0 || p.Max < p.Min:
+			return errors.Errorf("autoscale policy of pool '%s' must have 0 <= min <= max", p.PoolID)
+		case p.NodeCapacity < 1:
+			return errors.Errorf("autoscale policy of pool '%s' must have a node capacity of at least 1", p.PoolID)
+		}
+
+		if _, ok := as.policies[p.PoolID]; ok {
+			return errors.Errorf("pool '%s' has more than one autoscale policy", p.PoolID)
+		}
+
+		as.policies[p.PoolID] = p
+	}
+
+	e.as = as
+	return nil
+}
+
+//recordDemand records that a task or gang did not fit any node of its pool
+func (e *Engine) recordDemand(ctx context.Context, id, poolID string, size int64) {
+	if err := model.PutDemand(ctx, e.db, id, poolID, size, time.Now().Add(DemandWindow)); err != nil {
+		e.logs.With(Fields{FieldPoolID: poolID}).Printf("[WARN] Failed to record demand of '%s': %v", id, err)
+	}
+}
+
+//Signals reads the demand and capacity of every pool that has nodes or an
+//autoscale policy
+func (e *Engine) Signals(ctx context.Context) (signals []*PoolSignals, err error) {
+	nodes, err := model.ListNodes(ctx, e.db)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list nodes")
+	}
+
+	pools := map[string]*PoolSignals{}
+	if e.as != nil {
+		for poolID := range e.as.policies {
+			pools[poolID] = &PoolSignals{PoolID: poolID}
+		}
+	}
+
+	for _, node := range nodes {
+		sig, ok := pools[node.PoolID]
+		if !ok {
+			sig = &PoolSignals{PoolID: node.PoolID}
+			pools[node.PoolID] = sig
+		}
+
+		if node.Drain {
+			sig.Drained = append(sig.Drained, node)
+			continue
+		}
+
+		sig.Active = append(sig.Active, node)
+		sig.IdleCapacity += node.Cap
+	}
+
+	for _, sig := range pools {
+		demand, err := model.PoolDemand(ctx, e.db, sig.PoolID, MaxDemandPerPool)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to query demand of pool '%s'", sig.PoolID)
+		}
+
+		for _, d := range demand {
+			sig.Demand += d.Size
+		}
+
+		signals = append(signals, sig)
+	}
+
+	sort.Slice(signals, func(i, j int) bool { return signals[i].PoolID < signals[j].PoolID })
+	return signals, nil
+}
+
+//Autoscale publishes the demand and idle capacity of the pools and scales
+//the pools that have a policy. Pools are scaled up for their demand and to
+//their min, they are scaled in by draining empty nodes while there is no
+//demand and removing them once they are drained.
+func (e *Engine) Autoscale(ctx context.Context) (err error) {
+	signals, err := e.Signals(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to read pool signals")
+	}
+
+	PoolDemand.Reset()
+	PoolIdleCapacity.Reset()
+	for _, sig := range signals {
+		PoolDemand.WithLabelValues(sig.PoolID).Set(float64(sig.Demand))
+		PoolIdleCapacity.WithLabelValues(sig.PoolID).Set(float64(sig.IdleCapacity))
+	}
+
+	if e.as == nil {
+		return nil
+	}
+
+	for _, sig := range signals {
+		policy, ok := e.as.policies[sig.PoolID]
+		if !ok {
+			continue
+		}
+
+		if err = e.scalePool(ctx, policy, sig); err != nil {
+			return errors.Wrapf(err, "failed to scale pool '%s'", sig.PoolID)
+		}
+	}
+
+	return nil
+}
+
+//autoscaleLoop autoscales the pools every AutoscaleInterval while the pump
+//holds the leader lease. It runs apart from the leader cycle as the provider
+//may take longer than the lease lasts.
+func (e *Engine) autoscaleLoop(ctx context.Context, pumpID string, doneCh chan<- struct{}) {
+	defer close(doneCh)
+
+	ticker := time.NewTicker(AutoscaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lease, err := e.Leader(ctx)
+			if err != nil {
+				if errors.Cause(err) != model.ErrLeaseNotExists {
+					e.logs.Printf("[WARN] Failed to get pump leader, not autoscaling: %v", err)
+				}
+
+				continue
+			}
+
+			if lease.Holder != pumpID || lease.Expired() {
+				continue
+			}
+
+			if err = e.Autoscale(ctx); err != nil {
+				e.logs.Printf("[WARN] Failed to autoscale pools: %v", err)
+			}
+		}
+	}
+}
+
+//cooling returns whether the pool was scaled up within the AutoscaleCooldown
+func (e *Engine) cooling(ctx context.Context, poolID string) (bool, error) {
+	lease, err := model.GetLease(ctx, e.db, model.LeasePK{LeaseID: AutoscaleLeasePrefix + poolID})
+	if err != nil {
+		if errors.Cause(err) == model.ErrLeaseNotExists {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "failed to get cooldown lease")
+	}
+
+	return !lease.Expired(), nil
+}
+
+//cooldown starts the cooldown of the pool, it fails with model.ErrLeaseHeld
+//as the cause if the pool is cooling down already. The returned holder ends
+//the cooldown early.
+func (e *Engine) cooldown(ctx context.Context, poolID string) (holder string, err error) {
+	if holder, err = uuid.GenerateUUID(); err != nil {
+		return "", errors.Wrap(err, "failed to generate cooldown holder")
+	}
+
+	if err = model.AcquireLease(ctx, e.db, model.LeasePK{LeaseID: AutoscaleLeasePrefix + poolID}, holder, time.Now().Add(AutoscaleCooldown)); err != nil {
+		return "", errors.Wrap(err, "failed to acquire cooldown lease")
+	}
+
+	return holder, nil
+}
+
+//scaleDown hands the nodes that the autoscaler drained and that are empty to
+//the provider. Each node is taken before it is handed over so that it is
+//removed once, the nodes are marked again if the provider fails.
+func (e *Engine) scaleDown(ctx context.Context, sig *PoolSignals) error {
+	var ids []string
+	for _, id := range scaledIn(sig.Drained) {
+		if err := model.TakeScaleIn(ctx, e.db, model.NodePK{NodeID: id}); err != nil {
+			if errors.Cause(err) == model.ErrNodeNotScaledIn {
+				continue
+			}
+
+			return errors.Wrapf(err, "failed to take node '%s'", id)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if len(ids) < 1 {
+		return nil
+	}
+
+	logs := e.logs.With(Fields{FieldPoolID: sig.PoolID})
+	logs.Printf("[INFO] Scaling down pool '%s' by removing %d drained nodes", sig.PoolID, len(ids))
+	if err := e.as.provider.ScaleDown(ctx, sig.PoolID, ids); err != nil {
+		for _, id := range ids {
+			if merr := model.DrainNodeForScaleIn(ctx, e.db, model.NodePK{NodeID: id}); merr != nil {
+				logs.Printf("[WARN] Failed to mark node '%s' for scale in again: %v", id, merr)
+			}
+		}
+
+		return errors.Wrap(err, "failed to scale down")
+	}
+
+	AutoscaleActions.WithLabelValues(sig.PoolID, "down").Add(float64(len(ids)))
+	return nil
+}
+
+//scalePool scales a pool within the bounds of its policy
+func (e *Engine) scalePool(ctx context.Context, policy AutoscalePolicy, sig *PoolSignals) error {
+	if err := e.scaleDown(ctx, sig); err != nil {
+		return err
+	}
+
+	//nodes that were added may not have registered yet
+	cooling, err := e.cooling(ctx, sig.PoolID)
+	if err != nil || cooling {
+		return err
+	}
+
+	active := len(sig.Active)
+	target := active + int((sig.Demand+policy.NodeCapacity-1)/policy.NodeCapacity)
+	if target < policy.Min {
+		target = policy.Min
+	}
+
+	if target > policy.Max {
+		target = policy.Max
+	}
+
+	logs := e.logs.With(Fields{FieldPoolID: sig.PoolID})
+	if target > active {
+		holder, err := e.cooldown(ctx, sig.PoolID)
+		if err != nil {
+			if errors.Cause(err) == model.ErrLeaseHeld {
+				return nil
+			}
+
+			return err
+		}
+
+		logs.Printf("[INFO] Scaling up pool '%s' from %d to %d nodes for a demand of %d", sig.PoolID, active, target, sig.Demand)
+		if err = e.as.provider.ScaleUp(ctx, sig.PoolID, target-active); err != nil {
+			if rerr := model.ReleaseLease(ctx, e.db, model.LeasePK{LeaseID: AutoscaleLeasePrefix + sig.PoolID}, holder); rerr != nil {
+				logs.Printf("[WARN] Failed to end cooldown of pool '%s': %v", sig.PoolID, rerr)
+			}
+
+			return errors.Wrap(err, "failed to scale up")
+		}
+
+		AutoscaleActions.WithLabelValues(sig.PoolID, "up").Add(float64(target - active))
+		return nil
+	}
+
+	if sig.Demand > 0 || active <= policy.Min {
+		return nil
+	}
+
+	//empty nodes are drained first so no claim is placed on them while the
+	//provider removes them, that happens in a later round
+	ids := empty(sig.Active)
+	if len(ids) > active-policy.Min {
+		ids = ids[:active-policy.Min]
+	}
+
+	for _, id := range ids {
+		logs.With(Fields{FieldNodeID: id}).Printf("[INFO] Draining node '%s' to scale in pool '%s'", id, sig.PoolID)
+		if err := model.DrainNodeForScaleIn(ctx, e.db, model.NodePK{NodeID: id}); err != nil {
+			if errors.Cause(err) == model.ErrNodeNotEmpty {
+				continue
+			}
+
+			return errors.Wrapf(err, "failed to drain node '%s'", id)
+		}
+
+		AutoscaleActions.WithLabelValues(sig.PoolID, "drain").Inc()
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+)
+
+var (
+	//MinScheduleBackpressure is the pause between schedule rounds after the
+	//database throttled for the first time
+	MinScheduleBackpressure = time.Millisecond * 100
+
+	//MaxScheduleBackpressure caps the pause between schedule rounds while the
+	//database keeps throttling
+	MaxScheduleBackpressure = time.Second * 5
+)
+
+//backpressure slows the schedule workers down while the database throttles
+//them. Every throttled request doubles the pause before the next round of
+//any worker, every round that was paused halves it again.
+type backpressure struct {
+	mu    sync.Mutex
+	pause time.Duration
+}
+
+//observe raises the pause if the error is caused by throttling, it returns
+//whether it was
+func (bp *backpressure) observe(err error) bool {
+	if err == nil || !model.IsThrottled(err) {
+		return false
+	}
+
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	bp.pause *= 2
+	if bp.pause < MinScheduleBackpressure {
+		bp.pause = MinScheduleBackpressure
+	}
+
+	if bp.pause > MaxScheduleBackpressure {
+		bp.pause = MaxScheduleBackpressure
+	}
+
+	ScheduleThrottles.Inc()
+	ScheduleBackpressure.Set(bp.pause.Seconds())
+	return true
+}
+
+//wait pauses for the current backpressure, if any, and then lowers it
+func (bp *backpressure) wait(ctx context.Context) {
+	bp.mu.Lock()
+	pause := bp.pause
+	bp.mu.Unlock()
+	if pause <= 0 {
+		return
+	}
+
+	select {
+	case <-time.After(pause):
+	case <-ctx.Done():
+		return
+	}
+
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	bp.pause /= 2
+	if bp.pause < MinScheduleBackpressure {
+		bp.pause = 0
+	}
+
+	ScheduleBackpressure.Set(bp.pause.Seconds())
+}
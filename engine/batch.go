@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	//MaxSubmitBatchSize limits the number of tasks that are submitted in one batch
+	MaxSubmitBatchSize = 100
+)
+
+//SubmitResult is the outcome of submitting one task of a batch
+type SubmitResult struct {
+	//TaskID is set once the task was stored, or to the task that was submitted
+	//before with the same idempotency key
+	TaskID string
+
+	//Err is set if the task was not submitted
+	Err error
+}
+
+//SubmitBatch records a task for every spec and sends their schedule messages
+//in batches per schedule queue, it returns a result for every spec in the same
+//order. Each task is checked against the quotas and stored on its own with
+//its schedule message in the outbox, a message that was not accepted by the
+//queue is left to the outbox sweep.
+func (e *Engine) SubmitBatch(ctx context.Context, specs []TaskSpec) (results []SubmitResult, err error) {
+	if len(specs) > MaxSubmitBatchSize {
+		return nil, errors.Errorf("a batch holds at most %d tasks, got %d", MaxSubmitBatchSize, len(specs))
+	}
+
+	ctx, span := startSpan(ctx, "factory.submit_batch", trace.SpanKindProducer, Fields{})
+	defer func() { endSpan(span, err) }()
+
+	results = make([]SubmitResult, len(specs))
+	subs, pending := make([]*submission, len(specs)), map[string][]int{}
+	for i, spec := range specs {
+		sub, err := newSubmission(spec)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		if err = e.store(ctx, spec, sub); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		if sub.earlier != "" {
+			results[i].TaskID = sub.earlier
+			continue
+		}
+
+		results[i].TaskID, subs[i] = sub.task.TaskID, sub
+		if sub.out != nil {
+			pending[sub.out.Queue] = append(pending[sub.out.Queue], i)
+		}
+	}
+
+	sent, deferred := 0, 0
+	for queue, idxs := range pending {
+		for len(idxs) > 0 {
+			n := MaxQueueBatchSize
+			if len(idxs) < n {
+				n = len(idxs)
+			}
+
+			chunk := idxs[:n]
+			idxs = idxs[n:]
+
+			msgs := []QueueMsg{}
+			for _, i := range chunk {
+				msgs = append(msgs, QueueMsg{ID: subs[i].out.DeduplicationID(), Body: subs[i].msg, Delay: subs[i].delay})
+			}
+
+			failed, berr := SendScheduleMessageBatch(ctx, e.q, queue, msgs)
+			for _, i := range chunk {
+				out, logs := subs[i].out, e.logs.With(Fields{FieldTaskID: subs[i].task.TaskID})
+				serr := berr
+				if serr == nil {
+					serr = failed[out.DeduplicationID()]
+				}
+
+				if serr != nil {
+					logs.Printf("[WARN] Failed to send schedule message of task '%s', leaving it to the outbox sweep: %v", subs[i].task.TaskPK, serr)
+					deferred++
+					continue
+				}
+
+				sent++
+				if derr := model.DeleteOutbox(ctx, e.db, out.OutboxPK); derr != nil {
+					logs.Printf("[WARN] Failed to delete outbox message of task '%s', the sweep sends it again: %v", subs[i].task.TaskPK, derr)
+				}
+			}
+		}
+	}
+
+	e.logs.Printf("[INFO] Submitted batch of %d tasks, %d were scheduled right away and %d were left to the outbox sweep", len(specs), sent, deferred)
+	return results, nil
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+//Cancel marks a task as cancelled so it is no longer (re)scheduled and
+//removes its claim, the node's agent then stops the container on its next
+//heartbeat
+func (e *Engine) Cancel(ctx context.Context, taskID string) (*model.Task, error) {
+	logs := e.logs.With(Fields{FieldTaskID: taskID})
+	task, err := e.cancelTask(ctx, model.TaskPK{TaskID: taskID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to cancel task")
+	}
+
+	logs.Printf("[INFO] Cancelled task '%s'", task.TaskPK)
+	if task.ClaimID == "" {
+		return task, nil
+	}
+
+	claim, err := model.GetClaim(ctx, e.db, model.ClaimPK{ClaimID: task.ClaimID})
+	if err != nil {
+		if errors.Cause(err) == model.ErrClaimNotExists {
+			return task, nil
+		}
+
+		return nil, errors.Wrapf(err, "failed to get claim '%s'", task.ClaimID)
+	}
+
+	if err = e.removeClaim(ctx, claim, nil); err != nil {
+		if errors.Cause(err) == model.ErrClaimNotExists {
+			return task, nil
+		}
+
+		return nil, errors.Wrapf(err, "failed to remove claim of task '%s'", task.TaskPK)
+	}
+
+	logs.With(claimFields(claim)).Printf("[INFO] Removed claim '%s' of cancelled task", claim.ClaimPK)
+	return task, nil
+}
+
+//cancelTask marks the task as cancelled, a pending task is no longer counted
+//as queued towards its quotas. It is read again if its state changed
+//concurrently, e.g. because it was scheduled.
+func (e *Engine) cancelTask(ctx context.Context, pk model.TaskPK) (*model.Task, error) {
+	for {
+		task, err := model.GetTask(ctx, e.db, pk)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get task")
+		}
+
+		if task.Finished() {
+			return nil, model.ErrTaskCancelled
+		}
+
+		var items []*model.TxItem
+		if task.Quota && task.State == model.TaskStatePending {
+			for _, qpk := range quotaPKs(task.PoolID, task.Submitter) {
+				item, err := model.TxReleaseQuota(qpk, 0, 1)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to create quota item")
+				}
+
+				items = append(items, item)
+			}
+		}
+
+		cancelled, err := model.CancelTask(ctx, e.db, task, items...)
+		if errors.Cause(err) == model.ErrTaskCancelled {
+			continue //the task changed state since it was read
+		}
+
+		return cancelled, err
+	}
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"context"
+	"strings"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	//MaxTaskLogLines is the number of log lines of an exited container that are kept with its task
+	MaxTaskLogLines = 100
+
+	//MaxTaskLogSize limits the size of the logs kept with a task, older output is cut off
+	MaxTaskLogSize = 16 * 1024
+)
+
+//completeExited records the outcome of the tasks whose containers exited on
+//this node. The claim is removed, its capacity returned and the exit code and
+//the tail of the logs are stored with the task in one transaction, after
+//which the container is removed.
+func (e *Engine) completeExited(ctx context.Context, exe *DockerExec, node *model.Node) error {
+	containers, err := exe.Containers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list containers")
+	}
+
+	for _, c := range containers {
+		if c.Running {
+			continue
+		}
+
+		claim, err := model.GetClaim(ctx, e.db, model.ClaimPK{ClaimID: c.ClaimID})
+		if err != nil {
+			if errors.Cause(err) != model.ErrClaimNotExists {
+				return errors.Wrapf(err, "failed to get claim '%s'", c.ClaimID)
+			}
+
+			e.logs.With(Fields{FieldClaimID: c.ClaimID}).Printf("[INFO] Exited container '%s' claim '%s' no longer exists, removing...", c.ID, c.ClaimID)
+			if err = exe.RemoveContainer(ctx, c.ID); err != nil {
+				return errors.Wrapf(err, "failed to remove container '%s'", c.ID)
+			}
+
+			continue
+		}
+
+		if claim.NodeID != node.NodeID {
+			continue //left to collectOrphans or the node that owns it
+		}
+
+		if err = e.complete(ctx, exe, c, claim); err != nil {
+			return errors.Wrapf(err, "failed to complete claim '%s'", claim.ClaimPK)
+		}
+	}
+
+	return nil
+}
+
+//complete records the outcome of the container that ran the claim
+func (e *Engine) complete(ctx context.Context, exe *DockerExec, c Container, claim *model.Claim) error {
+	logs := e.logs.With(claimFields(claim))
+	exit, err := exe.ExitCode(ctx, c.ID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get exit code")
+	}
+
+	out, err := exe.Logs(ctx, c.ID, MaxTaskLogLines)
+	if err != nil {
+		return errors.Wrap(err, "failed to get logs")
+	}
+
+	if len(out) > MaxTaskLogSize {
+		out = out[len(out)-MaxTaskLogSize:]
+		if i := strings.IndexByte(out, '\n'); i >= 0 {
+			out = out[i+1:]
+		}
+	}
+
+	out = strings.ToValidUTF8(out, "")
+
+	item, err := model.TxCompleteTask(claim, exit, out)
+	if err != nil {
+		return errors.Wrap(err, "failed to create task item")
+	}
+
+	err = e.removeClaim(ctx, claim, nil, item)
+	if errors.Cause(err) == model.ErrTaskNotScheduled {
+		logs.Printf("[INFO] Task of claim '%s' is no longer scheduled with it, removing the claim only", claim.ClaimPK)
+		err = e.removeClaim(ctx, claim, nil)
+	}
+
+	if err != nil && errors.Cause(err) != model.ErrClaimNotExists {
+		return err
+	}
+
+	logs.Printf("[INFO] Container '%s' of claim '%s' exited with code %d", c.ID, claim.ClaimPK, exit)
+	if claim.WorkflowID != "" {
+		e.advanceWorkflowOf(ctx, claim)
+	}
+
+	if err = exe.RemoveContainer(ctx, c.ID); err != nil {
+		return errors.Wrapf(err, "failed to remove container '%s'", c.ID)
+	}
+
+	return nil
+}
+
+//TaskLogs returns the tail of the logs of a task that succeeded or failed
+func (e *Engine) TaskLogs(ctx context.Context, taskID string) (string, error) {
+	task, err := model.GetTask(ctx, e.db, model.TaskPK{TaskID: taskID})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get task")
+	}
+
+	if task.State != model.TaskStateSucceeded && task.State != model.TaskStateFailed {
+		return "", model.ErrTaskNotFinished
+	}
+
+	return task.Logs, nil
+}
@@ -0,0 +1,232 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	//MaxDueCronsPerPartition determines the max nr of crons per partition that are run per cycle
+	MaxDueCronsPerPartition = int64(10)
+
+	//MaxCronActive limits the number of unfinished runs that are kept track of per cron
+	MaxCronActive = 20
+)
+
+//CronSpec describes a task that is submitted on a recurring schedule
+type CronSpec struct {
+	TaskSpec
+
+	//Schedule is a standard cron expression of five fields, e.g: "*/5 * * * *"
+	Schedule string
+
+	//Concurrency is one of the model's concurrency policies, it determines
+	//what happens to a run while earlier runs have not finished
+	Concurrency string
+
+	//CatchUp is the max number of runs that are submitted at once when
+	//several were missed, e.g. because no pump was leader
+	CatchUp int64
+}
+
+//CreateCron stores a cron of which the first run is due at the next moment
+//that matches its schedule, it returns the cron id
+func (e *Engine) CreateCron(ctx context.Context, spec CronSpec) (cronID string, err error) {
+	sched, err := cron.ParseStandard(spec.Schedule)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid schedule")
+	}
+
+	if !model.ValidPriority(spec.Priority) {
+		return "", errors.Errorf("unknown priority '%s'", spec.Priority)
+	}
+
+	if spec.PoolID == "" || spec.Size < 1 {
+		return "", errors.New("a pool and a size of at least 1 are required")
+	}
+
+	if spec.CatchUp < 0 {
+		return "", errors.New("catch up can't be negative")
+	}
+
+	c, err := model.NewCron(spec.Schedule, spec.PoolID, spec.Size, spec.Submitter, sched.Next(time.Now()))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create cron")
+	}
+
+	switch spec.Concurrency {
+	case "":
+	case model.ConcurrencyAllow, model.ConcurrencyForbid, model.ConcurrencyReplace:
+		c.Concurrency = spec.Concurrency
+	default:
+		return "", errors.Errorf("unknown concurrency policy '%s'", spec.Concurrency)
+	}
+
+	if spec.Priority != "" {
+		c.Priority = spec.Priority
+	}
+
+	c.CatchUp = spec.CatchUp
+	if err = model.PutCron(ctx, e.db, c); err != nil {
+		return "", errors.Wrap(err, "failed to store cron")
+	}
+
+	e.logs.With(Fields{FieldCronID: c.CronID, FieldPoolID: c.PoolID}).Printf("[INFO] Created cron with schedule '%s', first run at %s", c.Schedule, time.Unix(c.Next, 0).Format(time.RFC3339))
+	return c.CronID, nil
+}
+
+//DeleteCron removes a cron, runs that were submitted are left as is
+func (e *Engine) DeleteCron(ctx context.Context, cronID string) error {
+	if err := model.DeleteCron(ctx, e.db, model.CronPK{CronID: cronID}); err != nil {
+		return errors.Wrap(err, "failed to delete cron")
+	}
+
+	e.logs.With(Fields{FieldCronID: cronID}).Printf("[INFO] Deleted cron '%s'", cronID)
+	return nil
+}
+
+//Crons returns all crons
+func (e *Engine) Crons(ctx context.Context) ([]*model.Cron, error) {
+	crons, err := model.ListCrons(ctx, e.db)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list crons")
+	}
+
+	return crons, nil
+}
+
+//RunCrons queries the database for crons with a run that is due and submits them
+func (e *Engine) RunCrons(ctx context.Context) (err error) {
+	due, err := model.DueCrons(ctx, e.db, MaxDueCronsPerPartition)
+	if err != nil {
+		return errors.Wrap(err, "failed to query due crons")
+	}
+
+	e.logs.Printf("[INFO] found %d due crons", len(due))
+	for _, c := range due {
+		if err = e.runCron(ctx, c); err != nil {
+			e.logs.With(Fields{FieldCronID: c.CronID}).Printf("[WARN] Failed to run cron '%s': %v", c.CronPK, err)
+		}
+	}
+
+	return nil
+}
+
+//catchUp walks the schedule from the next run up to now and returns how
+//many runs are to be submitted, at most the catch up limit or one if it has
+//none, and how many were missed beyond that. The walk stops after a hundred times
+//the limit of missed runs so that a long outage doesn't walk endlessly.
+func catchUp(sched cron.Schedule, next, now time.Time, limit int64) (runs, missed int64) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	for t := next; !t.After(now); t = sched.Next(t) {
+		if runs >= limit {
+			missed++
+			if missed >= limit*100 {
+				break
+			}
+
+			continue
+		}
+
+		runs++
+	}
+
+	return runs, missed
+}
+
+//runCron submits the runs of the cron that became due. The cron is advanced
+//before submitting so that a run is submitted at most once, even if another
+//pump became leader in the mean time.
+func (e *Engine) runCron(ctx context.Context, c *model.Cron) error {
+	logs := e.logs.With(Fields{FieldCronID: c.CronID, FieldPoolID: c.PoolID})
+	sched, err := cron.ParseStandard(c.Schedule)
+	if err != nil {
+		return errors.Wrap(err, "invalid schedule")
+	}
+
+	now := time.Now()
+	runs, missed := catchUp(sched, time.Unix(c.Next, 0), now, c.CatchUp)
+	if err = model.AdvanceCron(ctx, e.db, c.CronPK, c.Next, sched.Next(now).Unix()); err != nil {
+		if errors.Cause(err) == model.ErrCronAdvanced {
+			logs.Printf("[INFO] Cron was advanced concurrently, its runs are left to the other pump")
+			return nil
+		}
+
+		return errors.Wrap(err, "failed to advance cron")
+	}
+
+	if missed > 0 {
+		logs.Printf("[WARN] Skipping at least %d missed runs, catching up on %d", missed, runs)
+	}
+
+	active := []string{}
+	for _, taskID := range c.Active {
+		task, err := model.GetTask(ctx, e.db, model.TaskPK{TaskID: taskID})
+		if err != nil {
+			if errors.Cause(err) == model.ErrTaskNotExists {
+				continue
+			}
+
+			return errors.Wrapf(err, "failed to get task '%s'", taskID)
+		}
+
+		if !task.Finished() {
+			active = append(active, taskID)
+		}
+	}
+
+	switch c.Concurrency {
+	case model.ConcurrencyForbid:
+		if len(active) > 0 {
+			logs.Printf("[INFO] Skipping run, %d earlier runs have not finished", len(active))
+			runs = 0
+		} else if runs > 1 {
+			runs = 1
+		}
+
+	case model.ConcurrencyReplace:
+		for _, taskID := range active {
+			if _, err := e.Cancel(ctx, taskID); err != nil && errors.Cause(err) != model.ErrTaskCancelled {
+				return errors.Wrapf(err, "failed to cancel earlier run '%s'", taskID)
+			}
+
+			logs.With(Fields{FieldTaskID: taskID}).Printf("[INFO] Cancelled earlier run to replace it")
+		}
+
+		active, runs = []string{}, 1
+	}
+
+	var serr error
+	for i := int64(0); i < runs; i++ {
+		taskID, err := e.Submit(ctx, TaskSpec{PoolID: c.PoolID, Size: c.Size, Submitter: c.Submitter, Priority: c.Priority})
+		if err != nil {
+			if errors.Cause(err) == model.ErrQuotaExceeded {
+				logs.Printf("[WARN] Skipping run, it doesn't fit the quotas: %v", err)
+				continue
+			}
+
+			serr = errors.Wrap(err, "failed to submit run")
+			break //the runs that were submitted are still recorded
+		}
+
+		logs.With(Fields{FieldTaskID: taskID}).Printf("[INFO] Submitted run of cron '%s'", c.CronPK)
+		active = append(active, taskID)
+	}
+
+	if len(active) > MaxCronActive {
+		active = active[len(active)-MaxCronActive:]
+	}
+
+	if err = model.SetCronActive(ctx, e.db, c.CronPK, active); err != nil {
+		return errors.Wrap(err, "failed to record active runs")
+	}
+
+	return serr
+}
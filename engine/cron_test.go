@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestCatchUp(t *testing.T) {
+	everyFiveMinutes, err := cron.ParseStandard("*/5 * * * *")
+	if err != nil {
+		t.Fatalf("failed to parse schedule: %v", err)
+	}
+
+	next := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		name      string
+		now       time.Time
+		limit     int64
+		expRuns   int64
+		expMissed int64
+	}{
+		{"not yet due", next.Add(-time.Second), 3, 0, 0},
+		{"due right now", next, 3, 1, 0},
+		{"due since a while", next.Add(4 * time.Minute), 3, 1, 0},
+		{"missed runs within the limit", next.Add(10 * time.Minute), 3, 3, 0},
+		{"missed runs beyond the limit", next.Add(30 * time.Minute), 3, 3, 4},
+		{"no limit submits one", next.Add(30 * time.Minute), 0, 1, 6},
+		{"negative limit submits one", next.Add(30 * time.Minute), -1, 1, 6},
+		{"long outage stops the walk", next.Add(24 * time.Hour), 1, 1, 100},
+		{"walk stops relative to the limit", next.Add(7 * 24 * time.Hour), 2, 2, 200},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			runs, missed := catchUp(everyFiveMinutes, next, c.now, c.limit)
+			if runs != c.expRuns || missed != c.expMissed {
+				t.Fatalf("expected %d runs and %d missed, got: %d runs and %d missed", c.expRuns, c.expMissed, runs, missed)
+			}
+		})
+	}
+}
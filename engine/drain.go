@@ -0,0 +1,19 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+//Drain stops a node from taking new claims, the claims it already has are
+//left to run. Combine with Evict to move them elsewhere.
+func (e *Engine) Drain(ctx context.Context, nodeID string) error {
+	e.logs.With(Fields{FieldNodeID: nodeID}).Printf("[INFO] Draining node '%s'", nodeID)
+	if err := model.DrainNode(ctx, e.db, model.NodePK{NodeID: nodeID}); err != nil {
+		return errors.Wrap(err, "failed to drain node")
+	}
+
+	return nil
+}
@@ -1,23 +1,36 @@
 package engine
 
 import (
-	"log"
+	"time"
 
 	"github.com/advanderveer/factory/model"
 )
 
 //Engine controls the factory
 type Engine struct {
-	logs *log.Logger
+	logs Logger
 	db   model.DB
 	q    Q
+
+	//bp and sq are shared by the schedule workers
+	bp *backpressure
+	sq *subQueues
+
+	//as is set when the pump scales pools
+	as *autoscaling
+
+	//wfCursor is the creation time from which the leader reads the next page
+	//of running workflows
+	wfCursor int64
 }
 
-//New creates a new Engine
-func New(logs *log.Logger, db model.DB, q Q) *Engine {
+//New creates a new Engine, calls to the database and queues are traced
+func New(logs Logger, db model.DB, q Q) *Engine {
 	return &Engine{
 		logs: logs,
-		db:   db,
-		q:    q,
+		db:   tracedDB{db},
+		q:    tracedQ{q},
+		bp:   &backpressure{},
+		sq:   &subQueues{idle: map[string]time.Time{}},
 	}
 }
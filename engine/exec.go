@@ -4,13 +4,14 @@ import (
 	"bufio"
 	"context"
 	"fmt"
-	"log"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/advanderveer/factory/model"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -23,16 +24,26 @@ var (
 	//DockerRunExecTimeout is how long the executor will wait for docker runs
 	DockerRunExecTimeout = time.Second * 10
 
+	//DockerLogsExecTimeout is how long the executor will wait for container logs
+	DockerLogsExecTimeout = time.Second * 5
+
 	//DiscardLines does nothing with each line
 	DiscardLines = func(line string) error {
 		return nil
 	}
 )
 
+//Container was started by an executor to run a claim
+type Container struct {
+	ID      string
+	ClaimID string
+	Running bool
+}
+
 //DockerExec uses docker binary to exec
 type DockerExec struct {
 	dpath string
-	logs  *log.Logger
+	logs  Logger
 	db    model.DB
 
 	Incoming chan RunMsg
@@ -40,7 +51,7 @@ type DockerExec struct {
 }
 
 //NewDockerExec will create a Docker executer
-func NewDockerExec(logs *log.Logger, db model.DB) (*DockerExec, error) {
+func NewDockerExec(logs Logger, db model.DB) (*DockerExec, error) {
 	dockerPath, err := exec.LookPath("docker")
 	if err != nil {
 		return nil, fmt.Errorf("failed to find Docker executable in path: %v, is it installed?", err)
@@ -48,7 +59,7 @@ func NewDockerExec(logs *log.Logger, db model.DB) (*DockerExec, error) {
 
 	logs.Printf("[DEBUG] using Docker executable '%s'", dockerPath)
 	exec := &DockerExec{
-		db:       db,
+		db:       tracedDB{db},
 		dpath:    dockerPath,
 		logs:     logs,
 		Done:     make(chan struct{}),
@@ -58,10 +69,30 @@ func NewDockerExec(logs *log.Logger, db model.DB) (*DockerExec, error) {
 	return exec, nil
 }
 
+//dockerCommand names the Docker command that is run with the arguments
+func dockerCommand(arg []string) string {
+	if len(arg) > 1 && arg[0] == "container" {
+		return arg[0] + " " + arg[1]
+	}
+
+	if len(arg) > 0 {
+		return arg[0]
+	}
+
+	return ""
+}
+
 func (exe *DockerExec) execDockerTimeout(ctx context.Context, to time.Duration, lineHandler func(line string) error, arg ...string) (err error) {
 	ctx, cancel := context.WithTimeout(ctx, to)
 	defer cancel()
 
+	ctx, span := tracer().Start(ctx, "docker "+dockerCommand(arg), trace.WithSpanKind(trace.SpanKindClient))
+	start := time.Now()
+	defer func() {
+		DockerDuration.WithLabelValues(dockerCommand(arg), result(err)).Observe(time.Since(start).Seconds())
+		endSpan(span, err)
+	}()
+
 	cmd := exec.CommandContext(ctx, exe.dpath, arg...)
 	rc, err := cmd.StdoutPipe()
 	if err != nil {
@@ -106,12 +137,13 @@ func (exe *DockerExec) sendHeartbeats(ctx context.Context, nodeID string) error
 			return errors.Errorf("unexpected docker ps line: '%s'", line)
 		}
 
-		exe.logs.Printf("[DEBUG] Send heartbeat container: '%s' claim: '%s' as node: '%s'", fields[0], fields[1], nodeID)
+		logs := exe.logs.With(Fields{FieldClaimID: fields[1]})
+		logs.Printf("[DEBUG] Send heartbeat container: '%s' claim: '%s' as node: '%s'", fields[0], fields[1], nodeID)
 		pk := model.ClaimPK{ClaimID: fields[1]}
 		err := model.IncrementClaimTTL(ctx, exe.db, pk, nodeID, ClaimHeartbeatTimeout*2)
 		if err != nil {
 			if errors.Cause(err) == model.ErrClaimNotExists {
-				exe.logs.Printf("[INFO] Container '%s' claim '%s' for node '%s' no longer exists, stopping...", fields[0], fields[1], nodeID)
+				logs.Printf("[INFO] Container '%s' claim '%s' for node '%s' no longer exists, stopping...", fields[0], fields[1], nodeID)
 
 				args := []string{"container", "stop", "-t=10", fields[0]}
 				if err = exe.execDockerTimeout(ctx, time.Second*11, DiscardLines, args...); err != nil {
@@ -132,10 +164,73 @@ func (exe *DockerExec) sendHeartbeats(ctx context.Context, nodeID string) error
 	return nil
 }
 
-func (exe *DockerExec) startContainer(ctx context.Context, claimID string) (err error) {
-	args := []string{"container", "run", "-d", "-l", "factory.claim=" + claimID, "redis"}
+//ExitCode returns the exit code of a container that exited
+func (exe *DockerExec) ExitCode(ctx context.Context, containerID string) (code int64, err error) {
+	args := []string{"container", "inspect", "--format", "{{.State.ExitCode}}", containerID}
+	if err = exe.execDocker(ctx, func(line string) error {
+		code, err = strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+		return err
+	}, args...); err != nil {
+		return 0, errors.Wrapf(err, "failed to run: docker %v", args)
+	}
+
+	return code, nil
+}
+
+//Logs returns the last lines a container wrote to its standard output
+func (exe *DockerExec) Logs(ctx context.Context, containerID string, lines int) (string, error) {
+	out := []string{}
+	args := []string{"container", "logs", "--tail", strconv.Itoa(lines), containerID}
+	if err := exe.execDockerTimeout(ctx, DockerLogsExecTimeout, func(line string) error {
+		out = append(out, line)
+		return nil
+	}, args...); err != nil {
+		return "", errors.Wrapf(err, "failed to run: docker %v", args)
+	}
+
+	return strings.Join(out, "\n"), nil
+}
+
+//Containers lists all containers that were started for a claim, running or not
+func (exe *DockerExec) Containers(ctx context.Context) (containers []Container, err error) {
+	psargs := []string{"container", "ps", "-a", "-f", "label=factory.claim", "--format", "{{.ID}}\t{{.Label \"factory.claim\"}}\t{{.Status}}"}
+	if err = exe.execDocker(ctx, func(line string) error {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			return errors.Errorf("unexpected docker ps line: '%s'", line)
+		}
+
+		containers = append(containers, Container{
+			ID:      fields[0],
+			ClaimID: fields[1],
+			Running: strings.HasPrefix(fields[2], "Up"),
+		})
+
+		return nil
+	}, psargs...); err != nil {
+		return nil, errors.Wrapf(err, "failed to run: docker %v", psargs)
+	}
+
+	return containers, nil
+}
+
+//RemoveContainer will stop and remove a container
+func (exe *DockerExec) RemoveContainer(ctx context.Context, containerID string) (err error) {
+	args := []string{"container", "rm", "-f", containerID}
+	if err = exe.execDockerTimeout(ctx, time.Second*11, DiscardLines, args...); err != nil {
+		return errors.Wrapf(err, "failed to run: docker %v", args)
+	}
+
+	return nil
+}
+
+func (exe *DockerExec) startContainer(ctx context.Context, msg RunMsg) (err error) {
+	ctx, span := startSpan(trace.ContextWithRemoteSpanContext(ctx, msg.spanCtx), "factory.run", trace.SpanKindInternal, Fields{FieldClaimID: msg.ClaimID, FieldTaskID: msg.TaskID})
+	defer func() { endSpan(span, err) }()
+
+	args := []string{"container", "run", "-d", "-l", "factory.claim=" + msg.ClaimID, "redis"}
 	if err = exe.execDockerTimeout(ctx, DockerRunExecTimeout, func(line string) error {
-		exe.logs.Printf("[INFO] Started container '%s' with claim '%s'", line, claimID)
+		exe.logs.With(Fields{FieldClaimID: msg.ClaimID, FieldTaskID: msg.TaskID}).Printf("[INFO] Started container '%s' with claim '%s'", line, msg.ClaimID)
 		return nil
 	}, args...); err != nil {
 		return errors.Wrapf(err, "failed to run: docker %v", args)
@@ -163,8 +258,8 @@ func (exe *DockerExec) Start(ctx context.Context, nodeID string) {
 	for {
 		select {
 		case runMsg := <-exe.Incoming:
-			exe.logs.Printf("[INFO] Starting task run: %#v", runMsg)
-			err := exe.startContainer(ctx, runMsg.ClaimID)
+			exe.logs.With(Fields{FieldClaimID: runMsg.ClaimID, FieldTaskID: runMsg.TaskID}).Printf("[INFO] Starting task run: %#v", runMsg)
+			err := exe.startContainer(ctx, runMsg)
 			if err != nil {
 				exe.logs.Printf("[ERROR] Failed to start container: %v", err)
 				return
@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	//ScheduleQueueListInterval determines how often a pump lists the schedule
+	//queues, the sub-queue of a new tenant is polled once it was listed
+	ScheduleQueueListInterval = time.Second * 10
+
+	//ScheduleQueueIdleInterval determines how long a schedule queue that was
+	//found empty is not polled again
+	ScheduleQueueIdleInterval = time.Second * 2
+)
+
+//receivedMsg is a schedule message that was received but not yet handled
+type receivedMsg struct {
+	ctx      context.Context
+	priority string
+	queue    string
+	receipt  string
+	msg      ScheduleMsg
+}
+
+//subQueues tracks the schedule queue of every priority and the sub-queues of
+//the tenants in it, it is shared by the schedule workers of a pump
+type subQueues struct {
+	mu     sync.Mutex
+	listed time.Time
+	names  map[string][]string
+	idle   map[string]time.Time
+}
+
+//scheduleQueues returns the names of the schedule queues by priority, they
+//are listed again every ScheduleQueueListInterval. If listing fails the
+//queues that were listed before are returned.
+func (e *Engine) scheduleQueues(ctx context.Context) map[string][]string {
+	sq := e.sq
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	if time.Since(sq.listed) < ScheduleQueueListInterval {
+		return sq.names
+	}
+
+	sq.listed = time.Now()
+	names, err := ListScheduleQueues(ctx, e.q)
+	if err != nil {
+		e.logs.Printf("[WARN] Failed to list schedule queues, polling the ones listed before: %v", err)
+	}
+
+	if err != nil && sq.names != nil {
+		return sq.names
+	}
+
+	sq.names = map[string][]string{}
+	for _, priority := range model.Priorities {
+		sq.names[priority] = []string{FmtScheduleQueueName(priority)}
+	}
+
+	for _, name := range names {
+		if priority, slug, _ := ParseScheduleQueueName(name); slug != "" {
+			sq.names[priority] = append(sq.names[priority], name)
+		}
+	}
+
+	return sq.names
+}
+
+//dueQueues returns the names of the schedule queues by priority that were
+//not found empty within the ScheduleQueueIdleInterval
+func (e *Engine) dueQueues(ctx context.Context) (due map[string][]string, n int) {
+	all := e.scheduleQueues(ctx)
+
+	e.sq.mu.Lock()
+	defer e.sq.mu.Unlock()
+
+	due = map[string][]string{}
+	for priority, names := range all {
+		for _, name := range names {
+			if time.Now().Before(e.sq.idle[name]) {
+				continue
+			}
+
+			due[priority] = append(due[priority], name)
+			n++
+		}
+	}
+
+	return due, n
+}
+
+//rest keeps a queue that was found empty from being polled for the
+//ScheduleQueueIdleInterval
+func (sq *subQueues) rest(name string) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	sq.idle[name] = time.Now().Add(ScheduleQueueIdleInterval)
+}
+
+//fairQueue holds the received schedule messages in a sub-queue per priority
+//and tenant. Within a priority, messages are taken from the tenant with the
+//lowest dominant share: the capacity it uses divided by its weight. Capacity
+//is the only resource that is claimed so it is always the dominant one.
+type fairQueue struct {
+	queues  map[string]map[string][]*receivedMsg
+	usage   map[string]int64
+	weights map[string]int64
+
+	//slugs maps the sub-queue of a tenant back to the tenant
+	slugs map[string]string
+}
+
+//newFairQueue creates an empty queue, the quotas provide the usage and
+//weight of tenants, tenants without a quota have a weight of 1
+func newFairQueue(quotas []*model.Quota) *fairQueue {
+	fq := &fairQueue{
+		queues:  map[string]map[string][]*receivedMsg{},
+		usage:   map[string]int64{},
+		weights: map[string]int64{},
+		slugs:   map[string]string{},
+	}
+
+	for _, quota := range quotas {
+		fq.usage[quota.Name()] = quota.Used
+		fq.weights[quota.Name()] = quota.ShareWeight()
+		fq.slugs[tenantSlug(quota.Name())] = quota.Name()
+	}
+
+	return fq
+}
+
+//push adds the message to the sub-queue of its priority and tenant
+func (fq *fairQueue) push(rm *receivedMsg) {
+	tenants, ok := fq.queues[rm.priority]
+	if !ok {
+		tenants = map[string][]*receivedMsg{}
+		fq.queues[rm.priority] = tenants
+	}
+
+	tenants[rm.msg.Submitter] = append(tenants[rm.msg.Submitter], rm)
+}
+
+//share returns the tenant's usage relative to its weight
+func (fq *fairQueue) share(tenant string) float64 {
+	weight, ok := fq.weights[tenant]
+	if !ok {
+		weight = 1
+	}
+
+	return float64(fq.usage[tenant]) / float64(weight)
+}
+
+//queueShare returns the share of the tenant whose sub-queue it is, tenants
+//that never claimed capacity have no quota and a share of zero. The schedule
+//queue of a priority itself holds messages without a tenant.
+func (fq *fairQueue) queueShare(queue string) float64 {
+	_, slug, _ := ParseScheduleQueueName(queue)
+	tenant, ok := fq.slugs[slug]
+	if !ok {
+		return 0
+	}
+
+	return fq.share(tenant)
+}
+
+//pop takes the next message of the priority from the tenant with the lowest
+//share, ties are broken by tenant name. It returns nil if there is none.
+func (fq *fairQueue) pop(priority string) *receivedMsg {
+	var (
+		next  string
+		found bool
+	)
+
+	for tenant, msgs := range fq.queues[priority] {
+		if len(msgs) < 1 {
+			continue
+		}
+
+		if !found || fq.share(tenant) < fq.share(next) ||
+			(fq.share(tenant) == fq.share(next) && tenant < next) {
+			next, found = tenant, true
+		}
+	}
+
+	if !found {
+		return nil
+	}
+
+	msgs := fq.queues[priority][next]
+	fq.queues[priority][next] = msgs[1:]
+	return msgs[0]
+}
+
+//charge counts capacity that was claimed for the tenant towards its share
+func (fq *fairQueue) charge(tenant string, size int64) {
+	fq.usage[tenant] += size
+}
+
+//TenantShare is the usage of a tenant compared to the share of the capacity
+//it is entitled to
+type TenantShare struct {
+	Tenant string
+	Weight int64
+	Used   int64
+	Queued int64
+
+	//Entitled is the capacity the tenant is entitled to given its weight and
+	//the weights of the other tenants that use or wait for capacity. Idle
+	//tenants are entitled to nothing.
+	Entitled float64
+}
+
+//Shares returns the total capacity of all nodes and, for every tenant with a
+//quota, its usage versus its entitled share of that capacity
+func (e *Engine) Shares(ctx context.Context) (capacity int64, shares []*TenantShare, err error) {
+	nodes, err := model.ListNodes(ctx, e.db)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to list nodes")
+	}
+
+	for _, node := range nodes {
+		capacity += node.Max
+	}
+
+	quotas, err := model.ListQuotas(ctx, e.db, model.QuotaKindTenant)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to list tenant quotas")
+	}
+
+	active := int64(0)
+	for _, quota := range quotas {
+		if quota.Used > 0 || quota.Queued > 0 {
+			active += quota.ShareWeight()
+		}
+
+		shares = append(shares, &TenantShare{
+			Tenant: quota.Name(),
+			Weight: quota.ShareWeight(),
+			Used:   quota.Used,
+			Queued: quota.Queued,
+		})
+	}
+
+	for _, share := range shares {
+		if active > 0 && (share.Used > 0 || share.Queued > 0) {
+			share.Entitled = float64(capacity) * float64(share.Weight) / float64(active)
+		}
+	}
+
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Tenant < shares[j].Tenant })
+	return capacity, shares, nil
+}
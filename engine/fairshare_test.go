@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/advanderveer/factory/model"
+)
+
+func testQuota(tenant string, used, weight int64) *model.Quota {
+	return &model.Quota{
+		QuotaPK:     model.TenantQuotaPK(tenant),
+		QuotaLimits: model.QuotaLimits{Weight: weight},
+		Used:        used,
+	}
+}
+
+func testMsg(id, priority, tenant string, size int64) *receivedMsg {
+	return &receivedMsg{priority: priority, msg: ScheduleMsg{TaskID: id, Submitter: tenant, Size: size}}
+}
+
+func TestFairQueuePop(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		quotas   []*model.Quota
+		msgs     []*receivedMsg
+		priority string
+		charge   bool
+		exp      []string
+	}{
+		{
+			name:     "lowest usage first",
+			quotas:   []*model.Quota{testQuota("a", 10, 1), testQuota("b", 2, 1)},
+			msgs:     []*receivedMsg{testMsg("a1", "normal", "a", 1), testMsg("b1", "normal", "b", 1)},
+			priority: "normal",
+			exp:      []string{"b1", "a1"},
+		},
+		{
+			name:     "usage relative to weight",
+			quotas:   []*model.Quota{testQuota("a", 10, 5), testQuota("b", 4, 1)},
+			msgs:     []*receivedMsg{testMsg("b1", "normal", "b", 1), testMsg("a1", "normal", "a", 1)},
+			priority: "normal",
+			exp:      []string{"a1", "b1"},
+		},
+		{
+			name:     "tenants without a quota have no usage and a weight of 1",
+			quotas:   []*model.Quota{testQuota("a", 1, 1)},
+			msgs:     []*receivedMsg{testMsg("a1", "normal", "a", 1), testMsg("new1", "normal", "new", 1)},
+			priority: "normal",
+			exp:      []string{"new1", "a1"},
+		},
+		{
+			name:     "ties are broken by tenant name",
+			msgs:     []*receivedMsg{testMsg("c1", "normal", "c", 1), testMsg("a1", "normal", "a", 1), testMsg("b1", "normal", "b", 1)},
+			priority: "normal",
+			exp:      []string{"a1", "b1", "c1"},
+		},
+		{
+			name:     "a tenant's messages keep their order",
+			msgs:     []*receivedMsg{testMsg("a1", "normal", "a", 1), testMsg("a2", "normal", "a", 1), testMsg("a3", "normal", "a", 1)},
+			priority: "normal",
+			exp:      []string{"a1", "a2", "a3"},
+		},
+		{
+			name:     "without charging a tenant is drained first",
+			msgs:     []*receivedMsg{testMsg("a1", "normal", "a", 1), testMsg("a2", "normal", "a", 1), testMsg("b1", "normal", "b", 1)},
+			priority: "normal",
+			exp:      []string{"a1", "a2", "b1"},
+		},
+		{
+			name:     "charging alternates between equal tenants",
+			msgs:     []*receivedMsg{testMsg("a1", "normal", "a", 1), testMsg("a2", "normal", "a", 1), testMsg("b1", "normal", "b", 1), testMsg("b2", "normal", "b", 1)},
+			priority: "normal",
+			charge:   true,
+			exp:      []string{"a1", "b1", "a2", "b2"},
+		},
+		{
+			name:     "charging follows the weights",
+			quotas:   []*model.Quota{testQuota("a", 0, 2), testQuota("b", 0, 1)},
+			msgs:     []*receivedMsg{testMsg("a1", "normal", "a", 2), testMsg("a2", "normal", "a", 2), testMsg("a3", "normal", "a", 2), testMsg("b1", "normal", "b", 2), testMsg("b2", "normal", "b", 2)},
+			priority: "normal",
+			charge:   true,
+			exp:      []string{"a1", "b1", "a2", "a3", "b2"},
+		},
+		{
+			name:     "large tasks count for more",
+			msgs:     []*receivedMsg{testMsg("a1", "normal", "a", 8), testMsg("a2", "normal", "a", 1), testMsg("b1", "normal", "b", 1), testMsg("b2", "normal", "b", 1), testMsg("b3", "normal", "b", 1)},
+			priority: "normal",
+			charge:   true,
+			exp:      []string{"a1", "b1", "b2", "b3", "a2"},
+		},
+		{
+			name:     "only the priority is popped",
+			msgs:     []*receivedMsg{testMsg("h1", "high", "a", 1), testMsg("n1", "normal", "b", 1), testMsg("h2", "high", "b", 1)},
+			priority: "high",
+			exp:      []string{"h1", "h2"},
+		},
+		{
+			name:     "empty priority",
+			msgs:     []*receivedMsg{testMsg("n1", "normal", "a", 1)},
+			priority: "low",
+			exp:      nil,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			fq := newFairQueue(c.quotas)
+			for _, rm := range c.msgs {
+				fq.push(rm)
+			}
+
+			var act []string
+			for rm := fq.pop(c.priority); rm != nil; rm = fq.pop(c.priority) {
+				act = append(act, rm.msg.TaskID)
+				if c.charge {
+					fq.charge(rm.msg.Submitter, rm.msg.Size)
+				}
+			}
+
+			if !reflect.DeepEqual(act, c.exp) {
+				t.Fatalf("expected order %v, got: %v", c.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/cenkalti/backoff"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	//MaxGangSize limits the number of tasks in a gang, the whole gang is
+	//placed in one transaction which can hold at most 100 items
+	MaxGangSize = int64(25)
+
+	//MaxGangCandidates is the max number of nodes that are considered for placing a gang
+	MaxGangCandidates = int64(50)
+
+	//GangStartTimeout determines how long the claims of a gang are reserved
+	//for its containers to start. If a claim expires before its container
+	//heartbeats, the whole gang is rolled back and rescheduled.
+	GangStartTimeout = time.Second * 60
+)
+
+//SubmitGang records n tasks of the spec as a gang and submits it for
+//execution, the tasks are placed on nodes all together or not at all. It
+//returns the gang id and the ids of its tasks.
+func (e *Engine) SubmitGang(ctx context.Context, spec TaskSpec, n int64) (gangID string, taskIDs []string, err error) {
+	if n < 1 || n > MaxGangSize {
+		return "", nil, errors.Errorf("a gang must have between 1 and %d tasks", MaxGangSize)
+	}
+
+	if !spec.NotBefore.IsZero() || spec.IdempotencyKey != "" {
+		return "", nil, errors.New("gangs can't be delayed or submitted with an idempotency key")
+	}
+
+	if !model.ValidPriority(spec.Priority) {
+		return "", nil, errors.Errorf("unknown priority '%s'", spec.Priority)
+	}
+
+	gang, tasks, err := model.NewGang(spec.PoolID, spec.Size, n, spec.Submitter)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to create gang")
+	}
+
+	if spec.Priority != "" {
+		gang.Priority = spec.Priority
+		for _, task := range tasks {
+			task.Priority = spec.Priority
+		}
+	}
+
+	gangID, taskIDs = gang.GangID, gang.Tasks
+	ctx, span := startSpan(ctx, "factory.submit_gang", trace.SpanKindProducer, Fields{FieldGangID: gangID, FieldPoolID: spec.PoolID})
+	defer func() { endSpan(span, err) }()
+
+	quotas, err := e.quotas(ctx, spec.PoolID, spec.Submitter)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to get quotas")
+	}
+
+	if err = checkQuotaSize(quotas, spec.Size); err != nil {
+		return "", nil, err
+	}
+
+	for _, quota := range quotas {
+		if quota.MaxCapacity > 0 && spec.Size*n > quota.MaxCapacity {
+			return "", nil, errors.Wrapf(model.ErrQuotaExceeded, "gang size %d is larger than the max capacity %d of quota '%s'", spec.Size*n, quota.MaxCapacity, quota.QuotaPK)
+		}
+	}
+
+	if err = model.SubmitGang(ctx, e.db, gang, tasks, quotas...); err != nil {
+		return "", nil, errors.Wrap(err, "failed to store gang")
+	}
+
+	msg, err := json.Marshal(gangScheduleMsg(gang))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to marshal schedule message")
+	}
+
+	if err = SendScheduleMessage(ctx, e.q, gang.Priority, gang.Submitter, gang.GangID, string(msg)); err != nil {
+		return "", nil, errors.Wrap(err, "failed to send schedule message")
+	}
+
+	e.logs.With(Fields{FieldGangID: gangID, FieldPoolID: spec.PoolID}).Printf("[INFO] Submitted gang of %d tasks of size %d with %s priority", n, spec.Size, gang.Priority)
+	return gangID, taskIDs, nil
+}
+
+//gangScheduleMsg returns the message that schedules the whole gang
+func gangScheduleMsg(gang *model.Gang) ScheduleMsg {
+	return ScheduleMsg{
+		GangID:    gang.GangID,
+		PoolID:    gang.PoolID,
+		Size:      gang.Size * int64(len(gang.Tasks)),
+		Priority:  gang.Priority,
+		Submitter: gang.Submitter,
+	}
+}
+
+//packGang assigns every task to a node with room for it, the nodes with the
+//most capacity are filled first. It returns the node ids by task index or
+//nil if the tasks don't all fit.
+func packGang(nodes []*model.Node, tasks []*model.Task) []string {
+	free := map[string]int64{}
+	candidates := []*model.Node{}
+	for _, node := range nodes {
+		if node.Drain {
+			continue
+		}
+
+		free[node.NodeID] = node.Cap
+		candidates = append(candidates, node)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Cap > candidates[j].Cap })
+
+	nodeIDs := make([]string, len(tasks))
+	for i, task := range tasks {
+		for _, node := range candidates {
+			if free[node.NodeID] >= task.Size {
+				free[node.NodeID] -= task.Size
+				nodeIDs[i] = node.NodeID
+				break
+			}
+		}
+
+		if nodeIDs[i] == "" {
+			return nil
+		}
+	}
+
+	return nodeIDs
+}
+
+//ScheduleGang places claims for all unfinished tasks of a gang in one
+//transaction, either they all fit or none is placed. Node messages are only
+//sent once the whole gang is placed, if that fails the gang is rolled back.
+func (e *Engine) ScheduleGang(ctx context.Context, gangID string) (err error) {
+	logs := e.logs.With(Fields{FieldGangID: gangID})
+	gang, err := model.GetGang(ctx, e.db, model.GangPK{GangID: gangID})
+	if err != nil {
+		if errors.Cause(err) == model.ErrGangNotExists {
+			logs.Printf("[WARN] Gang has no record, it won't be scheduled")
+			return nil
+		}
+
+		return errors.Wrap(err, "failed to get gang")
+	}
+
+	if gang.State != model.TaskStatePending {
+		logs.Printf("[INFO] Gang was placed already, it won't be scheduled again")
+		return nil
+	}
+
+	tasks := []*model.Task{}
+	for _, taskID := range gang.Tasks {
+		task, err := model.GetTask(ctx, e.db, model.TaskPK{TaskID: taskID})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get task '%s'", taskID)
+		}
+
+		if !task.Finished() {
+			tasks = append(tasks, task)
+		}
+	}
+
+	if len(tasks) < 1 {
+		logs.Printf("[INFO] All tasks of the gang were cancelled or finished, it won't be scheduled")
+		return nil
+	}
+
+	logs = logs.With(Fields{FieldPoolID: gang.PoolID})
+	start := time.Now()
+	defer func() {
+		ScheduleDuration.WithLabelValues(gang.PoolID, result(err)).Observe(time.Since(start).Seconds())
+	}()
+
+	quotas, err := e.quotas(ctx, gang.PoolID, gang.Submitter)
+	if err != nil {
+		return errors.Wrap(err, "failed to get quotas")
+	}
+
+	var claims []*model.Claim
+	attempts := 0
+	operation := func() error {
+		attempts++
+		nodes, err := model.NodesWithEnoughCapacity(ctx, e.db, gang.PoolID, gang.Size, MaxGangCandidates)
+		if err != nil {
+			return errors.Wrap(err, "failed to find nodes with enough capacity")
+		}
+
+		nodeIDs := packGang(nodes, tasks)
+		if nodeIDs == nil {
+			return errors.Wrapf(ErrNoNodeCapacity, "not enough capacity for all %d tasks of the gang", len(tasks))
+		}
+
+		candidates, size, dequeued := []*model.Claim{}, int64(0), int64(0)
+		for i, task := range tasks {
+			candidate, err := model.NewClaim(task, nodeIDs[i], time.Now().Add(GangStartTimeout))
+			if err != nil {
+				return errors.Wrap(err, "failed to create claim")
+			}
+
+			candidates = append(candidates, candidate)
+			if candidate.Quota {
+				size += candidate.Size
+				if task.State == model.TaskStatePending {
+					dequeued++
+				}
+			}
+		}
+
+		//a transaction can't write the same quota twice, so the whole gang
+		//is charged in one item per quota
+		var quotaItems []*model.TxItem
+		if size > 0 {
+			for _, quota := range quotas {
+				item, err := model.TxChargeQuota(quota, size, dequeued)
+				if err != nil {
+					return errors.Wrap(err, "failed to create quota item")
+				}
+
+				quotaItems = append(quotaItems, item)
+			}
+		}
+
+		placement, err := uuid.GenerateUUID()
+		if err != nil {
+			return errors.Wrap(err, "failed to generate placement id")
+		}
+
+		err = model.PlaceGang(ctx, e.db, gang, tasks, candidates, placement, quotaItems...)
+		if err != nil {
+			switch errors.Cause(err) {
+			case model.ErrNodeCapacityUnfit:
+				return errors.Wrap(err, "node capacity changed while placing the gang")
+			case model.ErrGangNotPending, model.ErrTaskCancelled, model.ErrQuotaExceeded:
+				return backoff.Permanent(err)
+			}
+
+			return errors.Wrap(err, "failed to place gang")
+		}
+
+		gang.State, gang.Placement = model.TaskStateScheduled, placement
+		for _, claim := range candidates {
+			gang.Claims = append(gang.Claims, claim.ClaimID)
+		}
+
+		claims = candidates
+		return nil
+	}
+
+	b := backoff.NewExponentialBackOff()
+	err = backoff.Retry(operation, backoff.WithContext(
+		backoff.WithMaxTries(b, MaxClaimRetries), ctx))
+	ScheduleAttempts.WithLabelValues(gang.PoolID).Observe(float64(attempts))
+	switch errors.Cause(err) {
+	case model.ErrGangNotPending:
+		logs.Printf("[INFO] Gang was placed concurrently, it won't be scheduled again")
+		return nil
+	case model.ErrTaskCancelled:
+		//the message is received again, the cancelled task is then left out
+		return errors.Wrap(err, "a task of the gang was cancelled or finished while placing it")
+	case model.ErrQuotaExceeded:
+		return errors.Wrap(err, "gang waits for quota")
+	case ErrNoNodeCapacity:
+		e.recordDemand(ctx, gangID, gang.PoolID, gang.Size*int64(len(tasks))) //the gang size is that of each task
+	}
+
+	if err != nil || claims == nil {
+		return errors.Wrap(err, "failed to claim node capacity for the gang")
+	}
+
+	logs.Printf("[INFO] successfully claimed capacity for all %d tasks of the gang", len(claims))
+	for _, claim := range claims {
+		data, err := json.Marshal(RunMsg{TaskID: claim.TaskID, Size: claim.Size, ClaimID: claim.ClaimID})
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal claim for messaging")
+		}
+
+		if err = SendNodeMessage(ctx, e.q, model.NodePK{NodeID: claim.NodeID}, string(data)); err != nil {
+			logs.With(claimFields(claim)).Printf("[WARN] Failed to dispatch claim '%s', rolling back the gang: %v", claim.ClaimPK, err)
+			if rerr := e.releaseGang(ctx, gang, claim); rerr != nil {
+				logs.Printf("[ERROR] Failed to roll back gang, its claims are released once they expire: %v", rerr)
+			}
+
+			return errors.Wrapf(err, "failed to send node message for claim '%s'", claim.ClaimPK)
+		}
+	}
+
+	return nil
+}
+
+//releaseGang rolls back the placement of the claim's gang: the gang is
+//marked as pending and resubmitted in one transaction, after which all claims
+//of the placement are removed. A claim that is not part of the gang's current
+//placement is removed on its own.
+func (e *Engine) releaseGang(ctx context.Context, gang *model.Gang, claim *model.Claim) (err error) {
+	logs := e.logs.With(claimFields(claim).with(Fields{FieldGangID: gang.GangID}))
+	current := false
+	for _, claimID := range gang.Claims {
+		current = current || claimID == claim.ClaimID
+	}
+
+	if gang.State != model.TaskStateScheduled || !current {
+		logs.Printf("[INFO] Claim '%s' is not part of the gang's current placement, removing it on its own", claim.ClaimPK)
+		return e.removeClaim(ctx, claim, nil)
+	}
+
+	data, err := json.Marshal(gangScheduleMsg(gang))
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal schedule message")
+	}
+
+	out, err := model.NewOutbox(FmtTenantScheduleQueueName(gang.Priority, gang.Submitter), string(data), time.Now().Add(OutboxDeliveryTimeout))
+	if err != nil {
+		return errors.Wrap(err, "failed to create outbox message")
+	}
+
+	if err = model.RequeueGang(ctx, e.db, gang, out); err != nil {
+		if errors.Cause(err) == model.ErrGangNotScheduled {
+			logs.Printf("[INFO] Gang was rolled back concurrently, removing claim '%s' on its own", claim.ClaimPK)
+			return e.removeClaim(ctx, claim, nil)
+		}
+
+		return errors.Wrap(err, "failed to requeue gang")
+	}
+
+	for _, claimID := range gang.Claims {
+		member, err := model.GetClaim(ctx, e.db, model.ClaimPK{ClaimID: claimID})
+		if err != nil {
+			if errors.Cause(err) == model.ErrClaimNotExists {
+				continue
+			}
+
+			return errors.Wrapf(err, "failed to get claim '%s'", claimID)
+		}
+
+		//the task is put back to pending with the gang, unless it finished
+		item, err := model.TxUnscheduleTask(member)
+		if err != nil {
+			return errors.Wrap(err, "failed to create task item")
+		}
+
+		err = e.removeClaim(ctx, member, nil, item)
+		if errors.Cause(err) == model.ErrTaskNotScheduled {
+			err = e.removeClaim(ctx, member, nil)
+		}
+
+		if err != nil && errors.Cause(err) != model.ErrClaimNotExists {
+			return err
+		}
+	}
+
+	logs.Printf("[INFO] Rolled back the placement of the gang's %d claims for rescheduling", len(gang.Claims))
+	if derr := e.deliver(ctx, out); derr != nil {
+		logs.Printf("[WARN] failed to deliver re-submission of gang '%s', leaving it to the outbox sweep: %v", gang.GangPK, derr)
+	}
+
+	return nil
+}
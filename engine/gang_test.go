@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/advanderveer/factory/model"
+)
+
+func testNode(id string, cap int64, drain bool) *model.Node {
+	return &model.Node{NodePK: model.NodePK{NodeID: id}, Cap: cap, Max: cap, Drain: drain}
+}
+
+func testTasks(sizes ...int64) (tasks []*model.Task) {
+	for _, size := range sizes {
+		tasks = append(tasks, &model.Task{Size: size})
+	}
+
+	return tasks
+}
+
+func TestPackGang(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		nodes []*model.Node
+		tasks []*model.Task
+		exp   []string
+	}{
+		{
+			name:  "largest node is filled first",
+			nodes: []*model.Node{testNode("small", 2, false), testNode("large", 8, false)},
+			tasks: testTasks(2, 2, 2),
+			exp:   []string{"large", "large", "large"},
+		},
+		{
+			name:  "spills over to the next largest node",
+			nodes: []*model.Node{testNode("small", 2, false), testNode("medium", 4, false), testNode("large", 6, false)},
+			tasks: testTasks(4, 4, 2),
+			exp:   []string{"large", "medium", "large"},
+		},
+		{
+			name:  "task skips nodes without room",
+			nodes: []*model.Node{testNode("a", 3, false), testNode("b", 5, false)},
+			tasks: testTasks(3, 3),
+			exp:   []string{"b", "a"},
+		},
+		{
+			name:  "draining nodes are left out",
+			nodes: []*model.Node{testNode("draining", 10, true), testNode("active", 4, false)},
+			tasks: testTasks(4),
+			exp:   []string{"active"},
+		},
+		{
+			name:  "gang that only fits on a draining node",
+			nodes: []*model.Node{testNode("draining", 10, true), testNode("active", 4, false)},
+			tasks: testTasks(5),
+			exp:   nil,
+		},
+		{
+			name:  "gang larger than the total capacity",
+			nodes: []*model.Node{testNode("a", 4, false), testNode("b", 4, false)},
+			tasks: testTasks(4, 4, 1),
+			exp:   nil,
+		},
+		{
+			name:  "a task doesn't split across nodes",
+			nodes: []*model.Node{testNode("a", 3, false), testNode("b", 2, false)},
+			tasks: testTasks(4),
+			exp:   nil,
+		},
+		{
+			name:  "no nodes",
+			tasks: testTasks(1),
+			exp:   nil,
+		},
+		{
+			name:  "no tasks",
+			nodes: []*model.Node{testNode("a", 3, false)},
+			exp:   []string{},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			act := packGang(c.nodes, c.tasks)
+			if !reflect.DeepEqual(act, c.exp) {
+				t.Fatalf("expected node ids %v, got: %v", c.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	//AutoscaleHookTimeout limits how long the command of a hook may run
+	AutoscaleHookTimeout = time.Minute
+)
+
+//CommandAutoscaler scales pools by running a local command, e.g. a script
+//that calls a cloud provider. It is run as "<path> up <pool> <n>" to add n
+//nodes and as "<path> down <pool> <node-id>..." to remove nodes. The pool is
+//also set as the FACTORY_POOL_ID environment variable.
+type CommandAutoscaler struct {
+	logs Logger
+	path string
+}
+
+//NewCommandAutoscaler creates an autoscaler that runs the command at path
+func NewCommandAutoscaler(logs Logger, path string) *CommandAutoscaler {
+	return &CommandAutoscaler{logs: logs, path: path}
+}
+
+//ScaleUp runs the command to add n nodes to the pool
+func (a *CommandAutoscaler) ScaleUp(ctx context.Context, poolID string, n int) error {
+	return a.run(ctx, poolID, "up", poolID, fmt.Sprintf("%d", n))
+}
+
+//ScaleDown runs the command to remove the nodes from the pool
+func (a *CommandAutoscaler) ScaleDown(ctx context.Context, poolID string, nodeIDs []string) error {
+	return a.run(ctx, poolID, "down", append([]string{poolID}, nodeIDs...)...)
+}
+
+func (a *CommandAutoscaler) run(ctx context.Context, poolID, action string, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, AutoscaleHookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, a.path, append([]string{action}, args...)...)
+	cmd.Env = append(os.Environ(), "FACTORY_POOL_ID="+poolID)
+	out, err := cmd.CombinedOutput()
+	a.logs.With(Fields{FieldPoolID: poolID}).Printf("[DEBUG] Autoscale hook '%s %s' output: %s", a.path, action, strings.TrimSpace(string(out)))
+	if err != nil {
+		return errors.Wrapf(err, "autoscale hook failed to scale %s: %s", action, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	//PumpLeaseID identifies the lease that pumps compete over for leadership
+	PumpLeaseID = "pump"
+
+	//PumpLeaseTimeout determines how long leadership lasts without being renewed
+	PumpLeaseTimeout = PumpCycleInterval * 3
+)
+
+//NewPumpID returns an identifier that is unique for each pump instance
+func NewPumpID() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get hostname")
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate pump id")
+	}
+
+	return fmt.Sprintf("%s/%s", hostname, id), nil
+}
+
+//Leader returns the lease of the pump instance that currently leads, it
+//returns model.ErrLeaseNotExists when no pump has ever been elected
+func (e *Engine) Leader(ctx context.Context) (*model.Lease, error) {
+	lease, err := model.GetLease(ctx, e.db, model.LeasePK{LeaseID: PumpLeaseID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get pump lease")
+	}
+
+	return lease, nil
+}
+
+//elect will attempt to acquire or renew leadership for the pump instance
+func (e *Engine) elect(ctx context.Context, pumpID string) (bool, error) {
+	pk := model.LeasePK{LeaseID: PumpLeaseID}
+	err := model.AcquireLease(ctx, e.db, pk, pumpID, time.Now().Add(PumpLeaseTimeout))
+	if err != nil {
+		if errors.Cause(err) == model.ErrLeaseHeld {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "failed to acquire lease")
+	}
+
+	return true, nil
+}
+
+//resign will give up leadership so another pump can take over without waiting for the lease to expire
+func (e *Engine) resign(ctx context.Context, pumpID string) error {
+	pk := model.LeasePK{LeaseID: PumpLeaseID}
+	err := model.ReleaseLease(ctx, e.db, pk, pumpID)
+	if err != nil && errors.Cause(err) != model.ErrLeaseHeld {
+		return errors.Wrap(err, "failed to release lease")
+	}
+
+	return nil
+}
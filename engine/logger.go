@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/hashicorp/logutils"
+)
+
+const (
+	//FieldNodeID is the log field for the node a message is about
+	FieldNodeID = "node_id"
+
+	//FieldClaimID is the log field for the claim a message is about
+	FieldClaimID = "claim_id"
+
+	//FieldPoolID is the log field for the pool a message is about
+	FieldPoolID = "pool_id"
+
+	//FieldTaskID is the log field for the task a message is about
+	FieldTaskID = "task_id"
+
+	//FieldGangID is the log field for the gang a message is about
+	FieldGangID = "gang_id"
+
+	//FieldWorkflowID is the log field for the workflow a message is about
+	FieldWorkflowID = "workflow_id"
+
+	//FieldCronID is the log field for the cron a message is about
+	FieldCronID = "cron_id"
+)
+
+var (
+	//LogLevels are the levels a message can be prefixed with, e.g: "[INFO] started"
+	LogLevels = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+	//DefaultLogLevel is assumed for messages without a level prefix
+	DefaultLogLevel = "INFO"
+)
+
+//Fields add context to log messages
+type Fields map[string]interface{}
+
+func (f Fields) String() string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, f[k]))
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+//claimFields returns the log fields that describe a claim
+func claimFields(claim *model.Claim) Fields {
+	return Fields{
+		FieldClaimID: claim.ClaimID,
+		FieldNodeID:  claim.NodeID,
+		FieldPoolID:  claim.PoolID,
+		FieldTaskID:  claim.TaskID,
+	}
+}
+
+//with returns a copy of the fields with the other fields added
+func (f Fields) with(other Fields) Fields {
+	fields := Fields{}
+	for k, v := range f {
+		fields[k] = v
+	}
+
+	for k, v := range other {
+		fields[k] = v
+	}
+
+	return fields
+}
+
+//Logger is used by the engine to report on its progress. Messages are
+//prefixed with their level, e.g: "[INFO] started", and carry the fields of
+//the logger they were written with.
+type Logger interface {
+	Printf(format string, v ...interface{})
+	With(fields Fields) Logger
+}
+
+//TextLogger writes messages as lines of text, with fields appended
+type TextLogger struct {
+	logs   *log.Logger
+	fields Fields
+}
+
+//NewTextLogger creates a logger that writes lines of text of at least the given level
+func NewTextLogger(w io.Writer, minLevel string) *TextLogger {
+	levels := []logutils.LogLevel{}
+	for _, lvl := range LogLevels {
+		levels = append(levels, logutils.LogLevel(lvl))
+	}
+
+	return &TextLogger{
+		logs: log.New(&logutils.LevelFilter{
+			Levels:   levels,
+			MinLevel: logutils.LogLevel(minLevel),
+			Writer:   w,
+		}, "factory/", log.Lshortfile|log.Lmicroseconds),
+	}
+}
+
+//Printf writes a message
+func (l *TextLogger) Printf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if len(l.fields) > 0 {
+		msg = msg + " " + l.fields.String()
+	}
+
+	l.logs.Output(2, msg)
+}
+
+//With returns a logger that adds the fields to each message
+func (l *TextLogger) With(fields Fields) Logger {
+	return &TextLogger{logs: l.logs, fields: l.fields.with(fields)}
+}
+
+//JSONLogger writes messages as JSON objects, one per line
+type JSONLogger struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	min    int
+	fields Fields
+}
+
+//NewJSONLogger creates a logger that writes JSON lines of at least the given level
+func NewJSONLogger(w io.Writer, minLevel string) *JSONLogger {
+	return &JSONLogger{
+		mu:  &sync.Mutex{},
+		w:   w,
+		min: levelIndex(minLevel),
+	}
+}
+
+func levelIndex(level string) int {
+	for i, lvl := range LogLevels {
+		if lvl == level {
+			return i
+		}
+	}
+
+	return -1
+}
+
+//splitLevel takes the level prefix of a message
+func splitLevel(msg string) (level string, rest string) {
+	if strings.HasPrefix(msg, "[") {
+		if end := strings.Index(msg, "]"); end > 0 && levelIndex(msg[1:end]) >= 0 {
+			return msg[1:end], strings.TrimSpace(msg[end+1:])
+		}
+	}
+
+	return DefaultLogLevel, msg
+}
+
+//Printf writes a message
+func (l *JSONLogger) Printf(format string, v ...interface{}) {
+	level, msg := splitLevel(fmt.Sprintf(format, v...))
+	if levelIndex(level) < l.min {
+		return
+	}
+
+	entry := l.fields.with(Fields{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"level": level,
+		"msg":   msg,
+	})
+
+	if _, file, line, ok := runtime.Caller(1); ok {
+		entry["caller"] = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(Fields{"level": "ERROR", "msg": fmt.Sprintf("failed to marshal log entry: %v", err)})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(data, '\n'))
+}
+
+//With returns a logger that adds the fields to each message
+func (l *JSONLogger) With(fields Fields) Logger {
+	return &JSONLogger{mu: l.mu, w: l.w, min: l.min, fields: l.fields.with(fields)}
+}
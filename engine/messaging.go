@@ -3,12 +3,17 @@ package engine
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
 
 	"github.com/advanderveer/factory/model"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //Q is our local name of our queing interface
@@ -16,14 +21,27 @@ type Q sqsiface.SQSAPI
 
 //ScheduleMsg is used for the scheduling queue
 type ScheduleMsg struct {
-	PoolID string `json:"pool_id"`
-	Size   int64  `json:"size"`
+	TaskID   string `json:"task_id"`
+	PoolID   string `json:"pool_id"`
+	Size     int64  `json:"size"`
+	Priority string `json:"priority,omitempty"`
+
+	//Submitter is the tenant whose sub-queue the message is put in
+	Submitter string `json:"submitter,omitempty"`
+
+	//GangID is set instead of the task id when all tasks of a gang are to
+	//be placed together, the size is then that of the whole gang
+	GangID string `json:"gang_id,omitempty"`
 }
 
 //RunMsg is the msg send to nodes
 type RunMsg struct {
+	TaskID  string `json:"task_id"`
 	Size    int64  `json:"size"`
 	ClaimID string `json:"claim_id"`
+
+	//spanCtx links the executor's work to the trace of the message
+	spanCtx trace.SpanContext
 }
 
 var (
@@ -33,11 +51,40 @@ var (
 	//AWSQueueAccount determines what account our queues are in
 	AWSQueueAccount = "399106104436"
 
-	//ScheduleQueueName is the name of the queue that contains schedule requests
+	//ScheduleQueueName is the name of the queue that contains schedule
+	//requests of normal priority, other priorities have a queue that is
+	//suffixed with the priority, e.g: "factory-scheduling-high"
 	ScheduleQueueName = "factory-scheduling"
 
+	//TenantQueueInfix separates the schedule queue of a priority from the
+	//tenant whose sub-queue it is, e.g: "factory-scheduling-high-t-<tenant>".
+	//Sub-queues are created when a tenant first submits.
+	TenantQueueInfix = "-t-"
+
+	//ScheduleQueueFIFO is set when the schedule queues are FIFO queues, their
+	//names then end with the FIFO suffix and messages are deduplicated by SQS
+	ScheduleQueueFIFO = false
+
+	//FIFOQueueSuffix ends the name of every FIFO queue
+	FIFOQueueSuffix = ".fifo"
+
 	//NodeQueuePrefix makes queues from out stack identifable
 	NodeQueuePrefix = "factory-node-"
+
+	//MaxQueueBatchSize is the max nr of messages that are send to a queue in one call
+	MaxQueueBatchSize = 10
+
+	//MaxListQueuesResults is the max nr of queues that are listed in one call,
+	//more are listed page by page
+	MaxListQueuesResults = int64(1000)
+
+	//MaxQueueDelay is the longest delay the queue supports for a message,
+	//tasks that are due later are held back by a timer in the database
+	MaxQueueDelay = time.Minute * 15
+
+	//ScheduleVisibilityTimeout is how long a received schedule message stays
+	//hidden from other pumps, it is extended while the message is handled
+	ScheduleVisibilityTimeout = time.Second * 30
 )
 
 //FmtQueueName will return a deterministic queueu name for a node
@@ -45,6 +92,74 @@ func FmtQueueName(pk model.NodePK) string {
 	return fmt.Sprintf("%s%s", NodeQueuePrefix, pk)
 }
 
+//FmtScheduleQueueName returns the name of the schedule queue for tasks of the priority
+func FmtScheduleQueueName(priority string) string {
+	return fifoQueueName(scheduleQueueBase(priority))
+}
+
+//FmtTenantScheduleQueueName returns the name of the sub-queue of the tenant
+//in the schedule queue of the priority, messages without a tenant go to the
+//schedule queue itself. Tenants are hashed as queue names are restricted.
+func FmtTenantScheduleQueueName(priority, tenant string) string {
+	if tenant == "" {
+		return FmtScheduleQueueName(priority)
+	}
+
+	return fifoQueueName(fmt.Sprintf("%s%s%s", scheduleQueueBase(priority), TenantQueueInfix, tenantSlug(tenant)))
+}
+
+//scheduleQueueBase returns the name of the schedule queue of the priority
+//without the FIFO suffix
+func scheduleQueueBase(priority string) string {
+	if priority == "" || priority == model.PriorityNormal {
+		return ScheduleQueueName
+	}
+
+	return fmt.Sprintf("%s-%s", ScheduleQueueName, priority)
+}
+
+//fifoQueueName ends the name with the FIFO suffix if the schedule queues are FIFO
+func fifoQueueName(name string) string {
+	if ScheduleQueueFIFO {
+		return name + FIFOQueueSuffix
+	}
+
+	return name
+}
+
+//tenantSlug returns the part of a sub-queue name that identifies the tenant
+func tenantSlug(tenant string) string {
+	h := fnv.New64a()
+	h.Write([]byte(tenant))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+//ParseScheduleQueueName returns the priority and tenant slug of a schedule
+//queue, the slug is empty for the queue of the priority itself. It returns
+//false if the name is not of a schedule queue.
+func ParseScheduleQueueName(name string) (priority, slug string, ok bool) {
+	if ScheduleQueueFIFO {
+		if !strings.HasSuffix(name, FIFOQueueSuffix) {
+			return "", "", false
+		}
+
+		name = strings.TrimSuffix(name, FIFOQueueSuffix)
+	}
+
+	for _, p := range model.Priorities {
+		base := scheduleQueueBase(p)
+		if name == base {
+			return p, "", true
+		}
+
+		if strings.HasPrefix(name, base+TenantQueueInfix) {
+			return p, strings.TrimPrefix(name, base+TenantQueueInfix), true
+		}
+	}
+
+	return "", "", false
+}
+
 //FmtQueueURL will setup deterministicly return a queue url
 func FmtQueueURL(name string) string {
 	return fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", AWSQueueRegion, AWSQueueAccount, name)
@@ -73,10 +188,11 @@ func DeleteNodeQueue(ctx context.Context, q Q, pk model.NodePK) (err error) {
 }
 
 //NextNodeMessage iterates the node queue by fetching one at a time
-func NextNodeMessage(ctx context.Context, q Q, pk model.NodePK, handler func(msg string) bool) (err error) {
+func NextNodeMessage(ctx context.Context, q Q, pk model.NodePK, handler func(ctx context.Context, msg string) bool) (err error) {
 	inp := &sqs.ReceiveMessageInput{}
 	inp.SetQueueUrl(FmtQueueURL(FmtQueueName(pk)))
 	inp.SetWaitTimeSeconds(20)
+	inp.SetMessageAttributeNames(aws.StringSlice([]string{"All"}))
 	out := &sqs.ReceiveMessageOutput{}
 	if out, err = q.ReceiveMessageWithContext(ctx, inp); err != nil {
 		return errors.Wrap(err, "failed to receive message")
@@ -86,7 +202,7 @@ func NextNodeMessage(ctx context.Context, q Q, pk model.NodePK, handler func(msg
 		return nil
 	}
 
-	if handler(aws.StringValue(out.Messages[0].Body)) {
+	if handler(extractTrace(ctx, out.Messages[0]), aws.StringValue(out.Messages[0].Body)) {
 		dinp := &sqs.DeleteMessageInput{}
 		dinp.SetQueueUrl(FmtQueueURL(FmtQueueName(pk)))
 		dinp.SetReceiptHandle(aws.StringValue(out.Messages[0].ReceiptHandle))
@@ -98,52 +214,215 @@ func NextNodeMessage(ctx context.Context, q Q, pk model.NodePK, handler func(msg
 	return nil
 }
 
-//NextScheduleMessage iterates the schedule queue by fetching one at a time
-func NextScheduleMessage(ctx context.Context, q Q, handler func(msg string) bool) (err error) {
+//ListScheduleQueues returns the names of the schedule queues of every
+//priority and their tenant sub-queues, it reads every page of queues
+func ListScheduleQueues(ctx context.Context, q Q) (names []string, err error) {
+	inp := &sqs.ListQueuesInput{}
+	inp.SetQueueNamePrefix(ScheduleQueueName)
+	inp.SetMaxResults(MaxListQueuesResults)
+	if err = q.ListQueuesPagesWithContext(ctx, inp, func(out *sqs.ListQueuesOutput, last bool) bool {
+		for _, url := range aws.StringValueSlice(out.QueueUrls) {
+			name := url[strings.LastIndex(url, "/")+1:]
+			if _, _, ok := ParseScheduleQueueName(name); ok {
+				names = append(names, name)
+			}
+		}
+
+		return true
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to list queues")
+	}
+
+	return names, nil
+}
+
+//ReceiveScheduleMessages fetches up to max messages (at most 10) from the
+//named schedule queue, waiting up to wait seconds for them to arrive. They
+//stay hidden for the ScheduleVisibilityTimeout.
+func ReceiveScheduleMessages(ctx context.Context, q Q, queue string, max, wait int64) (msgs []*sqs.Message, err error) {
+	if max > 10 {
+		max = 10
+	}
+
 	inp := &sqs.ReceiveMessageInput{}
-	inp.SetQueueUrl(FmtQueueURL(ScheduleQueueName))
-	inp.SetWaitTimeSeconds(20)
+	inp.SetQueueUrl(FmtQueueURL(queue))
+	inp.SetMaxNumberOfMessages(max)
+	inp.SetWaitTimeSeconds(wait)
+	inp.SetVisibilityTimeout(int64(ScheduleVisibilityTimeout / time.Second))
+	inp.SetMessageAttributeNames(aws.StringSlice([]string{"All"}))
 	out := &sqs.ReceiveMessageOutput{}
 	if out, err = q.ReceiveMessageWithContext(ctx, inp); err != nil {
-		return errors.Wrap(err, "failed to receive message")
+		return nil, errors.Wrap(err, "failed to receive messages")
 	}
 
-	if len(out.Messages) < 1 {
-		return nil
+	return out.Messages, nil
+}
+
+//DeleteScheduleMessage deletes a message that was received from the named schedule queue
+func DeleteScheduleMessage(ctx context.Context, q Q, queue, receipt string) (err error) {
+	inp := &sqs.DeleteMessageInput{}
+	inp.SetQueueUrl(FmtQueueURL(queue))
+	inp.SetReceiptHandle(receipt)
+	if _, err = q.DeleteMessageWithContext(ctx, inp); err != nil {
+		return errors.Wrap(err, "failed to delete received message")
 	}
 
-	if handler(aws.StringValue(out.Messages[0].Body)) {
-		dinp := &sqs.DeleteMessageInput{}
-		dinp.SetQueueUrl(FmtQueueURL(ScheduleQueueName))
-		dinp.SetReceiptHandle(aws.StringValue(out.Messages[0].ReceiptHandle))
-		if _, err = q.DeleteMessageWithContext(ctx, dinp); err != nil {
-			return errors.Wrap(err, "failed to delete received message")
+	return nil
+}
+
+//ChangeScheduleMessageVisibility hides the messages received from the named
+//queue for the timeout from now on, it is changed in batches of
+//MaxQueueBatchSize. Messages that were deleted in the meantime are skipped.
+func ChangeScheduleMessageVisibility(ctx context.Context, q Q, queue string, receipts []string, timeout time.Duration) (err error) {
+	for len(receipts) > 0 {
+		n := MaxQueueBatchSize
+		if len(receipts) < n {
+			n = len(receipts)
+		}
+
+		inp := &sqs.ChangeMessageVisibilityBatchInput{}
+		inp.SetQueueUrl(FmtQueueURL(queue))
+		for i, receipt := range receipts[:n] {
+			entry := &sqs.ChangeMessageVisibilityBatchRequestEntry{}
+			entry.SetId(fmt.Sprintf("%d", i))
+			entry.SetReceiptHandle(receipt)
+			entry.SetVisibilityTimeout(int64(timeout / time.Second))
+			inp.Entries = append(inp.Entries, entry)
 		}
+
+		if _, err = q.ChangeMessageVisibilityBatchWithContext(ctx, inp); err != nil {
+			return errors.Wrap(err, "failed to change message visibility")
+		}
+
+		receipts = receipts[n:]
 	}
 
 	return nil
 }
 
-//SendScheduleMessage will dispatch a message to the node
-func SendScheduleMessage(ctx context.Context, q Q, msg string) (err error) {
+//SendScheduleMessage will dispatch a message to the tenant's sub-queue of the
+//schedule queue of the priority, the id deduplicates the message if the
+//schedule queues are FIFO
+func SendScheduleMessage(ctx context.Context, q Q, priority, tenant, id, msg string) (err error) {
+	return sendQueueMessage(ctx, q, FmtTenantScheduleQueueName(priority, tenant), id, msg, 0)
+}
+
+//sendQueueMessage will dispatch a message to the named queue that only
+//becomes visible after the delay. Messages to a FIFO queue are each put in
+//a group of their own and deduplicated by their id, a FIFO queue can't delay
+//single messages. Tenant sub-queues of the schedule queues are created if
+//they don't exist yet.
+func sendQueueMessage(ctx context.Context, q Q, name, id, msg string, delay time.Duration) (err error) {
 	inp := &sqs.SendMessageInput{}
-	inp.SetQueueUrl(FmtQueueURL(ScheduleQueueName))
+	inp.SetQueueUrl(FmtQueueURL(name))
 	inp.SetMessageBody(msg)
-	if _, err = q.SendMessageWithContext(ctx, inp); err != nil {
+	inp.SetMessageAttributes(injectTrace(ctx))
+	if strings.HasSuffix(name, FIFOQueueSuffix) {
+		if delay > 0 {
+			return errors.New("a fifo queue can't delay single messages")
+		}
+
+		inp.SetMessageGroupId(id)
+		inp.SetMessageDeduplicationId(id)
+	} else if delay > 0 {
+		inp.SetDelaySeconds(int64(delay / time.Second))
+	}
+
+	_, err = q.SendMessageWithContext(ctx, inp)
+	if createTenantQueue(ctx, q, name, err) {
+		_, err = q.SendMessageWithContext(ctx, inp)
+	}
+
+	if err != nil {
 		return errors.Wrap(err, "failed to send message")
 	}
 
 	return nil
 }
 
-//SendNodeMessage will dispatch a message to the node
-func SendNodeMessage(ctx context.Context, q Q, pk model.NodePK, msg string) (err error) {
-	inp := &sqs.SendMessageInput{}
-	inp.SetQueueUrl(FmtQueueURL(FmtQueueName(pk)))
-	inp.SetMessageBody(msg)
-	if _, err = q.SendMessageWithContext(ctx, inp); err != nil {
-		return errors.Wrap(err, "failed to send message")
+//createTenantQueue creates the named queue if sending to it failed because
+//it is a tenant sub-queue that doesn't exist yet, it returns whether the
+//send is to be tried again
+func createTenantQueue(ctx context.Context, q Q, name string, err error) bool {
+	if _, slug, ok := ParseScheduleQueueName(name); !ok || slug == "" || !IsQueueNotExists(err) {
+		return false
 	}
 
-	return nil
+	inp := &sqs.CreateQueueInput{}
+	inp.SetQueueName(name)
+	if strings.HasSuffix(name, FIFOQueueSuffix) {
+		inp.SetAttributes(map[string]*string{sqs.QueueAttributeNameFifoQueue: aws.String("true")})
+	}
+
+	if _, err = q.CreateQueueWithContext(ctx, inp); err != nil {
+		return false
+	}
+
+	return true
+}
+
+//IsQueueNotExists returns whether the error is caused by a queue that doesn't exist
+func IsQueueNotExists(err error) bool {
+	aerr, ok := errors.Cause(err).(awserr.Error)
+	return ok && aerr.Code() == sqs.ErrCodeQueueDoesNotExist
+}
+
+//QueueMsg is one message of a batch, the id identifies it in the batch and
+//deduplicates it if the queue is FIFO
+type QueueMsg struct {
+	ID    string
+	Body  string
+	Delay time.Duration
+}
+
+//SendScheduleMessageBatch will dispatch up to MaxQueueBatchSize messages to the
+//named schedule queue in one call. Messages that were not accepted are
+//returned with their error by id, the others were send.
+func SendScheduleMessageBatch(ctx context.Context, q Q, queue string, msgs []QueueMsg) (failed map[string]error, err error) {
+	if len(msgs) > MaxQueueBatchSize {
+		return nil, errors.Errorf("a batch holds at most %d messages, got %d", MaxQueueBatchSize, len(msgs))
+	}
+
+	inp := &sqs.SendMessageBatchInput{}
+	inp.SetQueueUrl(FmtQueueURL(queue))
+	attrs := injectTrace(ctx)
+	for _, msg := range msgs {
+		entry := &sqs.SendMessageBatchRequestEntry{}
+		entry.SetId(msg.ID)
+		entry.SetMessageBody(msg.Body)
+		entry.SetMessageAttributes(attrs)
+		if strings.HasSuffix(queue, FIFOQueueSuffix) {
+			if msg.Delay > 0 {
+				return nil, errors.New("a fifo queue can't delay single messages")
+			}
+
+			entry.SetMessageGroupId(msg.ID)
+			entry.SetMessageDeduplicationId(msg.ID)
+		} else if msg.Delay > 0 {
+			entry.SetDelaySeconds(int64(msg.Delay / time.Second))
+		}
+
+		inp.Entries = append(inp.Entries, entry)
+	}
+
+	out, err := q.SendMessageBatchWithContext(ctx, inp)
+	if createTenantQueue(ctx, q, queue, err) {
+		out, err = q.SendMessageBatchWithContext(ctx, inp)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send message batch")
+	}
+
+	failed = map[string]error{}
+	for _, f := range out.Failed {
+		failed[aws.StringValue(f.Id)] = errors.Errorf("failed to send message: %s: %s", aws.StringValue(f.Code), aws.StringValue(f.Message))
+	}
+
+	return failed, nil
+}
+
+//SendNodeMessage will dispatch a message to the node
+func SendNodeMessage(ctx context.Context, q Q, pk model.NodePK, msg string) (err error) {
+	return sendQueueMessage(ctx, q, FmtQueueName(pk), "", msg, 0)
 }
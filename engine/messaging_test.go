@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestScheduleQueueName(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		fifo     bool
+		priority string
+		tenant   string
+		exp      string
+		expSlug  string
+	}{
+		{"normal priority", false, "normal", "", "factory-scheduling", ""},
+		{"other priority", false, "high", "", "factory-scheduling-high", ""},
+		{"tenant sub-queue", false, "high", "a", "factory-scheduling-high-t-" + tenantSlug("a"), tenantSlug("a")},
+		{"fifo queue", true, "normal", "", "factory-scheduling.fifo", ""},
+		{"fifo tenant sub-queue ends with the suffix", true, "low", "a", "factory-scheduling-low-t-" + tenantSlug("a") + ".fifo", tenantSlug("a")},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			defer func(fifo bool) { ScheduleQueueFIFO = fifo }(ScheduleQueueFIFO)
+			ScheduleQueueFIFO = c.fifo
+
+			act := FmtTenantScheduleQueueName(c.priority, c.tenant)
+			if act != c.exp {
+				t.Fatalf("expected queue name '%s', got: '%s'", c.exp, act)
+			}
+
+			priority, slug, ok := ParseScheduleQueueName(act)
+			if !ok || priority != c.priority || slug != c.expSlug {
+				t.Fatalf("expected to parse priority '%s' and slug '%s', got: '%s', '%s', %v", c.priority, c.expSlug, priority, slug, ok)
+			}
+		})
+	}
+}
+
+func TestParseScheduleQueueNameMismatch(t *testing.T) {
+	defer func(fifo bool) { ScheduleQueueFIFO = fifo }(ScheduleQueueFIFO)
+	for _, c := range []struct {
+		name  string
+		fifo  bool
+		queue string
+	}{
+		{"node queue", false, "factory-node-abc"},
+		{"unknown priority", false, "factory-scheduling-urgent"},
+		{"standard queue when fifo", true, "factory-scheduling-high"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ScheduleQueueFIFO = c.fifo
+			if _, _, ok := ParseScheduleQueueName(c.queue); ok {
+				t.Fatalf("expected '%s' not to be a schedule queue", c.queue)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	//ScheduleDuration observes how long it takes to place a task
+	ScheduleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "factory",
+		Name:      "schedule_duration_seconds",
+		Help:      "Time it took to place a task on a node.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"pool", "result"})
+
+	//ScheduleAttempts observes how often placement was attempted for a task
+	ScheduleAttempts = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "factory",
+		Name:      "schedule_attempts",
+		Help:      "Number of query and claim attempts it took to place a task.",
+		Buckets:   prometheus.LinearBuckets(1, 1, int(MaxClaimRetries)),
+	}, []string{"pool"})
+
+	//SchedulePlans counts the outcome of placements that were planned for a batch of tasks
+	SchedulePlans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "factory",
+		Name:      "schedule_plans_total",
+		Help:      "Number of tasks of a batch that were placed as planned, conflicted, failed or could not be planned.",
+	}, []string{"pool", "result"})
+
+	//PumpCycles counts the cycles the pump leader has run
+	PumpCycles = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "factory",
+		Name:      "pump_cycles_total",
+		Help:      "Number of pump cycles run as leader.",
+	})
+
+	//ExpiredClaims counts the claims that expired
+	ExpiredClaims = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "factory",
+		Name:      "claims_expired_total",
+		Help:      "Number of claims that expired and were released.",
+	})
+
+	//ExpiredNodes counts the nodes that expired
+	ExpiredNodes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "factory",
+		Name:      "nodes_expired_total",
+		Help:      "Number of nodes that expired and were removed.",
+	})
+
+	//ReleaseFailures counts the claims that failed to be released
+	ReleaseFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "factory",
+		Name:      "release_failures_total",
+		Help:      "Number of claim releases that failed.",
+	})
+
+	//QueueReceiveErrors counts the errors while receiving from a queue
+	QueueReceiveErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "factory",
+		Name:      "queue_receive_errors_total",
+		Help:      "Number of errors while receiving messages from a queue.",
+	}, []string{"queue"})
+
+	//ScheduleQueueMessages reports the approximate size of the schedule queues
+	ScheduleQueueMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "factory",
+		Name:      "schedule_queue_messages",
+		Help:      "Approximate number of messages in the schedule queue of a priority.",
+	}, []string{"priority", "state"})
+
+	//Preemptions counts the claims that were released for a task of higher priority
+	Preemptions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "factory",
+		Name:      "preemptions_total",
+		Help:      "Number of claims that were released to place a task of higher priority.",
+	}, []string{"pool", "priority"})
+
+	//DockerDuration observes how long calls to Docker take
+	DockerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "factory",
+		Name:      "docker_duration_seconds",
+		Help:      "Time it took for Docker commands to complete.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"command", "result"})
+
+	//NodeCapacity reports the free and used capacity per node
+	NodeCapacity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "factory",
+		Name:      "node_capacity",
+		Help:      "Capacity of a node that is free or used.",
+	}, []string{"pool", "node", "state"})
+
+	//DuplicateRuns counts the run messages that were dropped because their claim was started before or is gone
+	DuplicateRuns = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "factory",
+		Name:      "duplicate_runs_total",
+		Help:      "Number of run messages dropped as their claim was already started, moved or released.",
+	})
+
+	//ScheduleThrottles counts the database requests of scheduling that were throttled
+	ScheduleThrottles = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "factory",
+		Name:      "schedule_throttles_total",
+		Help:      "Number of times scheduling was throttled by the database.",
+	})
+
+	//ScheduleBackpressure is the pause between schedule rounds while the database throttles
+	ScheduleBackpressure = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "factory",
+		Name:      "schedule_backpressure_seconds",
+		Help:      "Pause between schedule rounds because the database throttled.",
+	})
+
+	//PoolDemand is the size of the tasks that did not fit any node of a pool
+	PoolDemand = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "factory",
+		Name:      "pool_demand",
+		Help:      "Size of the tasks and gangs that recently did not fit any node of the pool.",
+	}, []string{"pool"})
+
+	//PoolIdleCapacity is the free capacity of the nodes of a pool that take claims
+	PoolIdleCapacity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "factory",
+		Name:      "pool_idle_capacity",
+		Help:      "Free capacity of the nodes of the pool that are not draining.",
+	}, []string{"pool"})
+
+	//AutoscaleActions counts the nodes that the autoscaler added, drained and removed
+	AutoscaleActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "factory",
+		Name:      "autoscale_nodes_total",
+		Help:      "Number of nodes the autoscaler added (up), drained (drain) or removed (down).",
+	}, []string{"pool", "action"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		ScheduleDuration,
+		ScheduleAttempts,
+		SchedulePlans,
+		PumpCycles,
+		ExpiredClaims,
+		ExpiredNodes,
+		ReleaseFailures,
+		QueueReceiveErrors,
+		ScheduleQueueMessages,
+		Preemptions,
+		DockerDuration,
+		NodeCapacity,
+		DuplicateRuns,
+		ScheduleThrottles,
+		ScheduleBackpressure,
+		PoolDemand,
+		PoolIdleCapacity,
+		AutoscaleActions,
+	)
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "ok"
+}
+
+//observeNodeCapacity reports the free and used capacity of a node
+func observeNodeCapacity(node *model.Node) {
+	NodeCapacity.WithLabelValues(node.PoolID, node.NodeID, "free").Set(float64(node.Cap))
+	NodeCapacity.WithLabelValues(node.PoolID, node.NodeID, "used").Set(float64(node.Max - node.Cap))
+}
+
+//ObserveCapacity reports the capacity of all nodes, nodes that are gone are no longer reported
+func (e *Engine) ObserveCapacity(ctx context.Context) error {
+	nodes, err := model.ListNodes(ctx, e.db)
+	if err != nil {
+		return errors.Wrap(err, "failed to list nodes")
+	}
+
+	NodeCapacity.Reset()
+	for _, node := range nodes {
+		observeNodeCapacity(node)
+	}
+
+	return nil
+}
+
+//ObserveScheduleQueue reports the approximate size of the schedule queue of
+//each priority, including the sub-queues of its tenants
+func (e *Engine) ObserveScheduleQueue(ctx context.Context) error {
+	for priority, queues := range e.scheduleQueues(ctx) {
+		sizes := map[string]float64{}
+		for _, queue := range queues {
+			inp := &sqs.GetQueueAttributesInput{}
+			inp.SetQueueUrl(FmtQueueURL(queue))
+			inp.SetAttributeNames(aws.StringSlice([]string{
+				sqs.QueueAttributeNameApproximateNumberOfMessages,
+				sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+			}))
+
+			out, err := e.q.GetQueueAttributesWithContext(ctx, inp)
+			if err != nil {
+				if IsQueueNotExists(err) {
+					continue
+				}
+
+				return errors.Wrapf(err, "failed to get attributes of %s priority queue '%s'", priority, queue)
+			}
+
+			for state, attr := range map[string]string{
+				"visible":     sqs.QueueAttributeNameApproximateNumberOfMessages,
+				"not_visible": sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+			} {
+				n, err := strconv.ParseFloat(aws.StringValue(out.Attributes[attr]), 64)
+				if err != nil {
+					return errors.Wrapf(err, "failed to parse queue attribute '%s'", attr)
+				}
+
+				sizes[state] += n
+			}
+		}
+
+		for state, n := range sizes {
+			ScheduleQueueMessages.WithLabelValues(priority, state).Set(n)
+		}
+	}
+
+	return nil
+}
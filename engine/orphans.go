@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+//collectOrphans goes over the containers left by earlier agents on this host
+//and adopts, stops or removes them based on the owner of their claim. Claims
+//owned by this node are adopted and receive heartbeats again, containers of
+//claims that no longer exist are removed. Containers of claims owned by this
+//host's previous node, or by a node that no longer exists, are removed and
+//their claim is released for rescheduling. Those of another live node are
+//left to that node's agent.
+func (e *Engine) collectOrphans(ctx context.Context, exe *DockerExec, node *model.Node, prevNodeID string) error {
+	containers, err := exe.Containers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list containers")
+	}
+
+	logs := e.logs.With(Fields{FieldNodeID: node.NodeID, FieldPoolID: node.PoolID})
+	logs.Printf("[INFO] Found %d containers from earlier runs", len(containers))
+	for _, c := range containers {
+		claim, err := model.GetClaim(ctx, e.db, model.ClaimPK{ClaimID: c.ClaimID})
+		if err != nil {
+			if errors.Cause(err) != model.ErrClaimNotExists {
+				return errors.Wrapf(err, "failed to get claim '%s'", c.ClaimID)
+			}
+
+			logs.With(Fields{FieldClaimID: c.ClaimID}).Printf("[INFO] Container '%s' claim '%s' no longer exists, removing...", c.ID, c.ClaimID)
+			if err = exe.RemoveContainer(ctx, c.ID); err != nil {
+				return errors.Wrapf(err, "failed to remove container '%s'", c.ID)
+			}
+
+			continue
+		}
+
+		claimLogs := logs.With(claimFields(claim))
+		if claim.NodeID == node.NodeID {
+			claimLogs.Printf("[INFO] Adopting container '%s' with claim '%s' (running: %t)", c.ID, c.ClaimID, c.Running)
+			continue
+		}
+
+		if claim.NodeID != prevNodeID {
+			_, err = model.GetNode(ctx, e.db, model.NodePK{NodeID: claim.NodeID})
+			if err == nil {
+				claimLogs.Printf("[DEBUG] Container '%s' claim '%s' belongs to live node '%s', leaving it", c.ID, c.ClaimID, claim.NodeID)
+				continue
+			}
+
+			if errors.Cause(err) != model.ErrNodeNotExists {
+				return errors.Wrapf(err, "failed to get node '%s'", claim.NodeID)
+			}
+		}
+
+		claimLogs.Printf("[INFO] Container '%s' claim '%s' belongs to orphaned node '%s', removing and releasing...", c.ID, c.ClaimID, claim.NodeID)
+		if err = exe.RemoveContainer(ctx, c.ID); err != nil {
+			return errors.Wrapf(err, "failed to remove container '%s'", c.ID)
+		}
+
+		if err = e.release(ctx, claim); err != nil {
+			return errors.Wrapf(err, "failed to release claim '%s'", claim.ClaimPK)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	//MaxPlanCandidates is the max number of nodes per pool that are read for
+	//planning the placement of a batch of tasks
+	MaxPlanCandidates = int64(25)
+)
+
+//plan is a planned placement of a task on a node
+type plan struct {
+	idx  int
+	task *model.Task
+	node *model.Node
+}
+
+//capacitySnapshot holds the free capacity of the nodes of a pool as it was
+//read for a batch, planned placements are subtracted from it
+type capacitySnapshot struct {
+	nodes []*model.Node
+	free  map[string]int64
+}
+
+//newCapacitySnapshot reads the nodes of the pool that fit the smallest task,
+//draining nodes are left out as they don't take new claims
+func (e *Engine) newCapacitySnapshot(ctx context.Context, poolID string, size int64) (*capacitySnapshot, error) {
+	nodes, err := model.NodesWithEnoughCapacity(ctx, e.db, poolID, size, MaxPlanCandidates)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find nodes with enough capacity")
+	}
+
+	snap := &capacitySnapshot{free: map[string]int64{}}
+	for _, node := range nodes {
+		if node.Drain {
+			continue
+		}
+
+		snap.nodes = append(snap.nodes, node)
+		snap.free[node.NodeID] = node.Cap
+	}
+
+	return snap, nil
+}
+
+//take returns the node with the least free capacity that fits the size and
+//subtracts the size from it, it returns nil if no node fits
+func (snap *capacitySnapshot) take(size int64) (best *model.Node) {
+	for _, node := range snap.nodes {
+		free := snap.free[node.NodeID]
+		if free < size {
+			continue
+		}
+
+		if best == nil || free < snap.free[best.NodeID] {
+			best = node
+		}
+	}
+
+	if best != nil {
+		snap.free[best.NodeID] -= size
+	}
+
+	return best
+}
+
+//quotaCache holds the quotas that were read for a batch by pool and
+//submitter, placements only use their limits so they are read once per batch
+type quotaCache map[[2]string][]*model.Quota
+
+//get returns the quotas of the pool and submitter, they are read on first use
+func (qc quotaCache) get(ctx context.Context, e *Engine, poolID, submitter string) ([]*model.Quota, error) {
+	key := [2]string{poolID, submitter}
+	if quotas, ok := qc[key]; ok {
+		return quotas, nil
+	}
+
+	quotas, err := e.quotas(ctx, poolID, submitter)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get quotas")
+	}
+
+	qc[key] = quotas
+	return quotas, nil
+}
+
+//scheduleBatch places the tasks of the received messages with one candidate
+//query per pool. Placements are planned in memory against a snapshot of the
+//capacity and each is committed with its own conditional transaction. Tasks
+//that can't be planned or whose placement conflicts with a concurrent one,
+//as well as anything other than plain pending tasks, are scheduled one by one
+//as before. It returns for every message whether it can be deleted.
+func (e *Engine) scheduleBatch(ctx context.Context, rms []*receivedMsg) (done []bool) {
+	done = make([]bool, len(rms))
+	fallback := map[int]bool{}
+	byPool, minSize := map[string][]*plan{}, map[string]int64{}
+	for i, rm := range rms {
+		task, err := model.GetTask(rm.ctx, e.db, model.TaskPK{TaskID: rm.msg.TaskID})
+		if err != nil || task.State != model.TaskStatePending || task.GangID != "" {
+			fallback[i] = true
+			continue
+		}
+
+		byPool[task.PoolID] = append(byPool[task.PoolID], &plan{idx: i, task: task})
+		if min, ok := minSize[task.PoolID]; !ok || task.Size < min {
+			minSize[task.PoolID] = task.Size
+		}
+	}
+
+	quotas := quotaCache{}
+	for poolID, plans := range byPool {
+		snap, err := e.newCapacitySnapshot(ctx, poolID, minSize[poolID])
+		if err != nil {
+			e.bp.observe(err)
+			e.logs.With(Fields{FieldPoolID: poolID}).Printf("[WARN] Failed to plan placements, scheduling one by one: %v", err)
+			for _, p := range plans {
+				fallback[p.idx] = true
+			}
+
+			continue
+		}
+
+		//messages are in fair order, so earlier tasks get the first pick
+		for _, p := range plans {
+			if p.node = snap.take(p.task.Size); p.node == nil {
+				SchedulePlans.WithLabelValues(poolID, "unplanned").Inc()
+				fallback[p.idx] = true
+				continue
+			}
+
+			ok, placed, err := e.placePlanned(rms[p.idx].ctx, p, quotas)
+			switch {
+			case err != nil:
+				e.bp.observe(err)
+				e.logs.With(Fields{FieldTaskID: p.task.TaskID, FieldPoolID: poolID}).Printf("[INFO] failed to schedule task '%s': %v", p.task.TaskPK, err)
+				SchedulePlans.WithLabelValues(poolID, "failed").Inc()
+				if !placed {
+					snap.free[p.node.NodeID] += p.task.Size
+				}
+			case !ok:
+				SchedulePlans.WithLabelValues(poolID, "conflict").Inc()
+				fallback[p.idx] = true
+			default:
+				SchedulePlans.WithLabelValues(poolID, "placed").Inc()
+				done[p.idx] = true
+			}
+		}
+	}
+
+	for i := range rms {
+		if fallback[i] {
+			done[i] = e.handleScheduleMessage(rms[i].ctx, rms[i].msg)
+		}
+	}
+
+	return done
+}
+
+//placePlanned commits the planned placement of a task. It returns false
+//without an error if the node no longer fits the task or the placement kept
+//conflicting with concurrent ones, the task is then to be scheduled one by
+//one. It returns true if the message of the task can be deleted, also when
+//the task was cancelled or scheduled concurrently. Quotas are taken from the
+//cache of the batch. Placed reports whether the claim was written, which is
+//also the case when dispatching it failed afterwards.
+func (e *Engine) placePlanned(ctx context.Context, p *plan, qc quotaCache) (ok, placed bool, err error) {
+	task, poolID := p.task, p.task.PoolID
+	ctx, span := startSpan(ctx, "factory.schedule", trace.SpanKindConsumer, Fields{FieldTaskID: task.TaskID, FieldPoolID: poolID, FieldNodeID: p.node.NodeID})
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	defer func() {
+		ScheduleDuration.WithLabelValues(poolID, result(err)).Observe(time.Since(start).Seconds())
+	}()
+
+	quotas, err := qc.get(ctx, e, poolID, task.Submitter)
+	if err != nil {
+		return false, false, err
+	}
+
+	claim, err := model.NewClaim(task, p.node.NodeID, time.Now().Add(ClaimHeartbeatTimeout))
+	if err != nil {
+		return false, false, errors.Wrap(err, "failed to create claim")
+	}
+
+	quotaItems, err := chargeQuotaItems(quotas, task, claim)
+	if err != nil {
+		return false, false, err
+	}
+
+	if err = model.PlaceClaim(ctx, e.db, task, claim, quotaItems...); err != nil {
+		switch errors.Cause(err) {
+		case model.ErrNodeCapacityUnfit, model.ErrTransactConflict:
+			return false, false, nil
+		case model.ErrTaskCancelled:
+			e.logs.With(Fields{FieldTaskID: task.TaskID}).Printf("[INFO] Task was cancelled, finished or scheduled concurrently, it won't be scheduled")
+			return true, false, nil
+		case model.ErrQuotaExceeded:
+			//the message is received again once it becomes visible
+			return false, false, errors.Wrap(err, "task waits for quota")
+		}
+
+		return false, false, errors.Wrap(err, "failed to place claim")
+	}
+
+	ScheduleAttempts.WithLabelValues(poolID).Observe(1)
+	e.logs.With(claimFields(claim)).Printf("[INFO] successfully claimed %d capacity on node %v as planned", task.Size, p.node.NodePK)
+	if err = e.dispatch(ctx, claim); err != nil {
+		return false, true, err
+	}
+
+	return true, true, nil
+}
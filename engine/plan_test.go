@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/advanderveer/factory/model"
+)
+
+func testSnapshot(nodes ...*model.Node) *capacitySnapshot {
+	snap := &capacitySnapshot{free: map[string]int64{}}
+	for _, node := range nodes {
+		snap.nodes = append(snap.nodes, node)
+		snap.free[node.NodeID] = node.Cap
+	}
+
+	return snap
+}
+
+func TestCapacitySnapshotTake(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		snap    *capacitySnapshot
+		sizes   []int64
+		exp     []string
+		expFree map[string]int64
+	}{
+		{
+			name:    "best fit",
+			snap:    testSnapshot(testNode("large", 8, false), testNode("small", 3, false), testNode("medium", 5, false)),
+			sizes:   []int64{3},
+			exp:     []string{"small"},
+			expFree: map[string]int64{"large": 8, "small": 0, "medium": 5},
+		},
+		{
+			name:    "node that is too small is skipped",
+			snap:    testSnapshot(testNode("large", 8, false), testNode("small", 3, false), testNode("medium", 5, false)),
+			sizes:   []int64{4},
+			exp:     []string{"medium"},
+			expFree: map[string]int64{"large": 8, "small": 3, "medium": 1},
+		},
+		{
+			name:    "planned placements are subtracted",
+			snap:    testSnapshot(testNode("a", 4, false), testNode("b", 6, false)),
+			sizes:   []int64{3, 3, 3},
+			exp:     []string{"a", "b", "b"},
+			expFree: map[string]int64{"a": 1, "b": 0},
+		},
+		{
+			name:    "a node that filled up is no longer taken",
+			snap:    testSnapshot(testNode("a", 2, false)),
+			sizes:   []int64{2, 1},
+			exp:     []string{"a", ""},
+			expFree: map[string]int64{"a": 0},
+		},
+		{
+			name:    "task that doesn't fit leaves the snapshot as is",
+			snap:    testSnapshot(testNode("a", 2, false), testNode("b", 3, false)),
+			sizes:   []int64{4, 2},
+			exp:     []string{"", "a"},
+			expFree: map[string]int64{"a": 0, "b": 3},
+		},
+		{
+			name:    "ties go to the first node",
+			snap:    testSnapshot(testNode("a", 4, false), testNode("b", 4, false)),
+			sizes:   []int64{1, 3},
+			exp:     []string{"a", "a"},
+			expFree: map[string]int64{"a": 0, "b": 4},
+		},
+		{
+			name:    "empty snapshot",
+			snap:    testSnapshot(),
+			sizes:   []int64{1},
+			exp:     []string{""},
+			expFree: map[string]int64{},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			act := []string{}
+			for _, size := range c.sizes {
+				node := c.snap.take(size)
+				if node == nil {
+					act = append(act, "")
+					continue
+				}
+
+				act = append(act, node.NodeID)
+			}
+
+			if !reflect.DeepEqual(act, c.exp) {
+				t.Fatalf("expected nodes %v, got: %v", c.exp, act)
+			}
+
+			if !reflect.DeepEqual(c.snap.free, c.expFree) {
+				t.Fatalf("expected free capacity %v, got: %v", c.expFree, c.snap.free)
+			}
+		})
+	}
+}
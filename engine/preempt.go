@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"context"
+	"sort"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	//MaxPreemptCandidates is the max number of nodes that are considered for preemption
+	MaxPreemptCandidates = int64(25)
+
+	//MinPreemptingPriority is the lowest priority of tasks that may preempt
+	//the claims of tasks with a lower priority
+	MinPreemptingPriority = model.PriorityHigh
+)
+
+//preempt releases claims of tasks with a lower priority from one node of the
+//task's pool so that the task fits on it, the node that needs the least
+//capacity released is chosen. Claims are released through the normal
+//eviction path, their tasks are resubmitted. It returns the number of claims
+//that were released.
+func (e *Engine) preempt(ctx context.Context, task *model.Task) (n int, err error) {
+	level := model.PriorityLevel(task.Priority)
+	nodes, err := model.NodesWithEnoughCapacity(ctx, e.db, task.PoolID, 0, MaxPreemptCandidates)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to find nodes")
+	}
+
+	var (
+		victims []*model.Claim
+		freed   int64
+		target  *model.Node
+	)
+
+	for _, node := range nodes {
+		if node.Drain || node.Max < task.Size {
+			continue
+		}
+
+		claims, err := model.NodeClaims(ctx, e.db, node.NodeID)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to find claims of node '%s'", node.NodePK)
+		}
+
+		//lowest priority first, then the largest so that fewer are released
+		lower := []*model.Claim{}
+		for _, claim := range claims {
+			if model.PriorityLevel(claim.Priority) < level {
+				lower = append(lower, claim)
+			}
+		}
+
+		sort.Slice(lower, func(i, j int) bool {
+			li, lj := model.PriorityLevel(lower[i].Priority), model.PriorityLevel(lower[j].Priority)
+			if li != lj {
+				return li < lj
+			}
+
+			return lower[i].Size > lower[j].Size
+		})
+
+		var release []*model.Claim
+		free, need := node.Cap, int64(0)
+		for _, claim := range lower {
+			if free >= task.Size {
+				break
+			}
+
+			release = append(release, claim)
+			free += claim.Size
+			need += claim.Size
+		}
+
+		if free < task.Size || (target != nil && need >= freed) {
+			continue
+		}
+
+		target, victims, freed = node, release, need
+	}
+
+	if target == nil {
+		return 0, nil
+	}
+
+	logs := e.logs.With(Fields{FieldTaskID: task.TaskID, FieldPoolID: task.PoolID, FieldNodeID: target.NodeID})
+	for _, claim := range victims {
+		if err = e.release(ctx, claim); err != nil {
+			return n, errors.Wrapf(err, "failed to release claim '%s'", claim.ClaimPK)
+		}
+
+		Preemptions.WithLabelValues(claim.PoolID, task.Priority).Inc()
+		logs.With(Fields{FieldClaimID: claim.ClaimID}).Printf("[INFO] Preempted claim '%s' of %s priority task '%s'", claim.ClaimPK, claim.Priority, claim.TaskID)
+		n++
+	}
+
+	return n, nil
+}
@@ -3,12 +3,16 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/advanderveer/factory/model"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -20,43 +24,290 @@ var (
 
 	//MaxExpiredClaimsPerPartition determines the max nr of claims per partition that can expire per cycle
 	MaxExpiredClaimsPerPartition = int64(10)
+
+	//ScheduleQueueWeights determines how many messages of each priority are
+	//handled per polling round, at most. Higher priorities are polled first.
+	ScheduleQueueWeights = map[string]int{
+		model.PriorityHigh:   8,
+		model.PriorityNormal: 4,
+		model.PriorityLow:    1,
+	}
+
+	//ScheduleIdleWait determines how long a worker waits after a round in
+	//which no messages were received
+	ScheduleIdleWait = time.Second
+
+	//ScheduleWorkers is the number of rounds of schedule messages that are
+	//received and scheduled at the same time by each pump
+	ScheduleWorkers = 4
+
+	//ScheduleVisibilityExtendInterval determines how often the visibility of
+	//the messages of a round is extended while they are being scheduled, it
+	//must be well below the ScheduleVisibilityTimeout
+	ScheduleVisibilityExtendInterval = time.Second * 10
 )
 
-//HandleScheduleMessages takes a message and attempts to schedule it
-func (e *Engine) HandleScheduleMessages(ctx context.Context, doneCh chan<- struct{}) {
-	e.logs.Printf("[INFO] Start handling scheduling messages")
-	defer e.logs.Printf("[INFO] Stopped handling scheduling messages")
-	defer close(doneCh)
+//handleScheduleMessage attempts to schedule the task of the message, it
+//returns whether the message can be deleted
+func (e *Engine) handleScheduleMessage(msgCtx context.Context, msg ScheduleMsg) bool {
+	var rerr error
+	if msg.GangID != "" {
+		msgCtx, span := startSpan(msgCtx, "factory.schedule_gang", trace.SpanKindConsumer, Fields{FieldGangID: msg.GangID, FieldPoolID: msg.PoolID})
+		defer func() { endSpan(span, rerr) }()
 
-	for {
-		if err := NextScheduleMessage(ctx, e.q, func(msgs string) bool {
+		if rerr = e.ScheduleGang(msgCtx, msg.GangID); rerr != nil {
+			e.bp.observe(rerr)
+			e.logs.With(Fields{FieldGangID: msg.GangID, FieldPoolID: msg.PoolID}).Printf("[INFO] failed to schedule gang '%s': %v", msg.GangID, rerr)
+			return false
+		}
+
+		return true
+	}
+
+	msgCtx, span := startSpan(msgCtx, "factory.schedule", trace.SpanKindConsumer, Fields{FieldTaskID: msg.TaskID, FieldPoolID: msg.PoolID})
+	defer func() { endSpan(span, rerr) }()
+
+	if rerr = e.Schedule(msgCtx, msg.TaskID); rerr != nil {
+		e.bp.observe(rerr)
+		e.logs.With(Fields{FieldTaskID: msg.TaskID, FieldPoolID: msg.PoolID}).Printf("[INFO] failed to schedule task '%s': %v", msg.TaskID, rerr)
+		return false
+	}
+
+	return true
+}
+
+//receiveScheduleRound receives up to the weight of each priority in messages
+//from the schedule queues and puts them in a fair queue, it also returns the
+//messages in order of arrival. Within a priority, the sub-queues of tenants
+//with the lowest share are received from first.
+func (e *Engine) receiveScheduleRound(ctx context.Context) (fq *fairQueue, received []*receivedMsg, err error) {
+	due, n := e.dueQueues(ctx)
+	if n < 1 {
+		return newFairQueue(nil), nil, nil
+	}
+
+	quotas, qerr := model.ListQuotas(ctx, e.db, model.QuotaKindTenant)
+	if qerr != nil {
+		e.logs.Printf("[WARN] Failed to list tenant quotas, scheduling in order of arrival: %v", qerr)
+	}
+
+	fq = newFairQueue(quotas)
+	for _, priority := range model.Priorities {
+		rms, err := e.receiveFair(ctx, fq, priority, due[priority], ScheduleQueueWeights[priority])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, rm := range rms {
+			fq.push(rm)
+		}
+
+		received = append(received, rms...)
+	}
+
+	return fq, received, nil
+}
+
+//receiveFair receives up to max messages from the queues of the priority, in
+//order of the share of their tenants. Every pass takes an equal part of what
+//is left from each queue and the queues that had more are passed again, so
+//the backlog of one tenant doesn't fill the round while others wait.
+func (e *Engine) receiveFair(ctx context.Context, fq *fairQueue, priority string, queues []string, max int) (received []*receivedMsg, err error) {
+	sort.SliceStable(queues, func(i, j int) bool {
+		si, sj := fq.queueShare(queues[i]), fq.queueShare(queues[j])
+		return si < sj || (si == sj && queues[i] < queues[j])
+	})
+
+	for max > 0 && len(queues) > 0 {
+		part := max / len(queues)
+		if part < 1 {
+			part = 1
+		}
 
-			e.logs.Printf("[INFO] received schedule message: %v", msgs)
-			msg := ScheduleMsg{}
-			rerr := json.Unmarshal([]byte(msgs), &msg)
-			if rerr != nil {
-				e.logs.Printf("[ERROR] failed to unmarshal schedule message: %v", rerr)
-				return false
+		more := []string{}
+		for _, queue := range queues {
+			if max < 1 {
+				break
 			}
 
-			if rerr = e.Schedule(ctx, msg.PoolID, msg.Size); rerr != nil {
-				e.logs.Printf("[INFO] failed to schedule request '%v': %v", msgs, rerr)
-				return false
+			if part > max {
+				part = max
 			}
 
-			return true
-		}); err != nil {
+			msgs, err := ReceiveScheduleMessages(ctx, e.q, queue, int64(part), 0)
+			if err != nil {
+				if IsQueueNotExists(err) {
+					e.sq.rest(queue)
+					continue
+				}
+
+				return nil, err
+			}
+
+			if len(msgs) < 1 {
+				e.sq.rest(queue)
+				continue
+			}
+
+			if len(msgs) == part {
+				more = append(more, queue)
+			}
+
+			max -= len(msgs)
+			for _, m := range msgs {
+				body := aws.StringValue(m.Body)
+				e.logs.Printf("[INFO] received schedule message: %v", body)
+				rm := &receivedMsg{ctx: extractTrace(ctx, m), priority: priority, queue: queue, receipt: aws.StringValue(m.ReceiptHandle)}
+				if err := json.Unmarshal([]byte(body), &rm.msg); err != nil {
+					e.logs.Printf("[ERROR] failed to unmarshal schedule message: %v", err)
+					continue
+				}
+
+				received = append(received, rm)
+			}
+		}
+
+		queues = more
+	}
+
+	return received, nil
+}
+
+//deleteScheduleMessage removes a message that was handled from its schedule queue
+func (e *Engine) deleteScheduleMessage(ctx context.Context, rm *receivedMsg) {
+	if err := DeleteScheduleMessage(ctx, e.q, rm.queue, rm.receipt); err != nil {
+		e.logs.Printf("[ERROR] Failed to delete schedule message: %v", err)
+	}
+}
+
+//hideScheduleMessages keeps extending the visibility of the received
+//messages until the returned function is called, so they are not received
+//again by another worker while they are being scheduled
+func (e *Engine) hideScheduleMessages(ctx context.Context, rms []*receivedMsg) (stop func()) {
+	receipts := map[string][]string{}
+	for _, rm := range rms {
+		receipts[rm.queue] = append(receipts[rm.queue], rm.receipt)
+	}
+
+	stopCh, doneCh := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(ScheduleVisibilityExtendInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for queue, rs := range receipts {
+					if err := ChangeScheduleMessageVisibility(ctx, e.q, queue, rs, ScheduleVisibilityTimeout); err != nil {
+						e.logs.Printf("[WARN] Failed to extend visibility of schedule messages: %v", err)
+					}
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stopCh)
+		<-doneCh
+	}
+}
+
+//scheduleRound schedules the messages of a round in fair order and deletes
+//those that were handled, messages that weren't become visible again once
+//their visibility timeout passes
+func (e *Engine) scheduleRound(ctx context.Context, fq *fairQueue) {
+	for _, priority := range model.Priorities {
+		batch := []*receivedMsg{}
+		for rm := fq.pop(priority); rm != nil; rm = fq.pop(priority) {
+			if rm.msg.GangID != "" {
+				if !e.handleScheduleMessage(rm.ctx, rm.msg) {
+					continue
+				}
+
+				fq.charge(rm.msg.Submitter, rm.msg.Size)
+				e.deleteScheduleMessage(ctx, rm)
+				continue
+			}
+
+			//tasks are charged as they join the batch so the fair order
+			//holds within it, whether they are placed or not
+			fq.charge(rm.msg.Submitter, rm.msg.Size)
+			batch = append(batch, rm)
+		}
+
+		for i, done := range e.scheduleBatch(ctx, batch) {
+			if done {
+				e.deleteScheduleMessage(ctx, batch[i])
+			}
+		}
+	}
+}
+
+//scheduleWorker receives and schedules rounds of messages until the context
+//is cancelled or receiving fails
+func (e *Engine) scheduleWorker(ctx context.Context) {
+	for {
+		e.bp.wait(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		fq, received, err := e.receiveScheduleRound(ctx)
+		if err != nil {
 			if aerr, ok := errors.Cause(err).(awserr.Error); ok && aerr.Code() == request.CanceledErrorCode {
 				e.logs.Printf("[INFO] Mext node message receive was cancelled")
 				return
 			}
 
+			QueueReceiveErrors.WithLabelValues("schedule").Inc()
 			e.logs.Printf("[ERROR] Failed to receive next node message: %v", err)
 			return
 		}
+
+		if len(received) < 1 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(ScheduleIdleWait):
+			}
+
+			continue
+		}
+
+		stop := e.hideScheduleMessages(ctx, received)
+		e.scheduleRound(ctx, fq)
+		stop()
 	}
 }
 
+//HandleScheduleMessages takes messages from the schedule queues and attempts
+//to schedule them with ScheduleWorkers workers. Every tenant has a sub-queue
+//in the schedule queue of each priority. Each worker polls the queues in
+//rounds from the highest priority to the lowest, each round takes at most
+//the weight of a priority in messages. Within a priority, the sub-queues of
+//tenants that use the least capacity relative to their share weight are
+//polled and scheduled first, and the tasks are placed as one batch. Workers
+//pause between rounds while the database throttles.
+func (e *Engine) HandleScheduleMessages(ctx context.Context, doneCh chan<- struct{}) {
+	e.logs.Printf("[INFO] Start handling scheduling messages with %d workers", ScheduleWorkers)
+	defer e.logs.Printf("[INFO] Stopped handling scheduling messages")
+	defer close(doneCh)
+
+	var wg sync.WaitGroup
+	for i := 0; i < ScheduleWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.scheduleWorker(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
 //ExpireClaims queries the database for expired claims and reschedules them
 func (e *Engine) ExpireClaims(ctx context.Context) (err error) {
 	expired, err := model.ExpiredClaims(ctx, e.db, MaxExpiredClaimsPerPartition)
@@ -70,6 +321,8 @@ func (e *Engine) ExpireClaims(ctx context.Context) (err error) {
 		if err != nil {
 			return errors.Wrapf(err, "failed to release claim '%s'", claim.ClaimPK)
 		}
+
+		ExpiredClaims.Inc()
 	}
 
 	return nil
@@ -84,11 +337,18 @@ func (e *Engine) ExpireNodes(ctx context.Context) (err error) {
 
 	e.logs.Printf("[INFO] found %d expired nodes", len(expired))
 	for _, node := range expired {
-		err := e.deleteNode(ctx, node.NodePK)
+		e.logs.With(Fields{FieldNodeID: node.NodeID, FieldPoolID: node.PoolID}).Printf("[INFO] Node '%s' expired", node.NodePK)
+		err := e.deleteNode(ctx, node.NodePK, true)
 		if err != nil {
+			if errors.Cause(err) == model.ErrNodeHeld {
+				continue //taken back by a restarted agent while we were reading
+			}
+
 			return errors.Wrapf(err, "failed to delete node '%s'", node.NodePK)
 		}
 
+		ExpiredNodes.Inc()
+
 		err = e.Evict(ctx, node.NodeID)
 		if err != nil {
 			return errors.Wrapf(err, "failed to evict node '%s' claims", node.NodePK)
@@ -98,38 +358,79 @@ func (e *Engine) ExpireNodes(ctx context.Context) (err error) {
 	return nil
 }
 
-func (e *Engine) shutdownPump(doneCh chan struct{}) error {
+func (e *Engine) shutdownPump(pumpID string, leader bool, doneChs ...chan struct{}) error {
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, MaxAgentShutdownTime)
 	defer cancel()
 
-	e.logs.Printf("[INFO] Waiting for schedule routine to exit")
-	select {
-	case <-doneCh:
-	case <-ctx.Done():
-		return errors.New("pump routine didn't exit in time")
+	if leader {
+		e.logs.Printf("[INFO] Resigning as pump leader")
+		err := e.resign(ctx, pumpID)
+		if err != nil {
+			return errors.Wrap(err, "failed to resign as leader")
+		}
+	}
+
+	e.logs.Printf("[INFO] Waiting for schedule and autoscale routines to exit")
+	for _, doneCh := range doneChs {
+		select {
+		case <-doneCh:
+		case <-ctx.Done():
+			return errors.New("pump routine didn't exit in time")
+		}
 	}
 
 	return nil
 }
 
-//Pump causes the engine to progress
+//Pump causes the engine to progress. Every pump handles schedule messages but
+//only the elected leader expires claims and nodes, sweeps the outbox,
+//advances workflows, submits the runs of due crons, reconciles node
+//capacity and autoscales pools.
 func (e *Engine) Pump(ctx context.Context) (err error) {
-	e.logs.Printf("[INFO] Started engine pump")
-	defer e.logs.Printf("[INFO] Exited engine pump")
+	pumpID, err := NewPumpID()
+	if err != nil {
+		return errors.Wrap(err, "failed to create pump id")
+	}
 
-	doneCh := make(chan struct{})
+	e.logs.Printf("[INFO] Started engine pump '%s'", pumpID)
+	defer e.logs.Printf("[INFO] Exited engine pump '%s'", pumpID)
+
+	doneCh, scaleDoneCh := make(chan struct{}), make(chan struct{})
 	go e.HandleScheduleMessages(ctx, doneCh)
+	go e.autoscaleLoop(ctx, pumpID, scaleDoneCh)
 
+	leader := false
+	reconciled := time.Now()
 	ticker := time.NewTicker(PumpCycleInterval)
 	for {
 		select {
 		case <-ctx.Done():
-			return e.shutdownPump(doneCh)
+			return e.shutdownPump(pumpID, leader, doneCh, scaleDoneCh)
 		case <-ticker.C:
 			e.logs.Printf("[DEBUG] Started Pump cycle")
 
-			err := e.ExpireClaims(ctx)
+			elected, err := e.elect(ctx, pumpID)
+			if err != nil {
+				return errors.Wrap(err, "failed to elect leader")
+			}
+
+			if elected != leader {
+				if elected {
+					e.logs.Printf("[INFO] Pump '%s' became leader", pumpID)
+				} else {
+					e.logs.Printf("[INFO] Pump '%s' lost leadership", pumpID)
+				}
+
+				leader = elected
+			}
+
+			if !leader {
+				continue
+			}
+
+			PumpCycles.Inc()
+			err = e.ExpireClaims(ctx)
 			if err != nil {
 				return errors.Wrap(err, "failed to expire claims")
 			}
@@ -138,6 +439,43 @@ func (e *Engine) Pump(ctx context.Context) (err error) {
 			if err != nil {
 				return errors.Wrap(err, "failed to expire nodes")
 			}
+
+			err = e.DeliverOutbox(ctx)
+			if err != nil {
+				return errors.Wrap(err, "failed to deliver outbox")
+			}
+
+			err = e.DeliverTimers(ctx)
+			if err != nil {
+				return errors.Wrap(err, "failed to deliver timers")
+			}
+
+			err = e.AdvanceWorkflows(ctx)
+			if err != nil {
+				return errors.Wrap(err, "failed to advance workflows")
+			}
+
+			err = e.RunCrons(ctx)
+			if err != nil {
+				return errors.Wrap(err, "failed to run crons")
+			}
+
+			if time.Since(reconciled) >= PumpReconcileInterval {
+				_, err = e.Reconcile(ctx)
+				if err != nil {
+					return errors.Wrap(err, "failed to reconcile node capacity")
+				}
+
+				reconciled = time.Now()
+			}
+
+			if oerr := e.ObserveCapacity(ctx); oerr != nil {
+				e.logs.Printf("[WARN] Failed to observe node capacity: %v", oerr)
+			}
+
+			if oerr := e.ObserveScheduleQueue(ctx); oerr != nil {
+				e.logs.Printf("[WARN] Failed to observe schedule queue: %v", oerr)
+			}
 		}
 	}
 }
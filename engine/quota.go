@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+//quotaPKs returns the keys of the quotas that apply to tasks of the pool and
+//submitter, tasks without a submitter only count towards the pool's quota
+func quotaPKs(poolID, submitter string) []model.QuotaPK {
+	pks := []model.QuotaPK{model.PoolQuotaPK(poolID)}
+	if submitter != "" {
+		pks = append(pks, model.TenantQuotaPK(submitter))
+	}
+
+	return pks
+}
+
+//Quota returns the quota, a quota that was never set has no limits
+func (e *Engine) Quota(ctx context.Context, pk model.QuotaPK) (*model.Quota, error) {
+	quota, err := model.GetQuota(ctx, e.db, pk)
+	if err != nil {
+		if errors.Cause(err) == model.ErrQuotaNotExists {
+			return &model.Quota{QuotaPK: pk}, nil
+		}
+
+		return nil, errors.Wrapf(err, "failed to get quota '%s'", pk)
+	}
+
+	return quota, nil
+}
+
+//SetQuota sets the limits of a pool or tenant quota, zero means unlimited.
+//Work that was already submitted or placed is left as is.
+func (e *Engine) SetQuota(ctx context.Context, pk model.QuotaPK, limits model.QuotaLimits) error {
+	if limits.MaxCapacity < 0 || limits.MaxQueued < 0 || limits.MaxSize < 0 || limits.Weight < 0 {
+		return errors.New("quota limits can't be negative")
+	}
+
+	e.logs.Printf("[INFO] Setting quota '%s' to max capacity %d, max queued %d, max size %d and weight %d", pk, limits.MaxCapacity, limits.MaxQueued, limits.MaxSize, limits.Weight)
+	if err := model.SetQuota(ctx, e.db, pk, limits); err != nil {
+		return errors.Wrap(err, "failed to set quota")
+	}
+
+	return nil
+}
+
+//quotas returns the quotas that apply to tasks of the pool and submitter
+func (e *Engine) quotas(ctx context.Context, poolID, submitter string) (quotas []*model.Quota, err error) {
+	for _, pk := range quotaPKs(poolID, submitter) {
+		quota, err := e.Quota(ctx, pk)
+		if err != nil {
+			return nil, err
+		}
+
+		quotas = append(quotas, quota)
+	}
+
+	return quotas, nil
+}
+
+//checkQuotaSize returns an error if a task of the size can never be placed
+//within the quotas
+func checkQuotaSize(quotas []*model.Quota, size int64) error {
+	for _, quota := range quotas {
+		if quota.MaxSize > 0 && size > quota.MaxSize {
+			return errors.Wrapf(model.ErrQuotaExceeded, "task size %d is larger than the max size %d of quota '%s'", size, quota.MaxSize, quota.QuotaPK)
+		}
+
+		if quota.MaxCapacity > 0 && size > quota.MaxCapacity {
+			return errors.Wrapf(model.ErrQuotaExceeded, "task size %d is larger than the max capacity %d of quota '%s'", size, quota.MaxCapacity, quota.QuotaPK)
+		}
+	}
+
+	return nil
+}
+
+//chargeQuotaItems creates the transaction items that count the claim towards
+//its quotas, a pending task is no longer counted as queued
+func chargeQuotaItems(quotas []*model.Quota, task *model.Task, claim *model.Claim) (items []*model.TxItem, err error) {
+	if !claim.Quota {
+		return nil, nil
+	}
+
+	dequeued := int64(0)
+	if task.State == model.TaskStatePending {
+		dequeued = 1
+	}
+
+	for _, quota := range quotas {
+		item, err := model.TxChargeQuota(quota, claim.Size, dequeued)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create quota item")
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+//releaseQuotaItems creates the transaction items that no longer count the
+//claim's capacity towards its quotas
+func releaseQuotaItems(claim *model.Claim) (items []*model.TxItem, err error) {
+	if !claim.Quota {
+		return nil, nil
+	}
+
+	for _, pk := range quotaPKs(claim.PoolID, claim.Submitter) {
+		item, err := model.TxReleaseQuota(pk, claim.Size, 0)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create quota item")
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
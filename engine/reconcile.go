@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	//PumpReconcileInterval determines how often the pump leader reconciles node capacity
+	PumpReconcileInterval = time.Minute
+
+	//ReconcileSettleTime determines how long a capacity mismatch has to persist
+	//before it is corrected, this prevents corrections based on claim indexes
+	//that haven't caught up yet
+	ReconcileSettleTime = time.Second * 2
+)
+
+//CapacityCorrection reports a node whose capacity drifted from its claims
+type CapacityCorrection struct {
+	NodeID   string `json:"node_id"`
+	PoolID   string `json:"pool_id"`
+	Cap      int64  `json:"cap"`
+	Expected int64  `json:"expected"`
+}
+
+//expectedCapacity returns the node's capacity as follows from its claims
+func (e *Engine) expectedCapacity(ctx context.Context, node *model.Node) (int64, error) {
+	claims, err := model.NodeClaims(ctx, e.db, node.NodeID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to find node claims")
+	}
+
+	expected := node.Max
+	for _, claim := range claims {
+		expected -= claim.Size
+	}
+
+	return expected, nil
+}
+
+//Reconcile recomputes the free capacity of every node from its claims and
+//repairs any mismatch. A node is only corrected when the mismatch is still
+//there after ReconcileSettleTime and its capacity didn't change in between.
+func (e *Engine) Reconcile(ctx context.Context) (corrections []CapacityCorrection, err error) {
+	nodes, err := model.ListNodes(ctx, e.db)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list nodes")
+	}
+
+	suspects := map[string]int64{}
+	for _, node := range nodes {
+		expected, err := e.expectedCapacity(ctx, node)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to determine expected capacity of node '%s'", node.NodePK)
+		}
+
+		if expected != node.Cap {
+			e.logs.With(Fields{FieldNodeID: node.NodeID, FieldPoolID: node.PoolID}).Printf("[DEBUG] Node '%s' has capacity %d while its claims leave %d", node.NodePK, node.Cap, expected)
+			suspects[node.NodeID] = node.Cap
+		}
+	}
+
+	e.logs.Printf("[INFO] found %d nodes with drifting capacity", len(suspects))
+	if len(suspects) < 1 {
+		return nil, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(ReconcileSettleTime):
+	}
+
+	for nodeID, observed := range suspects {
+		node, err := model.GetNode(ctx, e.db, model.NodePK{NodeID: nodeID})
+		if err != nil {
+			if errors.Cause(err) == model.ErrNodeNotExists {
+				continue
+			}
+
+			return corrections, errors.Wrapf(err, "failed to get node '%s'", nodeID)
+		}
+
+		if node.Cap != observed {
+			continue //capacity moved, the next run will see a settled state
+		}
+
+		expected, err := e.expectedCapacity(ctx, node)
+		if err != nil {
+			return corrections, errors.Wrapf(err, "failed to determine expected capacity of node '%s'", node.NodePK)
+		}
+
+		if expected == node.Cap {
+			continue
+		}
+
+		err = model.RepairNodeCapacity(ctx, e.db, node.NodePK, node.Cap, expected)
+		if err != nil {
+			if errors.Cause(err) == model.ErrNodeCapacityChanged {
+				continue
+			}
+
+			return corrections, errors.Wrapf(err, "failed to repair capacity of node '%s'", node.NodePK)
+		}
+
+		e.logs.With(Fields{FieldNodeID: node.NodeID, FieldPoolID: node.PoolID}).Printf("[INFO] Corrected capacity of node '%s' from %d to %d", node.NodePK, node.Cap, expected)
+		corrections = append(corrections, CapacityCorrection{
+			NodeID:   node.NodeID,
+			PoolID:   node.PoolID,
+			Cap:      node.Cap,
+			Expected: expected,
+		})
+	}
+
+	return corrections, nil
+}
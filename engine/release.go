@@ -2,36 +2,194 @@ package engine
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	"github.com/advanderveer/factory/model"
 	"github.com/pkg/errors"
 )
 
-func (e *Engine) release(ctx context.Context, claim *model.Claim) error {
-	if rerr := model.ReturnNodeCapacity(ctx, e.db, model.NodePK{NodeID: claim.NodeID}, claim.Size); rerr != nil {
-		e.logs.Printf("[WARN] failed to return node capacity: %v", rerr)
+var (
+	//OutboxDeliveryTimeout determines how long a released claim's outbox
+	//message may stay undelivered before the pump sweep delivers it
+	OutboxDeliveryTimeout = time.Second * 30
+
+	//MaxUndeliveredOutboxPerPartition determines the max nr of outbox messages per partition that are delivered per cycle
+	MaxUndeliveredOutboxPerPartition = int64(10)
+)
+
+//release deletes the claim, returns its capacity and records its resubmission
+//as one transaction. The resubmission is delivered only after that commits.
+//Releasing a claim of a gang rolls back the placement of the whole gang.
+func (e *Engine) release(ctx context.Context, claim *model.Claim) (err error) {
+	defer func() {
+		if err != nil {
+			ReleaseFailures.Inc()
+		}
+	}()
+
+	logs := e.logs.With(claimFields(claim))
+	if claim.GangID != "" {
+		gang, err := model.GetGang(ctx, e.db, model.GangPK{GangID: claim.GangID})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get gang '%s'", claim.GangID)
+		}
+
+		if err = e.releaseGang(ctx, gang, claim); err != nil {
+			if errors.Cause(err) == model.ErrClaimNotExists {
+				logs.Printf("[INFO] Claim '%s' was already released", claim.ClaimPK)
+				return nil
+			}
+
+			return err
+		}
+
+		return nil
 	}
 
-	if serr := e.Submit(ctx, claim.PoolID, claim.Size); serr != nil {
-		e.logs.Printf("[WARN] failed to re-submit claim as task: %v", serr)
+	data, err := json.Marshal(ScheduleMsg{
+		TaskID:    claim.TaskID,
+		Size:      claim.Size,
+		PoolID:    claim.PoolID,
+		Priority:  claim.Priority,
+		Submitter: claim.Submitter,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal schedule message")
 	}
 
-	err := model.DeleteClaim(ctx, e.db, claim.ClaimPK)
+	out, err := model.NewOutbox(FmtTenantScheduleQueueName(claim.Priority, claim.Submitter), string(data), time.Now().Add(OutboxDeliveryTimeout))
 	if err != nil {
-		return errors.Wrapf(err, "failed to delete claim '%s'", claim.ClaimPK)
+		return errors.Wrap(err, "failed to create outbox message")
+	}
+
+	err = e.removeClaim(ctx, claim, out)
+	if errors.Cause(err) == model.ErrTaskNotScheduled {
+		logs.Printf("[INFO] Task of claim '%s' is no longer scheduled with it, removing the claim without rescheduling", claim.ClaimPK)
+		if err = e.removeClaim(ctx, claim, nil); err == nil {
+			return nil
+		}
+	}
+
+	if err != nil {
+		if errors.Cause(err) == model.ErrClaimNotExists {
+			logs.Printf("[INFO] Claim '%s' was already released", claim.ClaimPK)
+			return nil
+		}
+
+		return err
+	}
+
+	logs.Printf("[INFO] Released claim '%s' for rescheduling", claim.ClaimPK)
+	if derr := e.deliver(ctx, out); derr != nil {
+		logs.Printf("[WARN] failed to deliver re-submission of claim '%s', leaving it to the outbox sweep: %v", claim.ClaimPK, derr)
 	}
 
 	return nil
 }
 
-func (e *Engine) deleteNode(ctx context.Context, pk model.NodePK) error {
-	e.logs.Printf("[INFO] Deregister node '%s'", pk)
-	err := model.DeregisterNode(ctx, e.db, pk)
+//removeClaim deletes the claim and returns its capacity to the node, if the
+//node still exists and can take it back, and to its quotas. The outbox
+//message (if any) and extra items are written in the same transaction.
+func (e *Engine) removeClaim(ctx context.Context, claim *model.Claim, out *model.Outbox, extra ...*model.TxItem) error {
+	logs := e.logs.With(claimFields(claim))
+	quotaItems, err := releaseQuotaItems(claim)
+	if err != nil {
+		return err
+	}
+
+	extra = append(quotaItems, extra...)
+	node, err := model.GetNode(ctx, e.db, model.NodePK{NodeID: claim.NodeID})
+	if err != nil {
+		if errors.Cause(err) != model.ErrNodeNotExists {
+			return errors.Wrapf(err, "failed to get node '%s'", claim.NodeID)
+		}
+
+		logs.Printf("[DEBUG] Node '%s' of claim '%s' no longer exists, removing without returning capacity", claim.NodeID, claim.ClaimPK)
+		node = nil
+	}
+
+	err = model.ReleaseClaim(ctx, e.db, claim, node, out, extra...)
+	if node != nil && errors.Cause(err) == model.ErrNodeReturnUnfit {
+		logs.Printf("[WARN] Node '%s' can't take back capacity of claim '%s', removing without it", claim.NodeID, claim.ClaimPK)
+		err = model.ReleaseClaim(ctx, e.db, claim, nil, out, extra...)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "failed to release claim '%s'", claim.ClaimPK)
+	}
+
+	return nil
+}
+
+//deliver sends an outbox message to its schedule queue and removes it, a
+//message that is not yet due is delayed until it is
+func (e *Engine) deliver(ctx context.Context, out *model.Outbox) error {
+	queue := out.Queue
+	if queue == "" {
+		queue = FmtScheduleQueueName(model.PriorityNormal)
+	}
+
+	var delay time.Duration
+	if out.Due > 0 {
+		delay = time.Until(time.Unix(out.Due, 0))
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+
+	if err := sendQueueMessage(ctx, e.q, queue, out.DeduplicationID(), out.Body, delay); err != nil {
+		return errors.Wrap(err, "failed to send schedule message")
+	}
+
+	if err := model.DeleteOutbox(ctx, e.db, out.OutboxPK); err != nil {
+		return errors.Wrap(err, "failed to delete outbox message")
+	}
+
+	return nil
+}
+
+//DeliverOutbox queries the database for outbox messages that were not delivered in time and delivers them.
+//A message that fails to deliver is logged and left for the next cycle so it
+//doesn't hold back the others.
+func (e *Engine) DeliverOutbox(ctx context.Context) (err error) {
+	undelivered, err := model.UndeliveredOutbox(ctx, e.db, MaxUndeliveredOutboxPerPartition)
+	if err != nil {
+		return errors.Wrap(err, "failed to query undelivered outbox messages")
+	}
+
+	e.logs.Printf("[INFO] found %d undelivered outbox messages", len(undelivered))
+	for _, out := range undelivered {
+		err := e.deliver(ctx, out)
+		if err != nil {
+			if errors.Cause(err) == model.ErrOutboxNotExists {
+				continue //delivered by the releaser while we were sending
+			}
+
+			e.logs.Printf("[WARN] Failed to deliver outbox message '%s', retrying next cycle: %v", out.OutboxPK, err)
+		}
+	}
+
+	return nil
+}
+
+//deleteNode deregisters the node and deletes its queue, if expired is set
+//the node is only deregistered if it wasn't taken back in the meantime
+func (e *Engine) deleteNode(ctx context.Context, pk model.NodePK, expired bool) error {
+	logs := e.logs.With(Fields{FieldNodeID: pk.NodeID})
+	logs.Printf("[INFO] Deregister node '%s'", pk)
+	deregister := model.DeregisterNode
+	if expired {
+		deregister = model.DeregisterExpiredNode
+	}
+
+	err := deregister(ctx, e.db, pk)
 	if err != nil {
 		return errors.Wrap(err, "failed to deregister node")
 	}
 
-	e.logs.Printf("[DEBUG] Deleting queue for node '%s'", pk)
+	logs.Printf("[DEBUG] Deleting queue for node '%s'", pk)
 	err = DeleteNodeQueue(ctx, e.q, pk)
 	if err != nil {
 		return errors.Wrap(err, "failed to delete node queue")
@@ -19,53 +19,160 @@ var (
 
 	//ClaimHeartbeatTimeout determines how often the node has to call in
 	ClaimHeartbeatTimeout = time.Second * 30
+
+	//ErrNoNodeCapacity is returned when no node of the pool fits the task, its
+	//demand is then recorded for the autoscaler
+	ErrNoNodeCapacity = errors.New("no nodes with enough capacity")
 )
 
-//Schedule will place a task on a node
-func (e *Engine) Schedule(ctx context.Context, poolID string, size int64) error {
+//Schedule will place a task on a node. The pool, size and submitter are
+//taken from the task's record, tasks without a record are not scheduled. If
+//no node fits the task, claims of tasks with a lower priority are preempted.
+func (e *Engine) Schedule(ctx context.Context, taskID string) (err error) {
+	logs := e.logs.With(Fields{FieldTaskID: taskID})
+	task, err := model.GetTask(ctx, e.db, model.TaskPK{TaskID: taskID})
+	if err != nil {
+		if errors.Cause(err) == model.ErrTaskNotExists {
+			logs.Printf("[WARN] Task has no record, it won't be scheduled")
+			return nil
+		}
+
+		return errors.Wrap(err, "failed to get task")
+	}
+
+	if task.Finished() {
+		logs.Printf("[INFO] Task was cancelled or finished, it won't be scheduled")
+		return nil
+	}
+
+	if task.State == model.TaskStateWaiting {
+		logs.Printf("[WARN] Task waits for the upstream tasks of its workflow, it won't be scheduled yet")
+		return nil
+	}
+
+	if task.GangID != "" {
+		logs.Printf("[WARN] Task is part of gang '%s', it is only scheduled with its gang", task.GangID)
+		return nil
+	}
+
+	//schedule messages are delivered at least once, a task keeps its claim
+	//until that is released and the task is put back to pending
+	if task.State == model.TaskStateScheduled && task.ClaimID != "" {
+		claim, err := model.GetClaim(ctx, e.db, model.ClaimPK{ClaimID: task.ClaimID})
+		switch {
+		case err == nil && claim.State == model.ClaimStateDispatched:
+			logs.Printf("[INFO] Task is already scheduled with claim '%s' that was not started, dispatching it again", claim.ClaimPK)
+			return e.dispatch(ctx, claim)
+		case err == nil:
+			logs.Printf("[INFO] Task is already scheduled with claim '%s', it won't be scheduled again", claim.ClaimPK)
+			return nil
+		case errors.Cause(err) != model.ErrClaimNotExists:
+			return errors.Wrapf(err, "failed to get claim '%s'", task.ClaimID)
+		}
+	}
+
+	poolID, size := task.PoolID, task.Size
+	logs = logs.With(Fields{FieldPoolID: poolID})
+	start := time.Now()
+	defer func() {
+		ScheduleDuration.WithLabelValues(poolID, result(err)).Observe(time.Since(start).Seconds())
+	}()
 
-	var claimed *model.Node
+	quotas, err := e.quotas(ctx, poolID, task.Submitter)
+	if err != nil {
+		return errors.Wrap(err, "failed to get quotas")
+	}
+
+	var claim *model.Claim
+	attempts, preempted := 0, false
 	operation := func() error {
-		e.logs.Printf("[DEBUG] quering nodes with at least capacity >= %d", size)
+		attempts++
+		logs.Printf("[DEBUG] quering nodes with at least capacity >= %d", size)
 		nodes, err := model.NodesWithEnoughCapacity(ctx, e.db, poolID, size, MaxClaimCandidates)
 		if err != nil {
 			return errors.Wrap(err, "failed to find nodes with enough capacity")
 		}
 
-		e.logs.Printf("[DEBUG] found %d nodes with enough capacity", len(nodes))
+		logs.Printf("[DEBUG] found %d nodes with enough capacity", len(nodes))
 		for _, node := range nodes {
-			err = model.ClaimNodeCapacity(ctx, e.db, node.NodePK, size)
+			if node.Drain {
+				continue
+			}
+
+			candidate, err := model.NewClaim(task, node.NodeID, time.Now().Add(ClaimHeartbeatTimeout))
+			if err != nil {
+				return errors.Wrap(err, "failed to create claim")
+			}
+
+			quotaItems, err := chargeQuotaItems(quotas, task, candidate)
+			if err != nil {
+				return err
+			}
+
+			err = model.PlaceClaim(ctx, e.db, task, candidate, quotaItems...)
 			if err != nil {
 				if errors.Cause(err) == model.ErrNodeCapacityUnfit {
 					continue
 				}
 
-				return errors.Wrap(err, "failed to claim node capacity")
+				if errors.Cause(err) == model.ErrTaskCancelled || errors.Cause(err) == model.ErrQuotaExceeded {
+					return backoff.Permanent(err)
+				}
+
+				return errors.Wrap(err, "failed to place claim")
 			}
 
-			e.logs.Printf("[INFO] successfully claimed %d capacity on node %v", size, node.NodePK)
-			claimed = node
+			logs.With(Fields{FieldNodeID: node.NodeID, FieldClaimID: candidate.ClaimID}).Printf("[INFO] successfully claimed %d capacity on node %v", size, node.NodePK)
+			claim = candidate
 
 			return nil //no need to consider other nodes, we succeeded
 		}
 
-		return errors.New("no nodes with enough capacity")
+		//only once per task, so claims are not released for every retry
+		if !preempted && model.PriorityLevel(task.Priority) >= model.PriorityLevel(MinPreemptingPriority) {
+			preempted = true
+			n, err := e.preempt(ctx, task)
+			if err != nil {
+				return errors.Wrap(err, "failed to preempt claims of lower priority")
+			}
+
+			if n > 0 {
+				return errors.Errorf("no nodes with enough capacity, preempted %d claims of lower priority", n)
+			}
+		}
+
+		return ErrNoNodeCapacity
 	}
 
 	b := backoff.NewExponentialBackOff()
-	err := backoff.Retry(operation, backoff.WithContext(
+	err = backoff.Retry(operation, backoff.WithContext(
 		backoff.WithMaxTries(b, MaxClaimRetries), ctx))
-	if err != nil || claimed == nil {
-		return errors.Wrap(err, "failed to claim node capacity")
+	ScheduleAttempts.WithLabelValues(poolID).Observe(float64(attempts))
+	if errors.Cause(err) == model.ErrTaskCancelled {
+		logs.Printf("[INFO] Task was cancelled, finished or scheduled concurrently, it won't be scheduled")
+		return nil
 	}
 
-	ttl := time.Now().Add(ClaimHeartbeatTimeout)
-	claim, err := model.CreateClaim(ctx, e.db, poolID, claimed.NodeID, size, ttl)
-	if err != nil {
-		return errors.Wrap(err, "failed to create claim")
+	if errors.Cause(err) == model.ErrQuotaExceeded {
+		//the message is received again once it becomes visible
+		return errors.Wrap(err, "task waits for quota")
 	}
 
+	if errors.Cause(err) == ErrNoNodeCapacity {
+		e.recordDemand(ctx, taskID, poolID, size)
+	}
+
+	if err != nil || claim == nil {
+		return errors.Wrap(err, "failed to claim node capacity")
+	}
+
+	return e.dispatch(ctx, claim)
+}
+
+//dispatch sends the run message of a claim that was placed to its node
+func (e *Engine) dispatch(ctx context.Context, claim *model.Claim) error {
 	msg := RunMsg{
+		TaskID:  claim.TaskID,
 		Size:    claim.Size,
 		ClaimID: claim.ClaimID,
 	}
@@ -76,9 +183,9 @@ func (e *Engine) Schedule(ctx context.Context, poolID string, size int64) error
 	}
 
 	msgs := string(data)
-	e.logs.Printf("[DEBUG] Dispatching message '%s' to node '%s'", msgs, claimed.NodePK)
-	err = SendNodeMessage(ctx, e.q, claimed.NodePK, msgs)
-	if err != nil {
+	nodePK := model.NodePK{NodeID: claim.NodeID}
+	e.logs.With(claimFields(claim)).Printf("[DEBUG] Dispatching message '%s' to node '%s'", msgs, nodePK)
+	if err = SendNodeMessage(ctx, e.q, nodePK, msgs); err != nil {
 		return errors.Wrapf(err, "failed to send node message '%s'", msgs)
 	}
 
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	//NodeIDFileName is the file in the state directory that holds the node id
+	NodeIDFileName = "node_id"
+)
+
+//readNodeID returns the node id persisted in the state dir or an empty
+//string if there is none
+func readNodeID(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, NodeIDFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", errors.Wrap(err, "failed to read node id file")
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+//writeNodeID persists the node id in the state dir
+func writeNodeID(dir string, nodeID string) error {
+	if dir == "" {
+		return nil
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "failed to create state dir")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, NodeIDFileName), []byte(nodeID+"\n"), 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to write node id file")
+	}
+
+	return nil
+}
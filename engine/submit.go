@@ -3,25 +3,257 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"time"
 
+	"github.com/advanderveer/factory/model"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
-//Submit will submit a task for execution on a node
-func (e *Engine) Submit(ctx context.Context, poolID string, size int64) error {
-	data := ScheduleMsg{
-		Size:   size,
-		PoolID: poolID,
+var (
+	//IdempotencyWindow determines how long the key of a submission prevents
+	//the same task from being submitted again
+	IdempotencyWindow = time.Hour * 24
+)
+
+//TaskSpec describes a task that is submitted
+type TaskSpec struct {
+	PoolID string
+	Size   int64
+
+	//Submitter is the identity that submitted the task, it is recorded on the
+	//task and its claims
+	Submitter string
+
+	//Priority is one of the model's priority classes, tasks are of normal
+	//priority if it is empty
+	Priority string
+
+	//NotBefore delays the scheduling of the task until that moment, it is
+	//scheduled right away if it is zero or has passed
+	NotBefore time.Time
+
+	//IdempotencyKey is optional, submitting again with the same key within
+	//the IdempotencyWindow returns the task of the first submission
+	IdempotencyKey string
+}
+
+//taskScheduleMsg returns the message that schedules the task
+func taskScheduleMsg(task *model.Task) ScheduleMsg {
+	return ScheduleMsg{
+		TaskID:    task.TaskID,
+		Size:      task.Size,
+		PoolID:    task.PoolID,
+		Priority:  task.Priority,
+		Submitter: task.Submitter,
+	}
+}
+
+//submission is a task that is stored before its schedule message is send
+type submission struct {
+	task  *model.Task
+	msg   string
+	delay time.Duration
+
+	//out holds the schedule message, it is stored with the task so that it
+	//is delivered by the outbox sweep if sending it after the commit fails
+	out *model.Outbox
+
+	//timed is set when the delay is kept by a timer that the pump sends the
+	//message for, it then isn't send by the submitter
+	timed bool
+
+	//earlier is the id of the task that was submitted before with the same
+	//idempotency key, nothing was stored and nothing is to be send
+	earlier string
+}
+
+//newSubmission validates the spec and creates the task it submits
+func newSubmission(spec TaskSpec) (*submission, error) {
+	if !model.ValidPriority(spec.Priority) {
+		return nil, errors.Errorf("unknown priority '%s'", spec.Priority)
+	}
+
+	task, err := model.NewTask(spec.PoolID, spec.Size, spec.Submitter)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create task")
 	}
 
-	msg, err := json.Marshal(data)
+	if spec.Priority != "" {
+		task.Priority = spec.Priority
+	}
+
+	if spec.NotBefore.After(time.Now()) {
+		task.NotBefore = spec.NotBefore.Unix()
+	}
+
+	msg, err := json.Marshal(taskScheduleMsg(task))
 	if err != nil {
-		return errors.Wrap(err, "failed to marshal schedule message")
+		return nil, errors.Wrap(err, "failed to marshal schedule message")
+	}
+
+	//delays the queue can't hold are kept by a timer that is stored with the
+	//task, the pump sends the message once it is due
+	sub := &submission{task: task, msg: string(msg)}
+	if spec.NotBefore.After(time.Now()) {
+		sub.delay = time.Until(spec.NotBefore)
+		sub.timed = sub.delay > MaxQueueDelay || ScheduleQueueFIFO
 	}
 
-	if err := SendScheduleMessage(ctx, e.q, string(msg)); err != nil {
-		return errors.Wrap(err, "failed to send schedule message")
+	return sub, nil
+}
+
+//store records the task of the submission and counts it towards the quotas
+func (e *Engine) store(ctx context.Context, spec TaskSpec, sub *submission) (err error) {
+	//a retry of a submission that succeeded is answered before the quotas are
+	//checked, as those may be full with the task it submitted
+	if spec.IdempotencyKey != "" {
+		idem, err := model.GetIdempotency(ctx, e.db, model.FmtIdempotencyPK(spec.Submitter, spec.IdempotencyKey))
+		if err == nil {
+			sub.earlier, err = e.submitted(ctx, idem)
+			return err
+		}
+
+		if errors.Cause(err) != model.ErrIdempotencyKeyNotExists {
+			return errors.Wrap(err, "failed to get idempotency key")
+		}
+	}
+
+	quotas, err := e.quotas(ctx, spec.PoolID, spec.Submitter)
+	if err != nil {
+		return errors.Wrap(err, "failed to get quotas")
+	}
+
+	if err = checkQuotaSize(quotas, spec.Size); err != nil {
+		return err
+	}
+
+	var extra []*model.TxItem
+	if spec.IdempotencyKey != "" {
+		item, err := model.TxPutIdempotency(model.NewIdempotency(spec.Submitter, spec.IdempotencyKey, sub.task.TaskID, time.Now().Add(IdempotencyWindow)))
+		if err != nil {
+			return errors.Wrap(err, "failed to create idempotency item")
+		}
+
+		extra = append(extra, item)
+	}
+
+	if sub.timed {
+		timer, err := model.NewTimer(FmtTenantScheduleQueueName(sub.task.Priority, sub.task.Submitter), sub.msg, spec.NotBefore)
+		if err != nil {
+			return errors.Wrap(err, "failed to create timer")
+		}
+
+		timer.DedupID = sub.task.TaskID
+
+		item, err := model.TxPutTimer(timer)
+		if err != nil {
+			return errors.Wrap(err, "failed to create timer item")
+		}
+
+		extra = append(extra, item)
+	} else {
+		out, err := model.NewOutbox(FmtTenantScheduleQueueName(sub.task.Priority, sub.task.Submitter), sub.msg, time.Now().Add(OutboxDeliveryTimeout))
+		if err != nil {
+			return errors.Wrap(err, "failed to create outbox message")
+		}
+
+		out.Due = sub.task.NotBefore
+		out.DedupID = sub.task.TaskID
+		item, err := model.TxPutOutbox(out)
+		if err != nil {
+			return errors.Wrap(err, "failed to create outbox item")
+		}
+
+		sub.out = out
+		extra = append(extra, item)
+	}
+
+	if err = model.SubmitTask(ctx, e.db, sub.task, quotas, extra...); err != nil {
+		if errors.Cause(err) == model.ErrIdempotencyKeyUsed {
+			idem, err := model.GetIdempotency(ctx, e.db, model.FmtIdempotencyPK(spec.Submitter, spec.IdempotencyKey))
+			if err != nil {
+				return errors.Wrap(err, "failed to get task of idempotency key")
+			}
+
+			sub.earlier, err = e.submitted(ctx, idem)
+			return err
+		}
+
+		return errors.Wrap(err, "failed to store task")
 	}
 
 	return nil
 }
+
+//Submit will record a task and submit it for execution on a node, it returns
+//the task id. Tasks that don't fit the quotas of the pool and submitter are
+//rejected with model.ErrQuotaExceeded as their cause. Tasks with a not
+//before in the future count towards the quotas but are scheduled once it passed.
+//Submitting with an idempotency key that was used before returns that task.
+//The schedule message is stored with the task, once that is committed the
+//task is scheduled even if sending the message fails.
+func (e *Engine) Submit(ctx context.Context, spec TaskSpec) (taskID string, err error) {
+	sub, err := newSubmission(spec)
+	if err != nil {
+		return "", err
+	}
+
+	task := sub.task
+	taskID = task.TaskID
+	ctx, span := startSpan(ctx, "factory.submit", trace.SpanKindProducer, Fields{FieldTaskID: taskID, FieldPoolID: spec.PoolID})
+	defer func() { endSpan(span, err) }()
+
+	if err = e.store(ctx, spec, sub); err != nil {
+		return "", err
+	}
+
+	if sub.earlier != "" {
+		return sub.earlier, nil
+	}
+
+	//timed tasks are send by the pump once their timer is due
+	if sub.out != nil {
+		if derr := e.deliver(ctx, sub.out); derr != nil {
+			e.logs.With(Fields{FieldTaskID: taskID}).Printf("[WARN] Failed to send schedule message of task '%s', leaving it to the outbox sweep: %v", task.TaskPK, derr)
+		}
+	}
+
+	if sub.delay > 0 {
+		e.logs.With(Fields{FieldTaskID: taskID, FieldPoolID: spec.PoolID}).Printf("[INFO] Submitted task of size %d with %s priority, it is not scheduled before %s", spec.Size, task.Priority, spec.NotBefore.Format(time.RFC3339))
+		return taskID, nil
+	}
+
+	e.logs.With(Fields{FieldTaskID: taskID, FieldPoolID: spec.PoolID}).Printf("[INFO] Submitted task of size %d with %s priority", spec.Size, task.Priority)
+	return taskID, nil
+}
+
+//submitted returns the task that was submitted earlier with the key. If the
+//schedule queues are FIFO and the task is still pending its message is send
+//again, SQS drops it if the first one did arrive.
+func (e *Engine) submitted(ctx context.Context, idem *model.Idempotency) (taskID string, err error) {
+	e.logs.With(Fields{FieldTaskID: idem.TaskID}).Printf("[INFO] Idempotency key '%s' was used before, returning the task it submitted", idem.IdempotencyPK)
+	if !ScheduleQueueFIFO {
+		return idem.TaskID, nil
+	}
+
+	task, err := model.GetTask(ctx, e.db, model.TaskPK{TaskID: idem.TaskID})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get task")
+	}
+
+	if task.State != model.TaskStatePending || task.NotBefore > 0 {
+		return task.TaskID, nil
+	}
+
+	msg, err := json.Marshal(taskScheduleMsg(task))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal schedule message")
+	}
+
+	if err = SendScheduleMessage(ctx, e.q, task.Priority, task.Submitter, task.TaskID, string(msg)); err != nil {
+		return "", errors.Wrap(err, "failed to send schedule message")
+	}
+
+	return task.TaskID, nil
+}
@@ -0,0 +1,42 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	//MaxDueTimersPerPartition determines the max nr of timers per partition that are delivered per cycle
+	MaxDueTimersPerPartition = int64(10)
+)
+
+//DeliverTimers queries the database for timers that are due, sends their
+//messages and removes them. Like outbox messages, a timer that could not be
+//removed after its message was send is send again next cycle. A timer that
+//fails to deliver is logged and left for the next cycle.
+func (e *Engine) DeliverTimers(ctx context.Context) (err error) {
+	due, err := model.DueTimers(ctx, e.db, MaxDueTimersPerPartition)
+	if err != nil {
+		return errors.Wrap(err, "failed to query due timers")
+	}
+
+	e.logs.Printf("[INFO] found %d due timers", len(due))
+	for _, timer := range due {
+		if err = sendQueueMessage(ctx, e.q, timer.Queue, timer.DeduplicationID(), timer.Body, 0); err != nil {
+			e.logs.Printf("[WARN] Failed to send message of timer '%s', retrying next cycle: %v", timer.TimerPK, err)
+			continue
+		}
+
+		if err = model.DeleteTimer(ctx, e.db, timer.TimerPK); err != nil {
+			if errors.Cause(err) == model.ErrTimerNotExists {
+				continue //delivered by another pump while we were sending
+			}
+
+			e.logs.Printf("[WARN] Failed to delete timer '%s', it is send again next cycle: %v", timer.TimerPK, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//TracerName names the tracer that creates the spans of the engine
+const TracerName = "github.com/advanderveer/factory/engine"
+
+func tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+//startSpan starts a span that describes the engine's progress on a task
+func startSpan(ctx context.Context, name string, kind trace.SpanKind, fields Fields) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{}
+	for k, v := range fields {
+		if s, ok := v.(string); ok && s != "" {
+			attrs = append(attrs, attribute.String("factory."+k, s))
+		}
+	}
+
+	return tracer().Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+//endSpan ends the span, recording the error if there is one
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+//msgCarrier propagates trace context through SQS message attributes
+type msgCarrier map[string]*sqs.MessageAttributeValue
+
+func (c msgCarrier) Get(key string) string {
+	if v, ok := c[key]; ok {
+		return aws.StringValue(v.StringValue)
+	}
+
+	return ""
+}
+
+func (c msgCarrier) Set(key string, value string) {
+	c[key] = (&sqs.MessageAttributeValue{}).SetDataType("String").SetStringValue(value)
+}
+
+func (c msgCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+//injectTrace returns message attributes that carry the trace context
+func injectTrace(ctx context.Context) map[string]*sqs.MessageAttributeValue {
+	carrier := msgCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) < 1 {
+		return nil
+	}
+
+	return carrier
+}
+
+//extractTrace returns a context that continues the trace carried by the message
+func extractTrace(ctx context.Context, msg *sqs.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, msgCarrier(msg.MessageAttributes))
+}
+
+//tracedDB creates a span around each DynamoDB call the engine makes
+type tracedDB struct {
+	model.DB
+}
+
+func dbSpan(ctx context.Context, op string, table *string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "dynamodb."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "dynamodb"),
+		attribute.String("db.operation", op),
+		attribute.String("aws.dynamodb.table_names", aws.StringValue(table)),
+	))
+}
+
+func (db tracedDB) GetItemWithContext(ctx aws.Context, inp *dynamodb.GetItemInput, opts ...request.Option) (out *dynamodb.GetItemOutput, err error) {
+	ctx, span := dbSpan(ctx, "GetItem", inp.TableName)
+	defer func() { endSpan(span, err) }()
+	return db.DB.GetItemWithContext(ctx, inp, opts...)
+}
+
+func (db tracedDB) PutItemWithContext(ctx aws.Context, inp *dynamodb.PutItemInput, opts ...request.Option) (out *dynamodb.PutItemOutput, err error) {
+	ctx, span := dbSpan(ctx, "PutItem", inp.TableName)
+	defer func() { endSpan(span, err) }()
+	return db.DB.PutItemWithContext(ctx, inp, opts...)
+}
+
+func (db tracedDB) UpdateItemWithContext(ctx aws.Context, inp *dynamodb.UpdateItemInput, opts ...request.Option) (out *dynamodb.UpdateItemOutput, err error) {
+	ctx, span := dbSpan(ctx, "UpdateItem", inp.TableName)
+	defer func() { endSpan(span, err) }()
+	return db.DB.UpdateItemWithContext(ctx, inp, opts...)
+}
+
+func (db tracedDB) DeleteItemWithContext(ctx aws.Context, inp *dynamodb.DeleteItemInput, opts ...request.Option) (out *dynamodb.DeleteItemOutput, err error) {
+	ctx, span := dbSpan(ctx, "DeleteItem", inp.TableName)
+	defer func() { endSpan(span, err) }()
+	return db.DB.DeleteItemWithContext(ctx, inp, opts...)
+}
+
+func (db tracedDB) QueryWithContext(ctx aws.Context, inp *dynamodb.QueryInput, opts ...request.Option) (out *dynamodb.QueryOutput, err error) {
+	ctx, span := dbSpan(ctx, "Query", inp.TableName)
+	defer func() { endSpan(span, err) }()
+	return db.DB.QueryWithContext(ctx, inp, opts...)
+}
+
+func (db tracedDB) ScanWithContext(ctx aws.Context, inp *dynamodb.ScanInput, opts ...request.Option) (out *dynamodb.ScanOutput, err error) {
+	ctx, span := dbSpan(ctx, "Scan", inp.TableName)
+	defer func() { endSpan(span, err) }()
+	return db.DB.ScanWithContext(ctx, inp, opts...)
+}
+
+func (db tracedDB) TransactWriteItemsWithContext(ctx aws.Context, inp *dynamodb.TransactWriteItemsInput, opts ...request.Option) (out *dynamodb.TransactWriteItemsOutput, err error) {
+	ctx, span := dbSpan(ctx, "TransactWriteItems", nil)
+	defer func() { endSpan(span, err) }()
+	return db.DB.TransactWriteItemsWithContext(ctx, inp, opts...)
+}
+
+//tracedQ creates a span around each SQS call the engine makes
+type tracedQ struct {
+	Q
+}
+
+func qSpan(ctx context.Context, op string, queueURL *string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "sqs."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("messaging.system", "aws_sqs"),
+		attribute.String("messaging.operation", op),
+		attribute.String("messaging.destination.name", aws.StringValue(queueURL)),
+	))
+}
+
+func (q tracedQ) SendMessageWithContext(ctx aws.Context, inp *sqs.SendMessageInput, opts ...request.Option) (out *sqs.SendMessageOutput, err error) {
+	ctx, span := qSpan(ctx, "SendMessage", inp.QueueUrl)
+	defer func() { endSpan(span, err) }()
+	return q.Q.SendMessageWithContext(ctx, inp, opts...)
+}
+
+func (q tracedQ) ReceiveMessageWithContext(ctx aws.Context, inp *sqs.ReceiveMessageInput, opts ...request.Option) (out *sqs.ReceiveMessageOutput, err error) {
+	ctx, span := qSpan(ctx, "ReceiveMessage", inp.QueueUrl)
+	defer func() { endSpan(span, err) }()
+	return q.Q.ReceiveMessageWithContext(ctx, inp, opts...)
+}
+
+func (q tracedQ) DeleteMessageWithContext(ctx aws.Context, inp *sqs.DeleteMessageInput, opts ...request.Option) (out *sqs.DeleteMessageOutput, err error) {
+	ctx, span := qSpan(ctx, "DeleteMessage", inp.QueueUrl)
+	defer func() { endSpan(span, err) }()
+	return q.Q.DeleteMessageWithContext(ctx, inp, opts...)
+}
+
+func (q tracedQ) CreateQueueWithContext(ctx aws.Context, inp *sqs.CreateQueueInput, opts ...request.Option) (out *sqs.CreateQueueOutput, err error) {
+	ctx, span := qSpan(ctx, "CreateQueue", inp.QueueName)
+	defer func() { endSpan(span, err) }()
+	return q.Q.CreateQueueWithContext(ctx, inp, opts...)
+}
+
+func (q tracedQ) DeleteQueueWithContext(ctx aws.Context, inp *sqs.DeleteQueueInput, opts ...request.Option) (out *sqs.DeleteQueueOutput, err error) {
+	ctx, span := qSpan(ctx, "DeleteQueue", inp.QueueUrl)
+	defer func() { endSpan(span, err) }()
+	return q.Q.DeleteQueueWithContext(ctx, inp, opts...)
+}
+
+func (q tracedQ) GetQueueAttributesWithContext(ctx aws.Context, inp *sqs.GetQueueAttributesInput, opts ...request.Option) (out *sqs.GetQueueAttributesOutput, err error) {
+	ctx, span := qSpan(ctx, "GetQueueAttributes", inp.QueueUrl)
+	defer func() { endSpan(span, err) }()
+	return q.Q.GetQueueAttributesWithContext(ctx, inp, opts...)
+}
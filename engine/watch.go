@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	//WatchPollInterval determines how often watchers poll the tables for changes
+	WatchPollInterval = time.Second * 2
+)
+
+//NodeEvent reports a node that was first seen or changed, or that was removed
+type NodeEvent struct {
+	Node    *model.Node
+	Removed bool
+}
+
+//TaskStatus returns the task as it currently stands. A task whose claim was
+//released is reported as pending until it is placed again.
+func (e *Engine) TaskStatus(ctx context.Context, taskID string) (*model.Task, error) {
+	task, err := model.GetTask(ctx, e.db, model.TaskPK{TaskID: taskID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get task")
+	}
+
+	if task.State != model.TaskStateScheduled {
+		return task, nil
+	}
+
+	if _, err = model.GetClaim(ctx, e.db, model.ClaimPK{ClaimID: task.ClaimID}); err != nil {
+		if errors.Cause(err) != model.ErrClaimNotExists {
+			return nil, errors.Wrapf(err, "failed to get claim '%s'", task.ClaimID)
+		}
+
+		task.State = model.TaskStatePending
+		task.NodeID = ""
+		task.ClaimID = ""
+	}
+
+	return task, nil
+}
+
+//WatchTask polls the task and calls fn when it is first seen and each time it
+//changes. It returns when the task finished, fn fails or ctx is done.
+func (e *Engine) WatchTask(ctx context.Context, taskID string, fn func(task *model.Task) error) error {
+	var last *model.Task
+	ticker := time.NewTicker(WatchPollInterval)
+	defer ticker.Stop()
+	for {
+		task, err := e.TaskStatus(ctx, taskID)
+		if err != nil {
+			return errors.Wrap(err, "failed to get task status")
+		}
+
+		if last == nil || *last != *task {
+			if err = fn(task); err != nil {
+				return err
+			}
+
+			last = task
+		}
+
+		if task.Finished() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+//WatchNodes polls the nodes of the pool (or all nodes if empty) and calls fn
+//for each node that is first seen, changed or removed. It returns when fn
+//fails or ctx is done.
+func (e *Engine) WatchNodes(ctx context.Context, poolID string, fn func(ev NodeEvent) error) error {
+	known := map[string]model.Node{}
+	ticker := time.NewTicker(WatchPollInterval)
+	defer ticker.Stop()
+	for {
+		nodes, err := model.ListNodes(ctx, e.db)
+		if err != nil {
+			return errors.Wrap(err, "failed to list nodes")
+		}
+
+		seen := map[string]struct{}{}
+		for _, node := range nodes {
+			if poolID != "" && node.PoolID != poolID {
+				continue
+			}
+
+			seen[node.NodeID] = struct{}{}
+			if prev, ok := known[node.NodeID]; ok && prev == *node {
+				continue
+			}
+
+			if err = fn(NodeEvent{Node: node}); err != nil {
+				return err
+			}
+
+			known[node.NodeID] = *node
+		}
+
+		for nodeID, prev := range known {
+			if _, ok := seen[nodeID]; ok {
+				continue
+			}
+
+			node := prev
+			if err = fn(NodeEvent{Node: &node, Removed: true}); err != nil {
+				return err
+			}
+
+			delete(known, nodeID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
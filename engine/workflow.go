@@ -0,0 +1,366 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	//MaxWorkflowTasks limits the number of tasks in a workflow, the workflow
+	//is submitted in one transaction which can hold at most 100 items
+	MaxWorkflowTasks = 40
+
+	//MaxAdvancedWorkflows is the max nr of running workflows that are advanced per pump cycle
+	MaxAdvancedWorkflows = int64(10)
+)
+
+//WorkflowTaskSpec describes a named task of a workflow
+type WorkflowTaskSpec struct {
+	Name   string
+	PoolID string
+	Size   int64
+
+	//DependsOn names the tasks that must succeed before this one is scheduled
+	DependsOn []string
+}
+
+//WorkflowSpec describes a workflow that is submitted
+type WorkflowSpec struct {
+	Tasks     []WorkflowTaskSpec
+	Submitter string
+	Priority  string
+
+	//OnFailure is model.OnFailureFail or model.OnFailureSkip and determines
+	//what happens to the downstream tasks of a task that did not succeed, it
+	//defaults to failing them
+	OnFailure string
+}
+
+//SubmitWorkflow records a workflow and its tasks, it returns the workflow id.
+//Tasks without dependencies are submitted for execution right away, the
+//others wait until the pump finds that their upstream tasks succeeded.
+func (e *Engine) SubmitWorkflow(ctx context.Context, spec WorkflowSpec) (workflowID string, err error) {
+	if len(spec.Tasks) < 1 || len(spec.Tasks) > MaxWorkflowTasks {
+		return "", errors.Errorf("a workflow must have between 1 and %d tasks", MaxWorkflowTasks)
+	}
+
+	if !model.ValidPriority(spec.Priority) {
+		return "", errors.Errorf("unknown priority '%s'", spec.Priority)
+	}
+
+	if spec.OnFailure == "" {
+		spec.OnFailure = model.OnFailureFail
+	}
+
+	if spec.OnFailure != model.OnFailureFail && spec.OnFailure != model.OnFailureSkip {
+		return "", errors.Errorf("unknown failure policy '%s'", spec.OnFailure)
+	}
+
+	wf, err := model.NewWorkflow(spec.Submitter, spec.OnFailure)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create workflow")
+	}
+
+	workflowID = wf.WorkflowID
+	ctx, span := startSpan(ctx, "factory.submit_workflow", trace.SpanKindProducer, Fields{FieldWorkflowID: workflowID})
+	defer func() { endSpan(span, err) }()
+
+	//tasks without dependencies are counted as queued right away, one item
+	//per quota as a transaction can't write the same quota twice
+	tasks, roots, names := []*model.Task{}, []*model.Task{}, map[string]bool{}
+	queued, quotas := map[model.QuotaPK]int64{}, map[model.QuotaPK]*model.Quota{}
+	for _, ts := range spec.Tasks {
+		if ts.Name == "" || names[ts.Name] {
+			return "", errors.Errorf("every task needs a unique name, got '%s'", ts.Name)
+		}
+
+		if ts.PoolID == "" || ts.Size < 1 {
+			return "", errors.Errorf("task '%s' needs a pool and a size of at least 1", ts.Name)
+		}
+
+		names[ts.Name] = true
+		task, err := model.NewTask(ts.PoolID, ts.Size, spec.Submitter)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to create task")
+		}
+
+		task.WorkflowID = workflowID
+		if spec.Priority != "" {
+			task.Priority = spec.Priority
+		}
+
+		taskQuotas, err := e.quotas(ctx, ts.PoolID, spec.Submitter)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to get quotas")
+		}
+
+		if err = checkQuotaSize(taskQuotas, ts.Size); err != nil {
+			return "", errors.Wrapf(err, "task '%s' doesn't fit", ts.Name)
+		}
+
+		if len(ts.DependsOn) > 0 {
+			task.State = model.TaskStateWaiting
+		} else {
+			for _, quota := range taskQuotas {
+				quotas[quota.QuotaPK] = quota
+				queued[quota.QuotaPK]++
+			}
+
+			roots = append(roots, task)
+		}
+
+		tasks = append(tasks, task)
+		wf.Tasks = append(wf.Tasks, &model.WorkflowTask{Name: ts.Name, TaskID: task.TaskID, DependsOn: ts.DependsOn})
+	}
+
+	if _, err = wf.Sorted(); err != nil {
+		return "", errors.Wrap(err, "invalid dependencies")
+	}
+
+	var items []*model.TxItem
+	for pk, quota := range quotas {
+		item, err := model.TxQueueQuota(quota, queued[pk])
+		if err != nil {
+			return "", errors.Wrap(err, "failed to create quota item")
+		}
+
+		items = append(items, item)
+	}
+
+	if err = model.SubmitWorkflow(ctx, e.db, wf, tasks, items...); err != nil {
+		return "", errors.Wrap(err, "failed to store workflow")
+	}
+
+	for _, task := range roots {
+		msg, err := json.Marshal(taskScheduleMsg(task))
+		if err != nil {
+			return "", errors.Wrap(err, "failed to marshal schedule message")
+		}
+
+		if err = SendScheduleMessage(ctx, e.q, task.Priority, task.Submitter, task.TaskID, string(msg)); err != nil {
+			return "", errors.Wrap(err, "failed to send schedule message")
+		}
+	}
+
+	e.logs.With(Fields{FieldWorkflowID: workflowID}).Printf("[INFO] Submitted workflow of %d tasks, %d of which were scheduled right away", len(tasks), len(roots))
+	return workflowID, nil
+}
+
+//WorkflowStatus returns the workflow and its tasks by name
+func (e *Engine) WorkflowStatus(ctx context.Context, workflowID string) (*model.Workflow, map[string]*model.Task, error) {
+	wf, err := model.GetWorkflow(ctx, e.db, model.WorkflowPK{WorkflowID: workflowID})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get workflow")
+	}
+
+	tasks := map[string]*model.Task{}
+	for _, wt := range wf.Tasks {
+		task, err := model.GetTask(ctx, e.db, model.TaskPK{TaskID: wt.TaskID})
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to get task '%s'", wt.Name)
+		}
+
+		tasks[wt.Name] = task
+	}
+
+	return wf, tasks, nil
+}
+
+//AdvanceWorkflows reads the next page of running workflows and releases the
+//tasks whose upstream tasks succeeded. Workflows are advanced when one of
+//their tasks completes, this catches up on the rest: e.g. tasks that waited
+//for quota or were cancelled. Pages are read from a cursor so that every
+//running workflow is visited in turn.
+func (e *Engine) AdvanceWorkflows(ctx context.Context) (err error) {
+	wfs, err := model.RunningWorkflows(ctx, e.db, e.wfCursor, MaxAdvancedWorkflows)
+	if err != nil {
+		return errors.Wrap(err, "failed to query running workflows")
+	}
+
+	e.logs.Printf("[INFO] found %d running workflows", len(wfs))
+	for _, wf := range wfs {
+		if err = e.advanceWorkflow(ctx, wf); err != nil {
+			e.logs.With(Fields{FieldWorkflowID: wf.WorkflowID}).Printf("[WARN] Failed to advance workflow '%s': %v", wf.WorkflowPK, err)
+		}
+	}
+
+	e.wfCursor = nextWorkflowCursor(e.wfCursor, wfs, MaxAdvancedWorkflows)
+	return nil
+}
+
+//nextWorkflowCursor returns where the page after the workflows starts. It
+//starts over once a page wasn't full. Workflows created in the same second
+//as the last one are read again, unless the whole page was of that second.
+func nextWorkflowCursor(cursor int64, wfs []*model.Workflow, limit int64) int64 {
+	if int64(len(wfs)) < limit {
+		return 0
+	}
+
+	last := wfs[len(wfs)-1].Created
+	if last <= cursor {
+		return cursor + 1
+	}
+
+	return last
+}
+
+//advanceWorkflowOf advances the workflow that the task of the claim belongs
+//to, it is called once the task completed so that its downstream tasks don't
+//wait for the pump to visit the workflow
+func (e *Engine) advanceWorkflowOf(ctx context.Context, claim *model.Claim) {
+	logs := e.logs.With(Fields{FieldWorkflowID: claim.WorkflowID})
+	wf, err := model.GetWorkflow(ctx, e.db, model.WorkflowPK{WorkflowID: claim.WorkflowID})
+	if err != nil {
+		logs.Printf("[WARN] Failed to get workflow '%s', leaving it to the pump: %v", claim.WorkflowID, err)
+		return
+	}
+
+	if wf.State != model.WorkflowStateRunning {
+		return
+	}
+
+	if err = e.advanceWorkflow(ctx, wf); err != nil {
+		logs.Printf("[WARN] Failed to advance workflow '%s', leaving it to the pump: %v", wf.WorkflowPK, err)
+	}
+}
+
+//advanceWorkflow walks the tasks of the workflow such that upstream tasks are
+//seen first. A waiting task is released once all of its upstream tasks
+//succeeded and failed or skipped once one of them didn't, that is then
+//propagated further downstream in the same walk. The workflow finishes when
+//all of its tasks did.
+func (e *Engine) advanceWorkflow(ctx context.Context, wf *model.Workflow) error {
+	logs := e.logs.With(Fields{FieldWorkflowID: wf.WorkflowID})
+	sorted, err := wf.Sorted()
+	if err != nil {
+		return errors.Wrap(err, "invalid dependencies")
+	}
+
+	_, tasks, err := e.WorkflowStatus(ctx, wf.WorkflowID)
+	if err != nil {
+		return err
+	}
+
+	done, succeeded := true, true
+	for _, wt := range sorted {
+		task := tasks[wt.Name]
+		if task.State != model.TaskStateWaiting {
+			done = done && task.Finished()
+			succeeded = succeeded && (!task.Finished() || task.State == model.TaskStateSucceeded)
+			continue
+		}
+
+		ready, broken := true, false
+		for _, dep := range wt.DependsOn {
+			up := tasks[dep]
+			ready = ready && up.State == model.TaskStateSucceeded
+			broken = broken || (up.Finished() && up.State != model.TaskStateSucceeded)
+		}
+
+		switch {
+		case broken:
+			state := model.TaskStateFailed
+			if wf.OnFailure == model.OnFailureSkip {
+				state = model.TaskStateSkipped
+			}
+
+			if err = model.SkipTask(ctx, e.db, task.TaskPK, state); err != nil {
+				if errors.Cause(err) != model.ErrTaskNotWaiting {
+					return errors.Wrapf(err, "failed to skip task '%s'", wt.Name)
+				}
+
+				done = false //it changed concurrently, e.g. it was cancelled
+				continue
+			}
+
+			logs.With(Fields{FieldTaskID: task.TaskID}).Printf("[INFO] Upstream of task '%s' did not succeed, it was marked as %s", wt.Name, state)
+			task.State, succeeded = state, false
+
+		case ready:
+			done = false
+			if err = e.releaseTask(ctx, task); err != nil {
+				if errors.Cause(err) == model.ErrQuotaExceeded {
+					logs.With(Fields{FieldTaskID: task.TaskID}).Printf("[INFO] Task '%s' waits for quota: %v", wt.Name, err)
+					continue
+				}
+
+				if errors.Cause(err) != model.ErrTaskNotWaiting {
+					return errors.Wrapf(err, "failed to release task '%s'", wt.Name)
+				}
+
+				continue
+			}
+
+			logs.With(Fields{FieldTaskID: task.TaskID}).Printf("[INFO] Upstream of task '%s' succeeded, it was released for scheduling", wt.Name)
+			task.State = model.TaskStatePending
+
+		default:
+			done = false
+		}
+	}
+
+	if !done {
+		return nil
+	}
+
+	state := model.WorkflowStateSucceeded
+	if !succeeded {
+		state = model.WorkflowStateFailed
+	}
+
+	if err = model.FinishWorkflow(ctx, e.db, wf.WorkflowPK, state); err != nil && errors.Cause(err) != model.ErrWorkflowFinished {
+		return errors.Wrap(err, "failed to finish workflow")
+	}
+
+	logs.Printf("[INFO] Workflow '%s' finished as %s", wf.WorkflowPK, state)
+	return nil
+}
+
+//releaseTask marks the waiting task as pending, counts it as queued towards
+//its quotas and records its submission in one transaction. The submission is
+//delivered only after that commits.
+func (e *Engine) releaseTask(ctx context.Context, task *model.Task) error {
+	quotas, err := e.quotas(ctx, task.PoolID, task.Submitter)
+	if err != nil {
+		return errors.Wrap(err, "failed to get quotas")
+	}
+
+	var items []*model.TxItem
+	if task.Quota {
+		for _, quota := range quotas {
+			item, err := model.TxQueueQuota(quota, 1)
+			if err != nil {
+				return errors.Wrap(err, "failed to create quota item")
+			}
+
+			items = append(items, item)
+		}
+	}
+
+	data, err := json.Marshal(taskScheduleMsg(task))
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal schedule message")
+	}
+
+	out, err := model.NewOutbox(FmtTenantScheduleQueueName(task.Priority, task.Submitter), string(data), time.Now().Add(OutboxDeliveryTimeout))
+	if err != nil {
+		return errors.Wrap(err, "failed to create outbox message")
+	}
+
+	out.DedupID = task.TaskID
+
+	if err = model.ReleaseTask(ctx, e.db, task, out, items...); err != nil {
+		return err
+	}
+
+	if derr := e.deliver(ctx, out); derr != nil {
+		e.logs.With(Fields{FieldTaskID: task.TaskID}).Printf("[WARN] failed to deliver submission of task '%s', leaving it to the outbox sweep: %v", task.TaskPK, derr)
+	}
+
+	return nil
+}
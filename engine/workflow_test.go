@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/advanderveer/factory/model"
+)
+
+func testWorkflows(created ...int64) (wfs []*model.Workflow) {
+	for _, c := range created {
+		wfs = append(wfs, &model.Workflow{Created: c})
+	}
+
+	return wfs
+}
+
+func TestNextWorkflowCursor(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		cursor int64
+		wfs    []*model.Workflow
+		limit  int64
+		exp    int64
+	}{
+		{"empty page starts over", 100, nil, 3, 0},
+		{"partial page starts over", 100, testWorkflows(100, 120), 3, 0},
+		{"full page continues from the last", 0, testWorkflows(100, 110, 120), 3, 120},
+		{"full page continues from the last second", 100, testWorkflows(100, 120, 120), 3, 120},
+		{"full page of the cursor's second moves on", 120, testWorkflows(120, 120, 120), 3, 121},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if act := nextWorkflowCursor(c.cursor, c.wfs, c.limit); act != c.exp {
+				t.Fatalf("expected cursor %d, got: %d", c.exp, act)
+			}
+		})
+	}
+}
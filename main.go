@@ -23,10 +23,20 @@ func main() {
 		Args:         os.Args[1:],
 		Autocomplete: true,
 		Commands: map[string]cli.CommandFactory{
-			"pump":  command.PumpFactory(),
-			"agent": command.AgentFactory(),
-			"run":   command.RunFactory(),
-			"evict": command.EvictFactory(),
+			"pump":            command.PumpFactory(),
+			"agent":           command.AgentFactory(),
+			"run":             command.RunFactory(),
+			"evict":           command.EvictFactory(),
+			"leader":          command.LeaderFactory(),
+			"reconcile":       command.ReconcileFactory(),
+			"api":             command.APIFactory(),
+			"quota get":       command.QuotaGetFactory(),
+			"quota set":       command.QuotaSetFactory(),
+			"status":          command.StatusFactory(),
+			"cron create":     command.CronCreateFactory(),
+			"cron list":       command.CronListFactory(),
+			"cron delete":     command.CronDeleteFactory(),
+			"workflow status": command.WorkflowStatusFactory(),
 		},
 	}
 
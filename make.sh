@@ -30,6 +30,12 @@ command -v docker >/dev/null 2>&1 || { echo "executable 'goagen' (design-based a
 	goagen app -d github.com/advanderveer/datajoin/backend/api/design --pkg app --out=api
 }
 
+function run_proto { #generate the gRPC service from its protobuf definition
+command -v protoc >/dev/null 2>&1 || { echo "executable 'protoc' (protobuf compiler) must be installed, with protoc-gen-go and protoc-gen-go-grpc: https://grpc.io/docs/languages/go/quickstart/" >&2; exit 1; }
+
+	go generate ./rpc
+}
+
 function run_build { #compile the lambda function(s)
 command -v docker >/dev/null 2>&1 || { echo "executable 'docker' (container runtime client) must be installed: https://www.docker.com/" >&2; exit 1; }
 	P=github.com/advanderveer/datajoin
@@ -37,7 +43,7 @@ command -v docker >/dev/null 2>&1 || { echo "executable 'docker' (container runt
   echo "--> building (CLI)..."
   go build \
     -ldflags "-X main.version=$(cat VERSION) -X main.commit=$(git rev-parse --short HEAD )" \
-    -o $GOPATH/bin/factory \
+    -o $(go env GOPATH)/bin/factory \
     main.go
 
 	# echo "--> building..."
@@ -112,6 +118,7 @@ function run_destroy { #remove the stack through cloudformation
 case $1 in
 	"run") run_run ;;
 	"gen") run_gen ;;
+	"proto") run_proto ;;
   "build") run_build ;;
 	"deploy") run_deploy ;;
   "destroy") run_destroy ;;
@@ -29,6 +29,21 @@ var (
 
 	//ErrClaimNotExists is thrown when a claim was expected to exist
 	ErrClaimNotExists = errors.New("claim does not exist")
+
+	//ErrClaimNotDispatched is thrown when a claim that was to be started no
+	//longer exists, is on another node or was started before
+	ErrClaimNotDispatched = errors.New("claim does not exist, is on another node or was already started")
+
+	//ErrClaimNotStarted is thrown when a claim was expected to have been started by the node
+	ErrClaimNotStarted = errors.New("claim does not exist, is on another node or was not started")
+)
+
+const (
+	//ClaimStateDispatched means the run message of the claim was send to its node
+	ClaimStateDispatched = "dispatched"
+
+	//ClaimStateStarted means the node started running the claim
+	ClaimStateStarted = "started"
 )
 
 //ClaimPK is the primary key
@@ -48,34 +63,135 @@ type Claim struct {
 	Size      int64  `dynamodbav:"size"`
 	Partition int64  `dynamodbav:"part"`
 	NodeID    string `dynamodbav:"node"`
+	TaskID    string `dynamodbav:"task"`
+	Submitter string `dynamodbav:"submitter"`
+	Priority  string `dynamodbav:"priority,omitempty"`
+	GangID    string `dynamodbav:"gang,omitempty"`
+
+	//WorkflowID is set if the task is part of a workflow, it is advanced
+	//when the task completes
+	WorkflowID string `dynamodbav:"workflow,omitempty"`
+
+	//State is dispatched until the node starts the claim, claims placed
+	//before claims had a state have none and count as dispatched
+	State string `dynamodbav:"state,omitempty"`
+
+	//Quota is set when the claim's capacity counts towards the quotas of its
+	//pool and submitter
+	Quota bool `dynamodbav:"quota,omitempty"`
 }
 
-//CreateClaim will add a claim and set the ttl
-func CreateClaim(ctx context.Context, db DB, poolID, nodeID string, size int64, ttl time.Time) (*Claim, error) {
+//NewClaim creates a claim for a task on the node that is not yet stored
+func NewClaim(task *Task, nodeID string, ttl time.Time) (*Claim, error) {
 	uuid, err := uuid.GenerateUUID()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to generate claim id")
 	}
 
-	claim := &Claim{
+	return &Claim{
 		ClaimPK: ClaimPK{
 			ClaimID: uuid,
 		},
-		PoolID:    poolID,
-		NodeID:    nodeID,
-		Size:      size,
-		TTL:       ttl.Unix(),
-		Partition: rand.Int63n(ClaimScatterPartitions),
+		PoolID:     task.PoolID,
+		NodeID:     nodeID,
+		TaskID:     task.TaskID,
+		Size:       task.Size,
+		Submitter:  task.Submitter,
+		Priority:   task.Priority,
+		GangID:     task.GangID,
+		State:      ClaimStateDispatched,
+		WorkflowID: task.WorkflowID,
+		Quota:      task.Quota,
+		TTL:        ttl.Unix(),
+		Partition:  rand.Int63n(ClaimScatterPartitions),
+	}, nil
+}
+
+//PlaceClaim will reduce the capacity of the claim's node, add the claim,
+//record it on the task and write any extra items in a single transaction,
+//either all succeed or nothing is written
+func PlaceClaim(ctx context.Context, db DB, task *Task, claim *Claim, extra ...*TxItem) (err error) {
+	capItem, err := TxClaimNodeCapacity(NodePK{NodeID: claim.NodeID}, claim.Size)
+	if err != nil {
+		return errors.Wrap(err, "failed to create capacity item")
+	}
+
+	putItem, err := TxPut(ClaimTableName, claim, "attribute_not_exists(id)", TxExpr{}, ErrClaimExists)
+	if err != nil {
+		return errors.Wrap(err, "failed to create claim item")
+	}
+
+	taskItem, err := TxScheduleTask(task, claim)
+	if err != nil {
+		return errors.Wrap(err, "failed to create task item")
 	}
 
-	put := dynamo.NewPut(ClaimTableName, claim)
-	put.SetConditionExpression("attribute_not_exists(id)")
-	put.SetConditionError(ErrClaimExists)
-	if err = put.ExecuteWithContext(ctx, db); err != nil {
-		return nil, errors.Wrap(err, "failed to put claim item")
+	items := append([]*TxItem{capItem, putItem, taskItem}, extra...)
+	if err = TransactWrite(ctx, db, items...); err != nil {
+		return errors.Wrap(err, "failed to place claim")
 	}
 
-	return claim, nil
+	return nil
+}
+
+//ReleaseClaim will delete the claim, return its capacity to the node (if
+//any), store the outbox message (if any) and write any extra items in a
+//single transaction. With an outbox message the task is resubmitted, it is
+//then put back to pending which fails with ErrTaskNotScheduled if the task
+//is no longer scheduled with the claim.
+func ReleaseClaim(ctx context.Context, db DB, claim *Claim, node *Node, out *Outbox, extra ...*TxItem) (err error) {
+	delItem, err := TxDelete(ClaimTableName, claim.ClaimPK, "attribute_exists(id)", TxExpr{}, ErrClaimNotExists)
+	if err != nil {
+		return errors.Wrap(err, "failed to create claim item")
+	}
+
+	items := []*TxItem{delItem}
+	if node != nil {
+		capItem, err := TxReturnNodeCapacity(node, claim.Size)
+		if err != nil {
+			return errors.Wrap(err, "failed to create capacity item")
+		}
+
+		items = append(items, capItem)
+	}
+
+	if out != nil {
+		outItem, err := TxPutOutbox(out)
+		if err != nil {
+			return errors.Wrap(err, "failed to create outbox item")
+		}
+
+		taskItem, err := TxUnscheduleTask(claim)
+		if err != nil {
+			return errors.Wrap(err, "failed to create task item")
+		}
+
+		items = append(items, outItem, taskItem)
+	}
+
+	items = append(items, extra...)
+	if err = TransactWrite(ctx, db, items...); err != nil {
+		return errors.Wrap(err, "failed to release claim")
+	}
+
+	return nil
+}
+
+//GetClaim returns a claim by its primary key
+func GetClaim(ctx context.Context, db DB, pk ClaimPK) (*Claim, error) {
+	q := dynamo.NewQuery(ClaimTableName, "id = :id")
+	q.AddExpressionValue(":id", pk.ClaimID)
+
+	claims := []*Claim{}
+	if _, err := q.ExecuteWithContext(ctx, db, &claims); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	if len(claims) < 1 {
+		return nil, ErrClaimNotExists
+	}
+
+	return claims[0], nil
 }
 
 //NodeClaims queries for all claims on a node
@@ -141,3 +257,42 @@ func IncrementClaimTTL(ctx context.Context, db DB, pk ClaimPK, nodeID string, t
 
 	return nil
 }
+
+//StartClaim marks a claim that was dispatched to the node as started, this
+//succeeds only once so that a run message that is delivered twice doesn't
+//start the claim twice
+func StartClaim(ctx context.Context, db DB, pk ClaimPK, nodeID string) (err error) {
+	upd := dynamo.NewUpdate(ClaimTableName, pk)
+	upd.SetUpdateExpression("SET #state = :started")
+	upd.SetConditionExpression("attribute_exists(id) AND #node = :node AND (attribute_not_exists(#state) OR #state = :dispatched)")
+	upd.AddExpressionName("#state", "state")
+	upd.AddExpressionName("#node", "node")
+	upd.AddExpressionValue(":node", nodeID)
+	upd.AddExpressionValue(":started", ClaimStateStarted)
+	upd.AddExpressionValue(":dispatched", ClaimStateDispatched)
+	upd.SetConditionError(ErrClaimNotDispatched)
+	if err = upd.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to update claim")
+	}
+
+	return nil
+}
+
+//UnstartClaim marks a claim that the node started as dispatched again, e.g.
+//when its run message is to be redelivered because it didn't run after all
+func UnstartClaim(ctx context.Context, db DB, pk ClaimPK, nodeID string) (err error) {
+	upd := dynamo.NewUpdate(ClaimTableName, pk)
+	upd.SetUpdateExpression("SET #state = :dispatched")
+	upd.SetConditionExpression("attribute_exists(id) AND #node = :node AND #state = :started")
+	upd.AddExpressionName("#state", "state")
+	upd.AddExpressionName("#node", "node")
+	upd.AddExpressionValue(":node", nodeID)
+	upd.AddExpressionValue(":started", ClaimStateStarted)
+	upd.AddExpressionValue(":dispatched", ClaimStateDispatched)
+	upd.SetConditionError(ErrClaimNotStarted)
+	if err = upd.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to update claim")
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	dynamo "github.com/advanderveer/go-dynamo"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	//CronTableName sets the name of the cron table
+	CronTableName = "factory-crons"
+
+	//CronNextIdxName sets the name of the index on the next run of crons
+	CronNextIdxName = "next_idx"
+
+	//CronScatterPartitions determines the spread of gsi indexes
+	CronScatterPartitions = int64(10)
+
+	//ErrCronExists is thrown when a cron was expected not to exist
+	ErrCronExists = errors.New("cron already exists")
+
+	//ErrCronNotExists is thrown when a cron was expected to exist
+	ErrCronNotExists = errors.New("cron does not exist")
+
+	//ErrCronAdvanced is thrown when the next run of a cron changed since it was read
+	ErrCronAdvanced = errors.New("cron was advanced or no longer exists")
+)
+
+const (
+	//ConcurrencyAllow submits runs of a cron while earlier runs have not finished
+	ConcurrencyAllow = "allow"
+
+	//ConcurrencyForbid skips runs of a cron while an earlier run has not finished
+	ConcurrencyForbid = "forbid"
+
+	//ConcurrencyReplace cancels the unfinished runs of a cron before submitting a new one
+	ConcurrencyReplace = "replace"
+)
+
+//CronPK is the primary key
+type CronPK struct {
+	CronID string `dynamodbav:"id"`
+}
+
+func (pk CronPK) String() string {
+	return fmt.Sprintf("%s", pk.CronID)
+}
+
+//Cron item records a task that is submitted on a recurring schedule
+type Cron struct {
+	CronPK
+	Schedule    string `dynamodbav:"schedule"`
+	PoolID      string `dynamodbav:"pool"`
+	Size        int64  `dynamodbav:"size"`
+	Priority    string `dynamodbav:"priority,omitempty"`
+	Submitter   string `dynamodbav:"submitter"`
+	Concurrency string `dynamodbav:"concurrency"`
+	Created     int64  `dynamodbav:"created"`
+	Partition   int64  `dynamodbav:"part"`
+
+	//CatchUp is the max number of runs that are submitted at once when
+	//several became due since the cron was last evaluated, others are skipped
+	CatchUp int64 `dynamodbav:"catch_up"`
+
+	//Next is the unix time at which the next run is due
+	Next int64 `dynamodbav:"next"`
+
+	//Active holds the ids of the tasks of runs that may not have finished
+	Active []string `dynamodbav:"active,omitempty"`
+}
+
+//NewCron creates a cron that is not yet stored
+func NewCron(schedule, poolID string, size int64, submitter string, next time.Time) (*Cron, error) {
+	uuid, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate cron id")
+	}
+
+	return &Cron{
+		CronPK: CronPK{
+			CronID: uuid,
+		},
+		Schedule:    schedule,
+		PoolID:      poolID,
+		Size:        size,
+		Priority:    PriorityNormal,
+		Submitter:   submitter,
+		Concurrency: ConcurrencyAllow,
+		Created:     time.Now().Unix(),
+		Partition:   rand.Int63n(CronScatterPartitions),
+		Next:        next.Unix(),
+	}, nil
+}
+
+//PutCron stores a new cron
+func PutCron(ctx context.Context, db DB, c *Cron) (err error) {
+	put := dynamo.NewPut(CronTableName, c)
+	put.SetConditionExpression("attribute_not_exists(id)")
+	put.SetConditionError(ErrCronExists)
+	if err = put.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to put cron item")
+	}
+
+	return nil
+}
+
+//GetCron returns a cron by its primary key
+func GetCron(ctx context.Context, db DB, pk CronPK) (*Cron, error) {
+	q := dynamo.NewQuery(CronTableName, "id = :id")
+	q.AddExpressionValue(":id", pk.CronID)
+
+	crons := []*Cron{}
+	if _, err := q.ExecuteWithContext(ctx, db, &crons); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	if len(crons) < 1 {
+		return nil, ErrCronNotExists
+	}
+
+	return crons[0], nil
+}
+
+//DeleteCron will delete a cron
+func DeleteCron(ctx context.Context, db DB, pk CronPK) (err error) {
+	del := dynamo.NewDelete(CronTableName, pk)
+	del.SetConditionExpression("attribute_exists(id)")
+	del.SetConditionError(ErrCronNotExists)
+	if err = del.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to delete cron item")
+	}
+
+	return nil
+}
+
+//DueCrons queries the next index for crons with a run that is due
+func DueCrons(ctx context.Context, db DB, limit int64) (crons []*Cron, err error) {
+	for i := int64(0); i < CronScatterPartitions; i++ {
+		q := dynamo.NewQuery(CronTableName, "part = :part AND #next <= :now")
+		q.SetIndexName(CronNextIdxName)
+		q.SetLimit(limit)
+		q.AddExpressionValue(":part", i)
+		q.AddExpressionName("#next", "next")
+		q.AddExpressionValue(":now", time.Now().Unix())
+
+		partCrons := []*Cron{}
+		if _, err := q.ExecuteWithContext(ctx, db, &partCrons); err != nil {
+			return nil, errors.Wrapf(err, "failed to query partition %d", i)
+		}
+
+		crons = append(crons, partCrons...)
+	}
+
+	return crons, nil
+}
+
+//ListCrons queries the next index for all crons
+func ListCrons(ctx context.Context, db DB) (crons []*Cron, err error) {
+	for i := int64(0); i < CronScatterPartitions; i++ {
+		q := dynamo.NewQuery(CronTableName, "part = :part AND #next >= :min")
+		q.SetIndexName(CronNextIdxName)
+		q.AddExpressionValue(":part", i)
+		q.AddExpressionName("#next", "next")
+		q.AddExpressionValue(":min", 0)
+
+		partCrons := []*Cron{}
+		if _, err := q.ExecuteWithContext(ctx, db, &partCrons); err != nil {
+			return nil, errors.Wrapf(err, "failed to query partition %d", i)
+		}
+
+		crons = append(crons, partCrons...)
+	}
+
+	return crons, nil
+}
+
+//AdvanceCron moves the next run of the cron forward, it fails if the next
+//run changed since it was read so that runs are only submitted once
+func AdvanceCron(ctx context.Context, db DB, pk CronPK, prev, next int64) (err error) {
+	upd := dynamo.NewUpdate(CronTableName, pk)
+	upd.SetUpdateExpression("SET #next = :next")
+	upd.SetConditionExpression("attribute_exists(id) AND #next = :prev")
+	upd.AddExpressionName("#next", "next")
+	upd.AddExpressionValue(":next", next)
+	upd.AddExpressionValue(":prev", prev)
+	upd.SetConditionError(ErrCronAdvanced)
+	if err = upd.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to update cron")
+	}
+
+	return nil
+}
+
+//SetCronActive records the tasks of the cron's runs that may not have finished
+func SetCronActive(ctx context.Context, db DB, pk CronPK, active []string) (err error) {
+	upd := dynamo.NewUpdate(CronTableName, pk)
+	if len(active) > 0 {
+		upd.SetUpdateExpression("SET active = :active")
+		upd.AddExpressionValue(":active", active)
+	} else {
+		upd.SetUpdateExpression("REMOVE active")
+	}
+
+	upd.SetConditionExpression("attribute_exists(id)")
+	upd.SetConditionError(ErrCronNotExists)
+	if err = upd.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to update cron")
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dynamo "github.com/advanderveer/go-dynamo"
+	"github.com/pkg/errors"
+)
+
+var (
+	//DemandTableName sets the name of the table that records unmet demand
+	DemandTableName = "factory-demand"
+
+	//DemandPoolIdxName sets the name of the index on the pool and expiry of demand
+	DemandPoolIdxName = "pool_idx"
+)
+
+//DemandPK is the primary key, it is the id of the task or gang that could
+//not be placed
+type DemandPK struct {
+	DemandID string `dynamodbav:"id"`
+}
+
+func (pk DemandPK) String() string {
+	return fmt.Sprintf("%s", pk.DemandID)
+}
+
+//Demand item records that a task or gang could not be placed for lack of
+//capacity in its pool. It is recorded again on every failed attempt so it
+//only expires once the task was placed or stopped being scheduled, the
+//table's ttl removes it eventually.
+type Demand struct {
+	DemandPK
+	PoolID  string `dynamodbav:"pool"`
+	Size    int64  `dynamodbav:"size"`
+	Expires int64  `dynamodbav:"expires"`
+}
+
+//PutDemand records the demand of a task or gang in the pool until it expires
+func PutDemand(ctx context.Context, db DB, id, poolID string, size int64, expires time.Time) (err error) {
+	put := dynamo.NewPut(DemandTableName, &Demand{
+		DemandPK: DemandPK{DemandID: id},
+		PoolID:   poolID,
+		Size:     size,
+		Expires:  expires.Unix(),
+	})
+
+	if err = put.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to put demand item")
+	}
+
+	return nil
+}
+
+//PoolDemand queries the demand of a pool that has not yet expired
+func PoolDemand(ctx context.Context, db DB, poolID string, limit int64) (demand []*Demand, err error) {
+	q := dynamo.NewQuery(DemandTableName, "#pool = :pool AND expires > :now")
+	q.SetIndexName(DemandPoolIdxName)
+	q.SetLimit(limit)
+	q.AddExpressionName("#pool", "pool")
+	q.AddExpressionValue(":pool", poolID)
+	q.AddExpressionValue(":now", time.Now().Unix())
+	if _, err = q.ExecuteWithContext(ctx, db, &demand); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	return demand, nil
+}
@@ -0,0 +1,244 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dynamo "github.com/advanderveer/go-dynamo"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	//GangTableName sets the name of the gang table
+	GangTableName = "factory-gangs"
+
+	//ErrGangExists is thrown when a gang was expected not to exist
+	ErrGangExists = errors.New("gang already exists")
+
+	//ErrGangNotExists is thrown when a gang was expected to exist
+	ErrGangNotExists = errors.New("gang does not exist")
+
+	//ErrGangNotPending is thrown when a gang was placed since it was read
+	ErrGangNotPending = errors.New("gang is no longer pending")
+
+	//ErrGangNotScheduled is thrown when a gang is no longer scheduled with the expected placement
+	ErrGangNotScheduled = errors.New("gang is not scheduled with the placement")
+)
+
+//GangPK is the primary key
+type GangPK struct {
+	GangID string `dynamodbav:"id"`
+}
+
+func (pk GangPK) String() string {
+	return fmt.Sprintf("%s", pk.GangID)
+}
+
+//Gang item records tasks that are placed together or not at all. It is
+//pending until claims for all of its unfinished tasks are placed, those
+//claims are recorded as its placement.
+type Gang struct {
+	GangPK
+	PoolID    string   `dynamodbav:"pool"`
+	Size      int64    `dynamodbav:"size"`
+	Tasks     []string `dynamodbav:"tasks"`
+	State     string   `dynamodbav:"state"`
+	Created   int64    `dynamodbav:"created"`
+	Submitter string   `dynamodbav:"submitter"`
+	Priority  string   `dynamodbav:"priority,omitempty"`
+
+	//Placement identifies the claims that were last placed for the gang,
+	//they are released together
+	Placement string   `dynamodbav:"placement,omitempty"`
+	Claims    []string `dynamodbav:"claims,omitempty"`
+}
+
+//NewGang creates a pending gang of n tasks of the size, none are stored yet
+func NewGang(poolID string, size, n int64, submitter string) (*Gang, []*Task, error) {
+	uuid, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate gang id")
+	}
+
+	gang := &Gang{
+		GangPK: GangPK{
+			GangID: uuid,
+		},
+		PoolID:    poolID,
+		Size:      size,
+		State:     TaskStatePending,
+		Created:   time.Now().Unix(),
+		Submitter: submitter,
+		Priority:  PriorityNormal,
+	}
+
+	tasks := []*Task{}
+	for i := int64(0); i < n; i++ {
+		task, err := NewTask(poolID, size, submitter)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		task.GangID = gang.GangID
+		gang.Tasks = append(gang.Tasks, task.TaskID)
+		tasks = append(tasks, task)
+	}
+
+	return gang, tasks, nil
+}
+
+//SubmitGang stores a new gang with its tasks and counts the tasks as queued
+//towards the quotas in a single transaction
+func SubmitGang(ctx context.Context, db DB, gang *Gang, tasks []*Task, quotas ...*Quota) (err error) {
+	putItem, err := TxPut(GangTableName, gang, "attribute_not_exists(id)", TxExpr{}, ErrGangExists)
+	if err != nil {
+		return errors.Wrap(err, "failed to create gang item")
+	}
+
+	items := []*TxItem{putItem}
+	for _, task := range tasks {
+		taskItem, err := TxPut(TaskTableName, task, "attribute_not_exists(id)", TxExpr{}, ErrTaskExists)
+		if err != nil {
+			return errors.Wrap(err, "failed to create task item")
+		}
+
+		items = append(items, taskItem)
+	}
+
+	for _, quota := range quotas {
+		quotaItem, err := TxQueueQuota(quota, int64(len(tasks)))
+		if err != nil {
+			return errors.Wrap(err, "failed to create quota item")
+		}
+
+		items = append(items, quotaItem)
+	}
+
+	if err = TransactWrite(ctx, db, items...); err != nil {
+		return errors.Wrap(err, "failed to submit gang")
+	}
+
+	return nil
+}
+
+//GetGang returns a gang by its primary key
+func GetGang(ctx context.Context, db DB, pk GangPK) (*Gang, error) {
+	q := dynamo.NewQuery(GangTableName, "id = :id")
+	q.AddExpressionValue(":id", pk.GangID)
+
+	gangs := []*Gang{}
+	if _, err := q.ExecuteWithContext(ctx, db, &gangs); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	if len(gangs) < 1 {
+		return nil, ErrGangNotExists
+	}
+
+	return gangs[0], nil
+}
+
+//PlaceGang reduces the capacity of the claims' nodes, adds the claims,
+//records them on their tasks and as the gang's placement and writes any
+//extra items in a single transaction. Either the whole gang is placed or
+//nothing is written. Claims and tasks are matched by index.
+func PlaceGang(ctx context.Context, db DB, gang *Gang, tasks []*Task, claims []*Claim, placement string, extra ...*TxItem) (err error) {
+	if len(tasks) != len(claims) {
+		return errors.Errorf("got %d claims for %d tasks", len(claims), len(tasks))
+	}
+
+	//a transaction can't write the same node twice, so claims on the same
+	//node take its capacity in one item
+	sizes, nodeIDs := map[string]int64{}, []string{}
+	for _, claim := range claims {
+		if _, ok := sizes[claim.NodeID]; !ok {
+			nodeIDs = append(nodeIDs, claim.NodeID)
+		}
+
+		sizes[claim.NodeID] += claim.Size
+	}
+
+	items := []*TxItem{}
+	for _, nodeID := range nodeIDs {
+		capItem, err := TxClaimNodeCapacity(NodePK{NodeID: nodeID}, sizes[nodeID])
+		if err != nil {
+			return errors.Wrap(err, "failed to create capacity item")
+		}
+
+		items = append(items, capItem)
+	}
+
+	claimIDs := []string{}
+	for i, claim := range claims {
+		putItem, err := TxPut(ClaimTableName, claim, "attribute_not_exists(id)", TxExpr{}, ErrClaimExists)
+		if err != nil {
+			return errors.Wrap(err, "failed to create claim item")
+		}
+
+		taskItem, err := TxScheduleTask(tasks[i], claim)
+		if err != nil {
+			return errors.Wrap(err, "failed to create task item")
+		}
+
+		items = append(items, putItem, taskItem)
+		claimIDs = append(claimIDs, claim.ClaimID)
+	}
+
+	gangItem, err := TxUpdate(GangTableName, gang.GangPK,
+		"SET #state = :scheduled, placement = :placement, claims = :claims",
+		"attribute_exists(id) AND #state = :pending",
+		TxExpr{
+			Names: map[string]string{"#state": "state"},
+			Values: map[string]interface{}{
+				":scheduled": TaskStateScheduled,
+				":pending":   TaskStatePending,
+				":placement": placement,
+				":claims":    claimIDs,
+			},
+		},
+		ErrGangNotPending)
+	if err != nil {
+		return errors.Wrap(err, "failed to create gang item")
+	}
+
+	items = append(append(items, gangItem), extra...)
+	if err = TransactWrite(ctx, db, items...); err != nil {
+		return errors.Wrap(err, "failed to place gang")
+	}
+
+	return nil
+}
+
+//RequeueGang marks the gang as pending again and stores the outbox message
+//that reschedules it in a single transaction. It fails if the gang is no
+//longer scheduled with the placement, e.g. because it was requeued already.
+func RequeueGang(ctx context.Context, db DB, gang *Gang, out *Outbox) (err error) {
+	gangItem, err := TxUpdate(GangTableName, gang.GangPK,
+		"SET #state = :pending REMOVE placement, claims",
+		"attribute_exists(id) AND #state = :scheduled AND placement = :placement",
+		TxExpr{
+			Names: map[string]string{"#state": "state"},
+			Values: map[string]interface{}{
+				":pending":   TaskStatePending,
+				":scheduled": TaskStateScheduled,
+				":placement": gang.Placement,
+			},
+		},
+		ErrGangNotScheduled)
+	if err != nil {
+		return errors.Wrap(err, "failed to create gang item")
+	}
+
+	outItem, err := TxPutOutbox(out)
+	if err != nil {
+		return errors.Wrap(err, "failed to create outbox item")
+	}
+
+	if err = TransactWrite(ctx, db, gangItem, outItem); err != nil {
+		return errors.Wrap(err, "failed to requeue gang")
+	}
+
+	return nil
+}
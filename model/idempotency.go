@@ -0,0 +1,81 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dynamo "github.com/advanderveer/go-dynamo"
+	"github.com/pkg/errors"
+)
+
+var (
+	//IdempotencyTableName sets the name of the table that deduplicates submissions
+	IdempotencyTableName = "factory-idempotency"
+
+	//ErrIdempotencyKeyUsed is thrown when a submission used a key that was used before
+	ErrIdempotencyKeyUsed = errors.New("idempotency key was already used")
+
+	//ErrIdempotencyKeyNotExists is thrown when a key was expected to have been used
+	ErrIdempotencyKeyNotExists = errors.New("idempotency key does not exist or expired")
+)
+
+//IdempotencyPK is the primary key, keys are scoped to the submitter that
+//used them so tenants can't collide
+type IdempotencyPK struct {
+	Key string `dynamodbav:"id"`
+}
+
+//FmtIdempotencyPK returns the primary key of the key used by the submitter.
+//The submitter is prefixed with its length, as both may contain any
+//separator, so that no two submitters can share a primary key.
+func FmtIdempotencyPK(submitter, key string) IdempotencyPK {
+	return IdempotencyPK{Key: fmt.Sprintf("%d:%s/%s", len(submitter), submitter, key)}
+}
+
+func (pk IdempotencyPK) String() string {
+	return fmt.Sprintf("%s", pk.Key)
+}
+
+//Idempotency item records the task that was submitted with a key. The key
+//can be used again once it expired, the table's ttl removes it eventually.
+type Idempotency struct {
+	IdempotencyPK
+	TaskID  string `dynamodbav:"task"`
+	Expires int64  `dynamodbav:"expires"`
+}
+
+//NewIdempotency records that the submitter used the key for the task
+func NewIdempotency(submitter, key, taskID string, expires time.Time) *Idempotency {
+	return &Idempotency{
+		IdempotencyPK: FmtIdempotencyPK(submitter, key),
+		TaskID:        taskID,
+		Expires:       expires.Unix(),
+	}
+}
+
+//TxPutIdempotency creates a transaction item that stores the key, it fails
+//if the key was used before and has not expired
+func TxPutIdempotency(idem *Idempotency) (*TxItem, error) {
+	return TxPut(IdempotencyTableName, idem,
+		"attribute_not_exists(id) OR expires < :now",
+		TxExpr{Values: map[string]interface{}{":now": time.Now().Unix()}},
+		ErrIdempotencyKeyUsed)
+}
+
+//GetIdempotency returns a key that has not expired by its primary key
+func GetIdempotency(ctx context.Context, db DB, pk IdempotencyPK) (*Idempotency, error) {
+	q := dynamo.NewQuery(IdempotencyTableName, "id = :id")
+	q.AddExpressionValue(":id", pk.Key)
+
+	idems := []*Idempotency{}
+	if _, err := q.ExecuteWithContext(ctx, db, &idems); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	if len(idems) < 1 || idems[0].Expires < time.Now().Unix() {
+		return nil, ErrIdempotencyKeyNotExists
+	}
+
+	return idems[0], nil
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestFmtIdempotencyPK(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		a, b  [2]string
+		equal bool
+	}{
+		{"same submitter and key", [2]string{"a", "k"}, [2]string{"a", "k"}, true},
+		{"other key", [2]string{"a", "k"}, [2]string{"a", "l"}, false},
+		{"other submitter", [2]string{"a", "k"}, [2]string{"b", "k"}, false},
+		{"separator moved into the submitter", [2]string{"a", "b/c"}, [2]string{"a/b", "c"}, false},
+		{"length prefix moved into the submitter", [2]string{"3:a", "b"}, [2]string{"1", "3:a/b"}, false},
+		{"empty submitter", [2]string{"", "a/k"}, [2]string{"a", "k"}, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			pka, pkb := FmtIdempotencyPK(c.a[0], c.a[1]), FmtIdempotencyPK(c.b[0], c.b[1])
+			if (pka == pkb) != c.equal {
+				t.Fatalf("expected keys '%s' and '%s' to be equal: %v", pka, pkb, c.equal)
+			}
+		})
+	}
+}
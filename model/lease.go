@@ -0,0 +1,94 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dynamo "github.com/advanderveer/go-dynamo"
+	"github.com/pkg/errors"
+)
+
+var (
+	//LeaseTableName sets the name of the lease table
+	LeaseTableName = "factory-leases"
+
+	//ErrLeaseHeld is thrown when the lease is held by another holder
+	ErrLeaseHeld = errors.New("lease is held by another holder")
+
+	//ErrLeaseNotExists is thrown when a lease was expected to exist
+	ErrLeaseNotExists = errors.New("lease does not exist")
+)
+
+//LeasePK is the primary key
+type LeasePK struct {
+	LeaseID string `dynamodbav:"id"`
+}
+
+func (pk LeasePK) String() string {
+	return fmt.Sprintf("%s", pk.LeaseID)
+}
+
+//Lease item
+type Lease struct {
+	LeasePK
+	Holder string `dynamodbav:"holder"`
+	TTL    int64  `dynamodbav:"ttl"`
+}
+
+//Expired returns whether the lease is no longer held by anyone
+func (l *Lease) Expired() bool {
+	return l.TTL < time.Now().Unix()
+}
+
+//AcquireLease will take the lease if it is free or expired, or renew it if already held by the holder
+func AcquireLease(ctx context.Context, db DB, pk LeasePK, holder string, ttl time.Time) (err error) {
+	upd := dynamo.NewUpdate(LeaseTableName, pk)
+	upd.SetUpdateExpression("SET #holder = :holder, #ttl = :ttl")
+	upd.SetConditionExpression("attribute_not_exists(id) OR #holder = :holder OR #ttl < :now")
+	upd.AddExpressionName("#holder", "holder")
+	upd.AddExpressionName("#ttl", "ttl")
+	upd.AddExpressionValue(":holder", holder)
+	upd.AddExpressionValue(":ttl", ttl.Unix())
+	upd.AddExpressionValue(":now", time.Now().Unix())
+	upd.SetConditionError(ErrLeaseHeld)
+	if err = upd.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to update lease")
+	}
+
+	return nil
+}
+
+//ReleaseLease will expire the lease immediately if it is still held by the holder
+func ReleaseLease(ctx context.Context, db DB, pk LeasePK, holder string) (err error) {
+	upd := dynamo.NewUpdate(LeaseTableName, pk)
+	upd.SetUpdateExpression("SET #ttl = :ttl")
+	upd.SetConditionExpression("attribute_exists(id) AND #holder = :holder")
+	upd.AddExpressionName("#holder", "holder")
+	upd.AddExpressionName("#ttl", "ttl")
+	upd.AddExpressionValue(":holder", holder)
+	upd.AddExpressionValue(":ttl", 0)
+	upd.SetConditionError(ErrLeaseHeld)
+	if err = upd.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to update lease")
+	}
+
+	return nil
+}
+
+//GetLease returns the current state of a lease
+func GetLease(ctx context.Context, db DB, pk LeasePK) (*Lease, error) {
+	q := dynamo.NewQuery(LeaseTableName, "id = :id")
+	q.AddExpressionValue(":id", pk.LeaseID)
+
+	leases := []*Lease{}
+	if _, err := q.ExecuteWithContext(ctx, db, &leases); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	if len(leases) < 1 {
+		return nil, ErrLeaseNotExists
+	}
+
+	return leases[0], nil
+}
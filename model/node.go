@@ -30,11 +30,23 @@ var (
 	//ErrNodeNotExists is thrown when a node was expected to exist
 	ErrNodeNotExists = errors.New("node does not exist")
 
-	//ErrNodeCapacityUnfit means the node capacity is too low or it unregistered
-	ErrNodeCapacityUnfit = errors.New("node capacity low or node no longer exist")
+	//ErrNodeCapacityUnfit means the node capacity is too low, it is draining or it unregistered
+	ErrNodeCapacityUnfit = errors.New("node capacity low, node draining or node no longer exist")
 
 	//ErrNodeReturnUnfit means the node capacity is too low or it unregistered
 	ErrNodeReturnUnfit = errors.New("node capacity high or node no longer exist")
+
+	//ErrNodeHeld means the node record is still in use by another host
+	ErrNodeHeld = errors.New("node is held by another host or no longer exist")
+
+	//ErrNodeCapacityChanged means the node capacity changed since it was read
+	ErrNodeCapacityChanged = errors.New("node capacity changed or node no longer exist")
+
+	//ErrNodeNotEmpty means the node has claims or no longer exist
+	ErrNodeNotEmpty = errors.New("node has claims or no longer exist")
+
+	//ErrNodeNotScaledIn means the node was not drained to be removed by the autoscaler or no longer exist
+	ErrNodeNotScaledIn = errors.New("node is not being scaled in or no longer exist")
 )
 
 //NodePK is the primary key
@@ -54,24 +66,33 @@ type Node struct {
 	Cap       int64  `dynamodbav:"cap"`
 	Max       int64  `dynamodbav:"max"`
 	Partition int64  `dynamodbav:"part"`
+	Host      string `dynamodbav:"host"`
+	Drain     bool   `dynamodbav:"drain,omitempty"`
+
+	//ScaleIn is set when the autoscaler drained the node to remove it, it is
+	//cleared once the removal was handed to the provider
+	ScaleIn bool `dynamodbav:"scale_in,omitempty"`
 }
 
-//RegisterNode will add a node and set the ttl
-func RegisterNode(ctx context.Context, db DB, poolID string, ttl time.Time) (*Node, error) {
-	uuid, err := uuid.GenerateUUID()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to generate node id")
+//RegisterNode will add a node and set the ttl, a node id is generated if none is given
+func RegisterNode(ctx context.Context, db DB, nodeID, poolID, host string, ttl time.Time) (node *Node, err error) {
+	if nodeID == "" {
+		nodeID, err = uuid.GenerateUUID()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate node id")
+		}
 	}
 
-	node := &Node{
+	node = &Node{
 		NodePK: NodePK{
-			NodeID: uuid,
+			NodeID: nodeID,
 		},
 		PoolID:    poolID,
 		Cap:       10,
 		Max:       10,
 		TTL:       ttl.Unix(),
 		Partition: rand.Int63n(NodeScatterPartitions),
+		Host:      host,
 	}
 
 	put := dynamo.NewPut(NodeTableName, node)
@@ -84,6 +105,32 @@ func RegisterNode(ctx context.Context, db DB, poolID string, ttl time.Time) (*No
 	return node, nil
 }
 
+//ReclaimNode takes back an existing node record of the pool if it expired or
+//belongs to the same host, and refreshes its ttl
+func ReclaimNode(ctx context.Context, db DB, pk NodePK, poolID, host string, ttl time.Time) (*Node, error) {
+	upd := dynamo.NewUpdate(NodeTableName, pk)
+	upd.SetUpdateExpression("SET #ttl = :ttl, #host = :host")
+	upd.SetConditionExpression("attribute_exists(id) AND #pool = :pool AND (#ttl < :now OR #host = :host)")
+	upd.AddExpressionName("#ttl", "ttl")
+	upd.AddExpressionValue(":ttl", ttl.Unix())
+	upd.AddExpressionValue(":now", time.Now().Unix())
+	upd.AddExpressionName("#pool", "pool")
+	upd.AddExpressionValue(":pool", poolID)
+	upd.AddExpressionName("#host", "host")
+	upd.AddExpressionValue(":host", host)
+	upd.SetConditionError(ErrNodeHeld)
+	if err := upd.ExecuteWithContext(ctx, db); err != nil {
+		return nil, errors.Wrap(err, "failed to update node")
+	}
+
+	node, err := GetNode(ctx, db, pk)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get node")
+	}
+
+	return node, nil
+}
+
 //DeregisterNode will remove a node
 func DeregisterNode(ctx context.Context, db DB, pk NodePK) (err error) {
 	del := dynamo.NewDelete(NodeTableName, pk)
@@ -96,6 +143,24 @@ func DeregisterNode(ctx context.Context, db DB, pk NodePK) (err error) {
 	return nil
 }
 
+//DeregisterExpiredNode will remove a node whose ttl passed, it fails with
+//ErrNodeHeld if the node was taken back since it was found to be expired
+func DeregisterExpiredNode(ctx context.Context, db DB, pk NodePK) (err error) {
+	delItem, err := TxDelete(NodeTableName, pk, "attribute_exists(id) AND #ttl < :now", TxExpr{
+		Names:  map[string]string{"#ttl": "ttl"},
+		Values: map[string]interface{}{":now": time.Now().Unix()},
+	}, ErrNodeHeld)
+	if err != nil {
+		return errors.Wrap(err, "failed to create node item")
+	}
+
+	if err = TransactWrite(ctx, db, delItem); err != nil {
+		return errors.Wrap(err, "failed to delete node item")
+	}
+
+	return nil
+}
+
 //NodesWithEnoughCapacity will return nodes that have enough cap
 func NodesWithEnoughCapacity(ctx context.Context, db DB, poolID string, size int64, limit int64) (nodes []*Node, err error) {
 	q := dynamo.NewQuery(NodeTableName, "#pool = :pool AND cap >= :size")
@@ -111,13 +176,34 @@ func NodesWithEnoughCapacity(ctx context.Context, db DB, poolID string, size int
 	return nodes, nil
 }
 
-//ClaimNodeCapacity will atomically reduce the nodes capacity
-func ClaimNodeCapacity(ctx context.Context, db DB, pk NodePK, size int64) (err error) {
+//TxClaimNodeCapacity creates a transaction item that reduces the nodes
+//capacity, draining nodes don't take new claims
+func TxClaimNodeCapacity(pk NodePK, size int64) (*TxItem, error) {
+	return TxUpdate(NodeTableName, pk,
+		"SET cap = cap - :size",
+		"attribute_exists(id) AND cap >= :size AND attribute_not_exists(drain)",
+		TxExpr{Values: map[string]interface{}{":size": size}},
+		ErrNodeCapacityUnfit)
+}
+
+//TxReturnNodeCapacity creates a transaction item that returns capacity back
+//to the node, it fails if that would raise the capacity above the node's max
+func TxReturnNodeCapacity(node *Node, size int64) (*TxItem, error) {
+	return TxUpdate(NodeTableName, node.NodePK,
+		"SET cap = cap + :size",
+		"attribute_exists(id) AND cap <= :limit",
+		TxExpr{Values: map[string]interface{}{":size": size, ":limit": node.Max - size}},
+		ErrNodeReturnUnfit)
+}
+
+//RepairNodeCapacity sets the node capacity if it didn't change since it was read
+func RepairNodeCapacity(ctx context.Context, db DB, pk NodePK, from, to int64) (err error) {
 	upd := dynamo.NewUpdate(NodeTableName, pk)
-	upd.SetUpdateExpression("SET cap = cap - :size")
-	upd.SetConditionExpression("attribute_exists(id) AND cap >= :size")
-	upd.SetConditionError(ErrNodeCapacityUnfit)
-	upd.AddExpressionValue(":size", size)
+	upd.SetUpdateExpression("SET cap = :to")
+	upd.SetConditionExpression("attribute_exists(id) AND cap = :from")
+	upd.SetConditionError(ErrNodeCapacityChanged)
+	upd.AddExpressionValue(":from", from)
+	upd.AddExpressionValue(":to", to)
 	if err = upd.ExecuteWithContext(ctx, db); err != nil {
 		return errors.Wrap(err, "failed to update node")
 	}
@@ -125,14 +211,44 @@ func ClaimNodeCapacity(ctx context.Context, db DB, pk NodePK, size int64) (err e
 	return nil
 }
 
-//ReturnNodeCapacity returns capacity backe to the node
-func ReturnNodeCapacity(ctx context.Context, db DB, pk NodePK, size int64) (err error) {
+//DrainNode marks the node so that it no longer takes new claims
+func DrainNode(ctx context.Context, db DB, pk NodePK) (err error) {
 	upd := dynamo.NewUpdate(NodeTableName, pk)
-	upd.SetUpdateExpression("SET cap = cap + :size")
-	upd.SetConditionExpression("attribute_exists(id) AND cap < #max")
-	upd.SetConditionError(ErrNodeReturnUnfit)
+	upd.SetUpdateExpression("SET drain = :drain")
+	upd.SetConditionExpression("attribute_exists(id)")
+	upd.AddExpressionValue(":drain", true)
+	upd.SetConditionError(ErrNodeNotExists)
+	if err = upd.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to update node")
+	}
+
+	return nil
+}
+
+//DrainNodeForScaleIn drains the node and marks it to be removed by the
+//autoscaler, it fails if the node has claims
+func DrainNodeForScaleIn(ctx context.Context, db DB, pk NodePK) (err error) {
+	upd := dynamo.NewUpdate(NodeTableName, pk)
+	upd.SetUpdateExpression("SET drain = :true, scale_in = :true")
+	upd.SetConditionExpression("attribute_exists(id) AND cap = #max")
 	upd.AddExpressionName("#max", "max")
-	upd.AddExpressionValue(":size", size)
+	upd.AddExpressionValue(":true", true)
+	upd.SetConditionError(ErrNodeNotEmpty)
+	if err = upd.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to update node")
+	}
+
+	return nil
+}
+
+//TakeScaleIn clears the mark of a node that the autoscaler drained, so that
+//its removal is handed to the provider only once
+func TakeScaleIn(ctx context.Context, db DB, pk NodePK) (err error) {
+	upd := dynamo.NewUpdate(NodeTableName, pk)
+	upd.SetUpdateExpression("REMOVE scale_in")
+	upd.SetConditionExpression("scale_in = :true")
+	upd.AddExpressionValue(":true", true)
+	upd.SetConditionError(ErrNodeNotScaledIn)
 	if err = upd.ExecuteWithContext(ctx, db); err != nil {
 		return errors.Wrap(err, "failed to update node")
 	}
@@ -140,6 +256,23 @@ func ReturnNodeCapacity(ctx context.Context, db DB, pk NodePK, size int64) (err
 	return nil
 }
 
+//GetNode returns a node by its primary key
+func GetNode(ctx context.Context, db DB, pk NodePK) (*Node, error) {
+	q := dynamo.NewQuery(NodeTableName, "id = :id")
+	q.AddExpressionValue(":id", pk.NodeID)
+
+	nodes := []*Node{}
+	if _, err := q.ExecuteWithContext(ctx, db, &nodes); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	if len(nodes) < 1 {
+		return nil, ErrNodeNotExists
+	}
+
+	return nodes[0], nil
+}
+
 //IncrementNodeTTL will lenghten the ttl of the node
 func IncrementNodeTTL(ctx context.Context, db DB, pk NodePK, t time.Duration) (err error) {
 	upd := dynamo.NewUpdate(NodeTableName, pk)
@@ -176,3 +309,23 @@ func ExpiredNodes(ctx context.Context, db DB, limit int64) (nodes []*Node, err e
 
 	return nodes, nil
 }
+
+//ListNodes queries the ttl index for all nodes
+func ListNodes(ctx context.Context, db DB) (nodes []*Node, err error) {
+	for i := int64(0); i < NodeScatterPartitions; i++ {
+		q := dynamo.NewQuery(NodeTableName, "part = :part AND #ttl >= :minttl")
+		q.SetIndexName(NodeTTLIdxName)
+		q.AddExpressionValue(":part", i)
+		q.AddExpressionName("#ttl", "ttl")
+		q.AddExpressionValue(":minttl", 0)
+
+		partNodes := []*Node{}
+		if _, err := q.ExecuteWithContext(ctx, db, &partNodes); err != nil {
+			return nil, errors.Wrapf(err, "failed to query partition %d", i)
+		}
+
+		nodes = append(nodes, partNodes...)
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	dynamo "github.com/advanderveer/go-dynamo"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	//OutboxTableName sets the name of the outbox table
+	OutboxTableName = "factory-outbox"
+
+	//OutboxTTLIdxName sets the name of ttl index
+	OutboxTTLIdxName = "ttl_idx"
+
+	//OutboxScatterPartitions determines the spread of gsi indexes
+	OutboxScatterPartitions = int64(10)
+
+	//ErrOutboxExists is thrown when an outbox message was expected not to exist
+	ErrOutboxExists = errors.New("outbox message already exists")
+
+	//ErrOutboxNotExists is thrown when an outbox message was expected to exist
+	ErrOutboxNotExists = errors.New("outbox message does not exist")
+)
+
+//OutboxPK is the primary key
+type OutboxPK struct {
+	OutboxID string `dynamodbav:"id"`
+}
+
+func (pk OutboxPK) String() string {
+	return fmt.Sprintf("%s", pk.OutboxID)
+}
+
+//Outbox item holds a message that is to be send once the transaction that
+//wrote it has been committed. The TTL marks the moment at which the message
+//is considered undelivered and may be picked up by a sweep. Messages without
+//a queue were stored before there were several and go to the default one.
+type Outbox struct {
+	OutboxPK
+	Queue     string `dynamodbav:"queue,omitempty"`
+	Body      string `dynamodbav:"body"`
+	TTL       int64  `dynamodbav:"ttl"`
+	Partition int64  `dynamodbav:"part"`
+
+	//Due is the unix time before which the message is not to become visible
+	//in the queue, it is zero for messages that are visible right away
+	Due int64 `dynamodbav:"due,omitempty"`
+
+	//DedupID deduplicates the message when it is send to a FIFO queue, e.g:
+	//the id of the task it submits. Messages without one use their own id.
+	DedupID string `dynamodbav:"dedup,omitempty"`
+}
+
+//DeduplicationID returns the id that deduplicates the message in a FIFO queue
+func (out *Outbox) DeduplicationID() string {
+	if out.DedupID != "" {
+		return out.DedupID
+	}
+
+	return out.OutboxID
+}
+
+//NewOutbox creates an outbox message for the named queue that is not yet stored
+func NewOutbox(queue, body string, ttl time.Time) (*Outbox, error) {
+	uuid, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate outbox id")
+	}
+
+	return &Outbox{
+		OutboxPK: OutboxPK{
+			OutboxID: uuid,
+		},
+		Queue:     queue,
+		Body:      body,
+		TTL:       ttl.Unix(),
+		Partition: rand.Int63n(OutboxScatterPartitions),
+	}, nil
+}
+
+//TxPutOutbox creates a transaction item that stores the outbox message
+func TxPutOutbox(out *Outbox) (*TxItem, error) {
+	return TxPut(OutboxTableName, out, "attribute_not_exists(id)", TxExpr{}, ErrOutboxExists)
+}
+
+//DeleteOutbox will delete an outbox message after it was delivered
+func DeleteOutbox(ctx context.Context, db DB, pk OutboxPK) (err error) {
+	del := dynamo.NewDelete(OutboxTableName, pk)
+	del.SetConditionExpression("attribute_exists(id)")
+	del.SetConditionError(ErrOutboxNotExists)
+	if err = del.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to delete outbox item")
+	}
+
+	return nil
+}
+
+//UndeliveredOutbox queries the ttl index for outbox messages that were not delivered in time
+func UndeliveredOutbox(ctx context.Context, db DB, limit int64) (outs []*Outbox, err error) {
+	for i := int64(0); i < OutboxScatterPartitions; i++ {
+		q := dynamo.NewQuery(OutboxTableName, "part = :part AND #ttl BETWEEN :minttl AND :maxttl")
+		q.SetIndexName(OutboxTTLIdxName)
+		q.SetLimit(limit)
+		q.AddExpressionValue(":part", i)
+		q.AddExpressionName("#ttl", "ttl")
+		q.AddExpressionValue(":minttl", 1)
+		q.AddExpressionValue(":maxttl", time.Now().Unix())
+
+		partOuts := []*Outbox{}
+		if _, err := q.ExecuteWithContext(ctx, db, &partOuts); err != nil {
+			return nil, errors.Wrapf(err, "failed to query partition %d", i)
+		}
+
+		outs = append(outs, partOuts...)
+	}
+
+	return outs, nil
+}
@@ -0,0 +1,197 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	dynamo "github.com/advanderveer/go-dynamo"
+	"github.com/pkg/errors"
+)
+
+var (
+	//QuotaTableName sets the name of the quota table
+	QuotaTableName = "factory-quotas"
+
+	//QuotaKindIdxName indexes quotas based on their kind
+	QuotaKindIdxName = "kind_idx"
+
+	//ErrQuotaNotExists is thrown when a quota was expected to exist
+	ErrQuotaNotExists = errors.New("quota does not exist")
+
+	//ErrQuotaExceeded is thrown when work doesn't fit in a quota
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+const (
+	//QuotaKindPool is the kind of quota that limits a pool
+	QuotaKindPool = "pool"
+
+	//QuotaKindTenant is the kind of quota that limits the tasks of a
+	//submitter, across all pools
+	QuotaKindTenant = "tenant"
+)
+
+//QuotaPK is the primary key, it consists of the kind of quota and the pool
+//or tenant it limits, e.g: "pool/my-pool"
+type QuotaPK struct {
+	QuotaID string `dynamodbav:"id"`
+}
+
+func (pk QuotaPK) String() string {
+	return fmt.Sprintf("%s", pk.QuotaID)
+}
+
+//PoolQuotaPK returns the primary key of a pool's quota
+func PoolQuotaPK(poolID string) QuotaPK {
+	return QuotaPK{QuotaID: QuotaKindPool + "/" + poolID}
+}
+
+//TenantQuotaPK returns the primary key of a tenant's quota
+func TenantQuotaPK(tenant string) QuotaPK {
+	return QuotaPK{QuotaID: QuotaKindTenant + "/" + tenant}
+}
+
+//Kind returns the kind of quota, e.g: "pool"
+func (pk QuotaPK) Kind() string {
+	return strings.SplitN(pk.QuotaID, "/", 2)[0]
+}
+
+//Name returns the pool or tenant that the quota limits
+func (pk QuotaPK) Name() string {
+	parts := strings.SplitN(pk.QuotaID, "/", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+
+	return parts[1]
+}
+
+//QuotaLimits are the limits of a quota, a limit of zero means unlimited
+type QuotaLimits struct {
+	MaxCapacity int64 `dynamodbav:"max_cap"`
+	MaxQueued   int64 `dynamodbav:"max_queued"`
+	MaxSize     int64 `dynamodbav:"max_size"`
+
+	//Weight determines a tenant's share of the capacity while tenants
+	//compete for it, zero counts as a weight of 1
+	Weight int64 `dynamodbav:"weight"`
+}
+
+//ShareWeight returns the weight of the tenant's share, at least 1
+func (l QuotaLimits) ShareWeight() int64 {
+	if l.Weight < 1 {
+		return 1
+	}
+
+	return l.Weight
+}
+
+//Quota item holds the limits of a pool or tenant and the usage that counts
+//towards them. Usage is counted for every pool and tenant, the item is
+//created by the first task that counts. Every write sets the "kind"
+//attribute, by which the quotas are indexed.
+type Quota struct {
+	QuotaPK
+	QuotaLimits
+
+	//Used is the capacity of the claims that count towards the quota
+	Used int64 `dynamodbav:"used"`
+
+	//Queued is the number of tasks that wait for their first placement
+	Queued int64 `dynamodbav:"queued"`
+}
+
+//GetQuota returns a quota by its primary key
+func GetQuota(ctx context.Context, db DB, pk QuotaPK) (*Quota, error) {
+	q := dynamo.NewQuery(QuotaTableName, "id = :id")
+	q.AddExpressionValue(":id", pk.QuotaID)
+
+	quotas := []*Quota{}
+	if _, err := q.ExecuteWithContext(ctx, db, &quotas); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	if len(quotas) < 1 {
+		return nil, ErrQuotaNotExists
+	}
+
+	return quotas[0], nil
+}
+
+//ListQuotas queries the kind index for all quotas of the kind
+func ListQuotas(ctx context.Context, db DB, kind string) (quotas []*Quota, err error) {
+	q := dynamo.NewQuery(QuotaTableName, "#kind = :kind")
+	q.SetIndexName(QuotaKindIdxName)
+	q.AddExpressionName("#kind", "kind")
+	q.AddExpressionValue(":kind", kind)
+	if _, err = q.ExecuteWithContext(ctx, db, &quotas); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	return quotas, nil
+}
+
+//SetQuota sets the limits of a quota, its usage is left as is
+func SetQuota(ctx context.Context, db DB, pk QuotaPK, limits QuotaLimits) (err error) {
+	upd := dynamo.NewUpdate(QuotaTableName, pk)
+	upd.SetUpdateExpression("SET #kind = :kind, max_cap = :max_cap, max_queued = :max_queued, max_size = :max_size, weight = :weight")
+	upd.AddExpressionName("#kind", "kind")
+	upd.AddExpressionValue(":kind", pk.Kind())
+	upd.AddExpressionValue(":max_cap", limits.MaxCapacity)
+	upd.AddExpressionValue(":max_queued", limits.MaxQueued)
+	upd.AddExpressionValue(":max_size", limits.MaxSize)
+	upd.AddExpressionValue(":weight", limits.Weight)
+	if err = upd.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to update quota")
+	}
+
+	return nil
+}
+
+//TxQueueQuota creates a transaction item that counts n submitted tasks
+//towards the quota, it fails if the quota's max queued tasks would be
+//exceeded or its limit changed since it was read
+func TxQueueQuota(quota *Quota, n int64) (*TxItem, error) {
+	cond := "attribute_not_exists(max_queued) OR max_queued = :max"
+	ex := TxExpr{
+		Names:  map[string]string{"#kind": "kind"},
+		Values: map[string]interface{}{":n": n, ":max": quota.MaxQueued, ":kind": quota.Kind()},
+	}
+	if quota.MaxQueued > 0 {
+		cond = "(" + cond + ") AND (attribute_not_exists(queued) OR queued <= :limit)"
+		ex.Values[":limit"] = quota.MaxQueued - n
+	}
+
+	return TxUpdate(QuotaTableName, quota.QuotaPK, "SET #kind = :kind ADD queued :n", cond, ex,
+		errors.Wrapf(ErrQuotaExceeded, "quota '%s' allows at most %d queued tasks", quota.QuotaPK, quota.MaxQueued))
+}
+
+//TxChargeQuota creates a transaction item that counts the capacity of claims
+//towards the quota and no longer counts the dequeued tasks as queued. It
+//fails if the quota's max capacity is reached or its limit changed since it
+//was read.
+func TxChargeQuota(quota *Quota, size, dequeued int64) (*TxItem, error) {
+	cond := "attribute_not_exists(max_cap) OR max_cap = :max"
+	ex := TxExpr{
+		Names:  map[string]string{"#kind": "kind"},
+		Values: map[string]interface{}{":size": size, ":queued": -dequeued, ":max": quota.MaxCapacity, ":kind": quota.Kind()},
+	}
+	if quota.MaxCapacity > 0 {
+		cond = "(" + cond + ") AND (attribute_not_exists(used) OR used <= :limit)"
+		ex.Values[":limit"] = quota.MaxCapacity - size
+	}
+
+	return TxUpdate(QuotaTableName, quota.QuotaPK, "SET #kind = :kind ADD used :size, queued :queued", cond, ex,
+		errors.Wrapf(ErrQuotaExceeded, "quota '%s' allows at most %d capacity in use", quota.QuotaPK, quota.MaxCapacity))
+}
+
+//TxReleaseQuota creates a transaction item that no longer counts the capacity
+//and queued tasks towards the quota
+func TxReleaseQuota(pk QuotaPK, size, queued int64) (*TxItem, error) {
+	return TxUpdate(QuotaTableName, pk,
+		"ADD used :size, queued :queued",
+		"attribute_exists(id)",
+		TxExpr{Values: map[string]interface{}{":size": -size, ":queued": -queued}},
+		ErrQuotaNotExists)
+}
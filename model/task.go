@@ -0,0 +1,333 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dynamo "github.com/advanderveer/go-dynamo"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	//TaskTableName sets the name of the task table
+	TaskTableName = "factory-tasks"
+
+	//TaskPoolIdxName indexes tasks based on the pool they were submitted to
+	TaskPoolIdxName = "pool_idx"
+
+	//ErrTaskExists is thrown when a task was expected not to exist
+	ErrTaskExists = errors.New("task already exists")
+
+	//ErrTaskNotExists is thrown when a task was expected to exist
+	ErrTaskNotExists = errors.New("task does not exist")
+
+	//ErrTaskCancelled is thrown when a task was already cancelled, finished or doesn't exist
+	ErrTaskCancelled = errors.New("task is cancelled, finished or does not exist")
+
+	//ErrTaskNotScheduled is thrown when a task is no longer scheduled with the expected claim
+	ErrTaskNotScheduled = errors.New("task is not scheduled with the claim")
+
+	//ErrTaskNotFinished is thrown when a task was expected to have finished
+	ErrTaskNotFinished = errors.New("task has not finished")
+)
+
+const (
+	//TaskStateWaiting means the task waits for the upstream tasks of its
+	//workflow to succeed before it is scheduled
+	TaskStateWaiting = "waiting"
+
+	//TaskStatePending means the task waits to be placed on a node
+	TaskStatePending = "pending"
+
+	//TaskStateScheduled means a claim was placed for the task
+	TaskStateScheduled = "scheduled"
+
+	//TaskStateCancelled means the task will not be (re)scheduled
+	TaskStateCancelled = "cancelled"
+
+	//TaskStateSucceeded means the task's container exited with code 0
+	TaskStateSucceeded = "succeeded"
+
+	//TaskStateFailed means the task's container exited with another code or
+	//an upstream task of its workflow did not succeed
+	TaskStateFailed = "failed"
+
+	//TaskStateSkipped means an upstream task of its workflow did not succeed
+	//and the workflow skips downstream tasks on failure
+	TaskStateSkipped = "skipped"
+
+	//PriorityLow tasks are scheduled after others
+	PriorityLow = "low"
+
+	//PriorityNormal is the priority of tasks that were submitted without one
+	PriorityNormal = "normal"
+
+	//PriorityHigh tasks are scheduled before others and may preempt the
+	//claims of tasks with a lower priority, see engine.MinPreemptingPriority
+	PriorityHigh = "high"
+)
+
+//Priorities lists the priority classes, from highest to lowest
+var Priorities = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+//ValidPriority returns whether the priority is a known class, empty means normal
+func ValidPriority(priority string) bool {
+	return priority == "" || PriorityLevel(priority) >= 0
+}
+
+//PriorityLevel returns the rank of a priority class, more urgent classes
+//rank higher. It returns -1 for unknown classes.
+func PriorityLevel(priority string) int {
+	if priority == "" {
+		priority = PriorityNormal
+	}
+
+	for i, p := range Priorities {
+		if p == priority {
+			return len(Priorities) - 1 - i
+		}
+	}
+
+	return -1
+}
+
+//TaskPK is the primary key
+type TaskPK struct {
+	TaskID string `dynamodbav:"id"`
+}
+
+func (pk TaskPK) String() string {
+	return fmt.Sprintf("%s", pk.TaskID)
+}
+
+//Task item records a submitted task and the claim that was last placed for it
+type Task struct {
+	TaskPK
+	PoolID    string `dynamodbav:"pool"`
+	Size      int64  `dynamodbav:"size"`
+	State     string `dynamodbav:"state"`
+	NodeID    string `dynamodbav:"node,omitempty"`
+	ClaimID   string `dynamodbav:"claim,omitempty"`
+	Created   int64  `dynamodbav:"created"`
+	Submitter string `dynamodbav:"submitter"`
+	Exit      int64  `dynamodbav:"exit"`
+	Logs      string `dynamodbav:"logs,omitempty"`
+	Priority  string `dynamodbav:"priority,omitempty"`
+	GangID    string `dynamodbav:"gang,omitempty"`
+
+	//WorkflowID is set if the task is part of a workflow
+	WorkflowID string `dynamodbav:"workflow,omitempty"`
+
+	//NotBefore is the unix time before which the task is not scheduled, it
+	//is zero for tasks that were scheduled right away
+	NotBefore int64 `dynamodbav:"not_before,omitempty"`
+
+	//Quota is set when the task counts towards the quotas of its pool and
+	//submitter, tasks submitted before quotas existed don't
+	Quota bool `dynamodbav:"quota,omitempty"`
+}
+
+//Finished returns whether the task reached a state it won't leave
+func (t *Task) Finished() bool {
+	switch t.State {
+	case TaskStateCancelled, TaskStateSucceeded, TaskStateFailed, TaskStateSkipped:
+		return true
+	}
+
+	return false
+}
+
+//NewTask creates a pending task of normal priority that is not yet stored
+func NewTask(poolID string, size int64, submitter string) (*Task, error) {
+	uuid, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate task id")
+	}
+
+	return &Task{
+		TaskPK: TaskPK{
+			TaskID: uuid,
+		},
+		PoolID:    poolID,
+		Size:      size,
+		State:     TaskStatePending,
+		Created:   time.Now().Unix(),
+		Submitter: submitter,
+		Priority:  PriorityNormal,
+		Quota:     true,
+	}, nil
+}
+
+//PutTask stores a new task
+func PutTask(ctx context.Context, db DB, task *Task) (err error) {
+	put := dynamo.NewPut(TaskTableName, task)
+	put.SetConditionExpression("attribute_not_exists(id)")
+	put.SetConditionError(ErrTaskExists)
+	if err = put.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to put task item")
+	}
+
+	return nil
+}
+
+//SubmitTask stores a new task, counts it as queued towards the quotas and
+//writes any extra items in a single transaction
+func SubmitTask(ctx context.Context, db DB, task *Task, quotas []*Quota, extra ...*TxItem) (err error) {
+	putItem, err := TxPut(TaskTableName, task, "attribute_not_exists(id)", TxExpr{}, ErrTaskExists)
+	if err != nil {
+		return errors.Wrap(err, "failed to create task item")
+	}
+
+	items := []*TxItem{putItem}
+	for _, quota := range quotas {
+		quotaItem, err := TxQueueQuota(quota, 1)
+		if err != nil {
+			return errors.Wrap(err, "failed to create quota item")
+		}
+
+		items = append(items, quotaItem)
+	}
+
+	if err = TransactWrite(ctx, db, append(items, extra...)...); err != nil {
+		return errors.Wrap(err, "failed to submit task")
+	}
+
+	return nil
+}
+
+//GetTask returns a task by its primary key
+func GetTask(ctx context.Context, db DB, pk TaskPK) (*Task, error) {
+	q := dynamo.NewQuery(TaskTableName, "id = :id")
+	q.AddExpressionValue(":id", pk.TaskID)
+
+	tasks := []*Task{}
+	if _, err := q.ExecuteWithContext(ctx, db, &tasks); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	if len(tasks) < 1 {
+		return nil, ErrTaskNotExists
+	}
+
+	return tasks[0], nil
+}
+
+//PoolTasks queries for the tasks submitted to a pool, most recent first
+func PoolTasks(ctx context.Context, db DB, poolID string, limit int64) (tasks []*Task, err error) {
+	q := dynamo.NewQuery(TaskTableName, "#pool = :pool")
+	q.SetIndexName(TaskPoolIdxName)
+	q.SetLimit(limit)
+	q.AddExpressionName("#pool", "pool")
+	q.AddExpressionValue(":pool", poolID)
+	if _, err = q.ExecuteWithContext(ctx, db, &tasks); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	//the index sorts ascending on creation, we show the most recent first
+	for i, j := 0, len(tasks)-1; i < j; i, j = i+1, j-1 {
+		tasks[i], tasks[j] = tasks[j], tasks[i]
+	}
+
+	return tasks, nil
+}
+
+//TxScheduleTask creates a transaction item that records the claim placed for
+//the task, it fails if the task's state or claim changed since it was read:
+//e.g. it was cancelled, finished or scheduled concurrently
+func TxScheduleTask(task *Task, claim *Claim) (*TxItem, error) {
+	expr := TxExpr{
+		Names: map[string]string{"#state": "state", "#node": "node", "#claim": "claim"},
+		Values: map[string]interface{}{
+			":scheduled": TaskStateScheduled,
+			":from":      task.State,
+			":node":      claim.NodeID,
+			":claim":     claim.ClaimID,
+		},
+	}
+
+	cond := "attribute_exists(id) AND #state = :from AND attribute_not_exists(#claim)"
+	if task.ClaimID != "" {
+		cond = "attribute_exists(id) AND #state = :from AND #claim = :readClaim"
+		expr.Values[":readClaim"] = task.ClaimID
+	}
+
+	return TxUpdate(TaskTableName, task.TaskPK,
+		"SET #state = :scheduled, #node = :node, #claim = :claim", cond, expr,
+		ErrTaskCancelled)
+}
+
+//TxUnscheduleTask creates a transaction item that puts the task of a claim
+//that is released back to pending, it fails if the task is no longer
+//scheduled with the claim
+func TxUnscheduleTask(claim *Claim) (*TxItem, error) {
+	return TxUpdate(TaskTableName, TaskPK{TaskID: claim.TaskID},
+		"SET #state = :pending REMOVE #node, #claim",
+		"#claim = :claim AND #state = :scheduled",
+		TxExpr{
+			Names: map[string]string{"#state": "state", "#node": "node", "#claim": "claim"},
+			Values: map[string]interface{}{
+				":pending":   TaskStatePending,
+				":claim":     claim.ClaimID,
+				":scheduled": TaskStateScheduled,
+			},
+		},
+		ErrTaskNotScheduled)
+}
+
+//TxCompleteTask creates a transaction item that records the exit code and
+//the tail of the logs of the task's container, it fails if the task is no
+//longer scheduled with the claim
+func TxCompleteTask(claim *Claim, exit int64, logs string) (*TxItem, error) {
+	state := TaskStateSucceeded
+	if exit != 0 {
+		state = TaskStateFailed
+	}
+
+	return TxUpdate(TaskTableName, TaskPK{TaskID: claim.TaskID},
+		"SET #state = :state, #exit = :exit, #logs = :logs",
+		"#claim = :claim AND #state = :scheduled",
+		TxExpr{
+			Names: map[string]string{"#state": "state", "#exit": "exit", "#logs": "logs", "#claim": "claim"},
+			Values: map[string]interface{}{
+				":state":     state,
+				":exit":      exit,
+				":logs":      logs,
+				":claim":     claim.ClaimID,
+				":scheduled": TaskStateScheduled,
+			},
+		},
+		ErrTaskNotScheduled)
+}
+
+//CancelTask marks a task that hasn't finished as cancelled and writes any
+//extra items in a single transaction, once cancelled no further claims are
+//recorded for it. It fails if the task's state changed since it was read.
+func CancelTask(ctx context.Context, db DB, task *Task, extra ...*TxItem) (*Task, error) {
+	updItem, err := TxUpdate(TaskTableName, task.TaskPK,
+		"SET #state = :cancelled",
+		"attribute_exists(id) AND #state = :from AND #state IN (:waiting, :pending, :scheduled)",
+		TxExpr{
+			Names: map[string]string{"#state": "state"},
+			Values: map[string]interface{}{
+				":cancelled": TaskStateCancelled,
+				":from":      task.State,
+				":waiting":   TaskStateWaiting,
+				":pending":   TaskStatePending,
+				":scheduled": TaskStateScheduled,
+			},
+		},
+		ErrTaskCancelled)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create task item")
+	}
+
+	if err = TransactWrite(ctx, db, append([]*TxItem{updItem}, extra...)...); err != nil {
+		return nil, errors.Wrap(err, "failed to cancel task")
+	}
+
+	cancelled := *task
+	cancelled.State = TaskStateCancelled
+	return &cancelled, nil
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	dynamo "github.com/advanderveer/go-dynamo"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	//TimerTableName sets the name of the timer table
+	TimerTableName = "factory-timers"
+
+	//TimerDueIdxName sets the name of the index on the moment timers are due
+	TimerDueIdxName = "due_idx"
+
+	//TimerScatterPartitions determines the spread of gsi indexes
+	TimerScatterPartitions = int64(10)
+
+	//ErrTimerExists is thrown when a timer was expected not to exist
+	ErrTimerExists = errors.New("timer already exists")
+
+	//ErrTimerNotExists is thrown when a timer was expected to exist
+	ErrTimerNotExists = errors.New("timer does not exist")
+)
+
+//TimerPK is the primary key
+type TimerPK struct {
+	TimerID string `dynamodbav:"id"`
+}
+
+func (pk TimerPK) String() string {
+	return fmt.Sprintf("%s", pk.TimerID)
+}
+
+//Timer item holds a message that is to be send to the queue once it is due,
+//for delays that are longer than the queue supports
+type Timer struct {
+	TimerPK
+	Queue     string `dynamodbav:"queue"`
+	Body      string `dynamodbav:"body"`
+	Due       int64  `dynamodbav:"due"`
+	Partition int64  `dynamodbav:"part"`
+
+	//DedupID deduplicates the message when it is send to a FIFO queue, e.g:
+	//the id of the task it submits. Timers without one use their own id.
+	DedupID string `dynamodbav:"dedup,omitempty"`
+}
+
+//DeduplicationID returns the id that deduplicates the message in a FIFO queue
+func (t *Timer) DeduplicationID() string {
+	if t.DedupID != "" {
+		return t.DedupID
+	}
+
+	return t.TimerID
+}
+
+//NewTimer creates a timer for the named queue that is not yet stored
+func NewTimer(queue, body string, due time.Time) (*Timer, error) {
+	uuid, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate timer id")
+	}
+
+	return &Timer{
+		TimerPK: TimerPK{
+			TimerID: uuid,
+		},
+		Queue:     queue,
+		Body:      body,
+		Due:       due.Unix(),
+		Partition: rand.Int63n(TimerScatterPartitions),
+	}, nil
+}
+
+//TxPutTimer creates a transaction item that stores the timer
+func TxPutTimer(t *Timer) (*TxItem, error) {
+	return TxPut(TimerTableName, t, "attribute_not_exists(id)", TxExpr{}, ErrTimerExists)
+}
+
+//DeleteTimer will delete a timer after its message was send
+func DeleteTimer(ctx context.Context, db DB, pk TimerPK) (err error) {
+	del := dynamo.NewDelete(TimerTableName, pk)
+	del.SetConditionExpression("attribute_exists(id)")
+	del.SetConditionError(ErrTimerNotExists)
+	if err = del.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to delete timer item")
+	}
+
+	return nil
+}
+
+//DueTimers queries the due index for timers of which the message is to be send
+func DueTimers(ctx context.Context, db DB, limit int64) (timers []*Timer, err error) {
+	for i := int64(0); i < TimerScatterPartitions; i++ {
+		q := dynamo.NewQuery(TimerTableName, "part = :part AND due BETWEEN :mindue AND :maxdue")
+		q.SetIndexName(TimerDueIdxName)
+		q.SetLimit(limit)
+		q.AddExpressionValue(":part", i)
+		q.AddExpressionValue(":mindue", 1)
+		q.AddExpressionValue(":maxdue", time.Now().Unix())
+
+		partTimers := []*Timer{}
+		if _, err := q.ExecuteWithContext(ctx, db, &partTimers); err != nil {
+			return nil, errors.Wrapf(err, "failed to query partition %d", i)
+		}
+
+		timers = append(timers, partTimers...)
+	}
+
+	return timers, nil
+}
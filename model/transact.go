@@ -0,0 +1,190 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/cenkalti/backoff"
+	"github.com/pkg/errors"
+)
+
+var (
+	//MaxTransactConflictRetries determines how often a transaction is retried
+	//when it conflicted with a concurrent one
+	MaxTransactConflictRetries = uint64(5)
+
+	//TransactConflictBackoff is the first wait before a transaction that
+	//conflicted is retried, it grows with every retry
+	TransactConflictBackoff = time.Millisecond * 20
+
+	//ErrTransactConflict is returned when a transaction kept conflicting with
+	//concurrent transactions on the same items
+	ErrTransactConflict = errors.New("transaction conflicted with concurrent transactions")
+)
+
+//TxItem is one conditional write in a transaction, Err is returned when its
+//condition caused the transaction to be cancelled
+type TxItem struct {
+	*dynamodb.TransactWriteItem
+	Err error
+}
+
+//TxExpr holds the expression names and values of a transaction item
+type TxExpr struct {
+	Names  map[string]string
+	Values map[string]interface{}
+}
+
+func (ex TxExpr) marshal() (names map[string]*string, values map[string]*dynamodb.AttributeValue, err error) {
+	if len(ex.Names) > 0 {
+		names = aws.StringMap(ex.Names)
+	}
+
+	if len(ex.Values) > 0 {
+		values = map[string]*dynamodb.AttributeValue{}
+		for k, v := range ex.Values {
+			if values[k], err = dynamodbattribute.Marshal(v); err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to marshal expression value '%s'", k)
+			}
+		}
+	}
+
+	return names, values, nil
+}
+
+//TxPut creates a conditional put transaction item
+func TxPut(table string, item interface{}, cond string, ex TxExpr, condErr error) (*TxItem, error) {
+	attrs, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal item")
+	}
+
+	names, values, err := ex.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	put := &dynamodb.Put{}
+	put.SetTableName(table)
+	put.SetItem(attrs)
+	put.SetConditionExpression(cond)
+	put.ExpressionAttributeNames = names
+	put.ExpressionAttributeValues = values
+	return &TxItem{TransactWriteItem: (&dynamodb.TransactWriteItem{}).SetPut(put), Err: condErr}, nil
+}
+
+//TxUpdate creates a conditional update transaction item
+func TxUpdate(table string, pk interface{}, upd, cond string, ex TxExpr, condErr error) (*TxItem, error) {
+	key, err := dynamodbattribute.MarshalMap(pk)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal key")
+	}
+
+	names, values, err := ex.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	update := &dynamodb.Update{}
+	update.SetTableName(table)
+	update.SetKey(key)
+	update.SetUpdateExpression(upd)
+	update.SetConditionExpression(cond)
+	update.ExpressionAttributeNames = names
+	update.ExpressionAttributeValues = values
+	return &TxItem{TransactWriteItem: (&dynamodb.TransactWriteItem{}).SetUpdate(update), Err: condErr}, nil
+}
+
+//TxDelete creates a conditional delete transaction item
+func TxDelete(table string, pk interface{}, cond string, ex TxExpr, condErr error) (*TxItem, error) {
+	key, err := dynamodbattribute.MarshalMap(pk)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal key")
+	}
+
+	names, values, err := ex.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	del := &dynamodb.Delete{}
+	del.SetTableName(table)
+	del.SetKey(key)
+	del.SetConditionExpression(cond)
+	del.ExpressionAttributeNames = names
+	del.ExpressionAttributeValues = values
+	return &TxItem{TransactWriteItem: (&dynamodb.TransactWriteItem{}).SetDelete(del), Err: condErr}, nil
+}
+
+//TransactWrite executes all items or none of them. If the transaction is
+//cancelled because of a failing condition the error of that item is returned.
+//Transactions that conflict with a concurrent one on the same items, e.g. on
+//the counters of a quota, are retried with a backoff. If they keep
+//conflicting ErrTransactConflict is returned.
+func TransactWrite(ctx context.Context, db DB, items ...*TxItem) (err error) {
+	inp := &dynamodb.TransactWriteItemsInput{}
+	for _, item := range items {
+		inp.TransactItems = append(inp.TransactItems, item.TransactWriteItem)
+	}
+
+	operation := func() error {
+		_, err := db.TransactWriteItemsWithContext(ctx, inp)
+		if err == nil {
+			return nil
+		}
+
+		cerr, ok := err.(*dynamodb.TransactionCanceledException)
+		if !ok {
+			return backoff.Permanent(errors.Wrap(err, "failed to transact write items"))
+		}
+
+		conflict := false
+		for i, reason := range cerr.CancellationReasons {
+			switch aws.StringValue(reason.Code) {
+			case "ConditionalCheckFailed":
+				if i < len(items) && items[i].Err != nil {
+					return backoff.Permanent(items[i].Err)
+				}
+			case "TransactionConflict":
+				conflict = true
+			}
+		}
+
+		if conflict {
+			return ErrTransactConflict
+		}
+
+		return backoff.Permanent(errors.Wrap(err, "failed to transact write items"))
+	}
+
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = TransactConflictBackoff
+	return backoff.Retry(operation, backoff.WithContext(
+		backoff.WithMaxTries(b, MaxTransactConflictRetries), ctx))
+}
+
+//IsThrottled returns whether the error is caused by the database throttling
+//requests, also when it cancelled a transaction
+func IsThrottled(err error) bool {
+	switch cerr := errors.Cause(err).(type) {
+	case *dynamodb.TransactionCanceledException:
+		for _, reason := range cerr.CancellationReasons {
+			if aws.StringValue(reason.Code) == "ThrottlingError" {
+				return true
+			}
+		}
+	case awserr.Error:
+		switch cerr.Code() {
+		case dynamodb.ErrCodeProvisionedThroughputExceededException,
+			dynamodb.ErrCodeRequestLimitExceeded,
+			"ThrottlingException":
+			return true
+		}
+	}
+
+	return false
+}
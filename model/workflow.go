@@ -0,0 +1,263 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dynamo "github.com/advanderveer/go-dynamo"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	//WorkflowTableName sets the name of the workflow table
+	WorkflowTableName = "factory-workflows"
+
+	//WorkflowStateIdxName indexes workflows based on their state
+	WorkflowStateIdxName = "state_idx"
+
+	//ErrWorkflowExists is thrown when a workflow was expected not to exist
+	ErrWorkflowExists = errors.New("workflow already exists")
+
+	//ErrWorkflowNotExists is thrown when a workflow was expected to exist
+	ErrWorkflowNotExists = errors.New("workflow does not exist")
+
+	//ErrWorkflowFinished is thrown when a workflow was expected to be running
+	ErrWorkflowFinished = errors.New("workflow has finished")
+
+	//ErrTaskNotWaiting is thrown when a task no longer waits for its upstream tasks
+	ErrTaskNotWaiting = errors.New("task is no longer waiting for upstream tasks")
+)
+
+const (
+	//WorkflowStateRunning means some tasks of the workflow have not finished
+	WorkflowStateRunning = "running"
+
+	//WorkflowStateSucceeded means all tasks of the workflow succeeded
+	WorkflowStateSucceeded = "succeeded"
+
+	//WorkflowStateFailed means all tasks of the workflow finished but some did not succeed
+	WorkflowStateFailed = "failed"
+
+	//OnFailureFail marks the downstream tasks of a task that did not succeed as failed
+	OnFailureFail = "fail"
+
+	//OnFailureSkip marks the downstream tasks of a task that did not succeed as skipped
+	OnFailureSkip = "skip"
+)
+
+//WorkflowPK is the primary key
+type WorkflowPK struct {
+	WorkflowID string `dynamodbav:"id"`
+}
+
+func (pk WorkflowPK) String() string {
+	return fmt.Sprintf("%s", pk.WorkflowID)
+}
+
+//WorkflowTask is a named task of a workflow and the names of the tasks that
+//must succeed before it is scheduled
+type WorkflowTask struct {
+	Name      string   `dynamodbav:"name"`
+	TaskID    string   `dynamodbav:"task"`
+	DependsOn []string `dynamodbav:"depends_on,omitempty"`
+}
+
+//Workflow item records a graph of tasks. Tasks without dependencies are
+//scheduled when the workflow is submitted, the others wait until their
+//upstream tasks succeeded.
+type Workflow struct {
+	WorkflowPK
+	State     string          `dynamodbav:"state"`
+	OnFailure string          `dynamodbav:"on_failure"`
+	Created   int64           `dynamodbav:"created"`
+	Submitter string          `dynamodbav:"submitter"`
+	Tasks     []*WorkflowTask `dynamodbav:"tasks"`
+}
+
+//NewWorkflow creates a running workflow without tasks that is not yet stored
+func NewWorkflow(submitter, onFailure string) (*Workflow, error) {
+	uuid, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate workflow id")
+	}
+
+	return &Workflow{
+		WorkflowPK: WorkflowPK{
+			WorkflowID: uuid,
+		},
+		State:     WorkflowStateRunning,
+		OnFailure: onFailure,
+		Created:   time.Now().Unix(),
+		Submitter: submitter,
+	}, nil
+}
+
+//Sorted returns the tasks such that every task comes after the tasks it
+//depends on, it fails if a dependency is unknown or the tasks form a cycle
+func (wf *Workflow) Sorted() ([]*WorkflowTask, error) {
+	byName := map[string]*WorkflowTask{}
+	for _, wt := range wf.Tasks {
+		byName[wt.Name] = wt
+	}
+
+	indegree, downstream := map[string]int{}, map[string][]*WorkflowTask{}
+	for _, wt := range wf.Tasks {
+		for _, dep := range wt.DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return nil, errors.Errorf("task '%s' depends on unknown task '%s'", wt.Name, dep)
+			}
+
+			indegree[wt.Name]++
+			downstream[dep] = append(downstream[dep], wt)
+		}
+	}
+
+	sorted, next := []*WorkflowTask{}, []*WorkflowTask{}
+	for _, wt := range wf.Tasks {
+		if indegree[wt.Name] == 0 {
+			next = append(next, wt)
+		}
+	}
+
+	for len(next) > 0 {
+		wt := next[0]
+		next = next[1:]
+		sorted = append(sorted, wt)
+		for _, down := range downstream[wt.Name] {
+			indegree[down.Name]--
+			if indegree[down.Name] == 0 {
+				next = append(next, down)
+			}
+		}
+	}
+
+	if len(sorted) != len(wf.Tasks) {
+		return nil, errors.New("the dependencies of the tasks form a cycle")
+	}
+
+	return sorted, nil
+}
+
+//SubmitWorkflow stores a new workflow with its tasks and writes any extra
+//items in a single transaction
+func SubmitWorkflow(ctx context.Context, db DB, wf *Workflow, tasks []*Task, extra ...*TxItem) (err error) {
+	putItem, err := TxPut(WorkflowTableName, wf, "attribute_not_exists(id)", TxExpr{}, ErrWorkflowExists)
+	if err != nil {
+		return errors.Wrap(err, "failed to create workflow item")
+	}
+
+	items := []*TxItem{putItem}
+	for _, task := range tasks {
+		taskItem, err := TxPut(TaskTableName, task, "attribute_not_exists(id)", TxExpr{}, ErrTaskExists)
+		if err != nil {
+			return errors.Wrap(err, "failed to create task item")
+		}
+
+		items = append(items, taskItem)
+	}
+
+	if err = TransactWrite(ctx, db, append(items, extra...)...); err != nil {
+		return errors.Wrap(err, "failed to submit workflow")
+	}
+
+	return nil
+}
+
+//GetWorkflow returns a workflow by its primary key
+func GetWorkflow(ctx context.Context, db DB, pk WorkflowPK) (*Workflow, error) {
+	q := dynamo.NewQuery(WorkflowTableName, "id = :id")
+	q.AddExpressionValue(":id", pk.WorkflowID)
+
+	wfs := []*Workflow{}
+	if _, err := q.ExecuteWithContext(ctx, db, &wfs); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	if len(wfs) < 1 {
+		return nil, ErrWorkflowNotExists
+	}
+
+	return wfs[0], nil
+}
+
+//RunningWorkflows queries the state index for workflows that are running
+//and were created at or after the unix time, the oldest first
+func RunningWorkflows(ctx context.Context, db DB, after, limit int64) (wfs []*Workflow, err error) {
+	q := dynamo.NewQuery(WorkflowTableName, "#state = :running AND #created >= :after")
+	q.SetIndexName(WorkflowStateIdxName)
+	q.SetLimit(limit)
+	q.AddExpressionName("#state", "state")
+	q.AddExpressionName("#created", "created")
+	q.AddExpressionValue(":running", WorkflowStateRunning)
+	q.AddExpressionValue(":after", after)
+	if _, err = q.ExecuteWithContext(ctx, db, &wfs); err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+
+	return wfs, nil
+}
+
+//FinishWorkflow records the final state of a running workflow
+func FinishWorkflow(ctx context.Context, db DB, pk WorkflowPK, state string) (err error) {
+	upd := dynamo.NewUpdate(WorkflowTableName, pk)
+	upd.SetUpdateExpression("SET #state = :state")
+	upd.SetConditionExpression("attribute_exists(id) AND #state = :running")
+	upd.AddExpressionName("#state", "state")
+	upd.AddExpressionValue(":state", state)
+	upd.AddExpressionValue(":running", WorkflowStateRunning)
+	upd.SetConditionError(ErrWorkflowFinished)
+	if err = upd.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to update workflow")
+	}
+
+	return nil
+}
+
+//ReleaseTask marks a task that waited for its upstream tasks as pending,
+//stores the outbox message that schedules it and writes any extra items in
+//a single transaction
+func ReleaseTask(ctx context.Context, db DB, task *Task, out *Outbox, extra ...*TxItem) (err error) {
+	updItem, err := TxUpdate(TaskTableName, task.TaskPK,
+		"SET #state = :pending",
+		"attribute_exists(id) AND #state = :waiting",
+		TxExpr{
+			Names:  map[string]string{"#state": "state"},
+			Values: map[string]interface{}{":pending": TaskStatePending, ":waiting": TaskStateWaiting},
+		},
+		ErrTaskNotWaiting)
+	if err != nil {
+		return errors.Wrap(err, "failed to create task item")
+	}
+
+	outItem, err := TxPutOutbox(out)
+	if err != nil {
+		return errors.Wrap(err, "failed to create outbox item")
+	}
+
+	if err = TransactWrite(ctx, db, append([]*TxItem{updItem, outItem}, extra...)...); err != nil {
+		return errors.Wrap(err, "failed to release task")
+	}
+
+	return nil
+}
+
+//SkipTask marks a task that waited for its upstream tasks as failed or
+//skipped, it is then never scheduled. Its exit code is set to -1 as it never ran.
+func SkipTask(ctx context.Context, db DB, pk TaskPK, state string) (err error) {
+	upd := dynamo.NewUpdate(TaskTableName, pk)
+	upd.SetUpdateExpression("SET #state = :state, #exit = :exit")
+	upd.SetConditionExpression("attribute_exists(id) AND #state = :waiting")
+	upd.AddExpressionName("#state", "state")
+	upd.AddExpressionName("#exit", "exit")
+	upd.AddExpressionValue(":state", state)
+	upd.AddExpressionValue(":exit", -1)
+	upd.AddExpressionValue(":waiting", TaskStateWaiting)
+	upd.SetConditionError(ErrTaskNotWaiting)
+	if err = upd.ExecuteWithContext(ctx, db); err != nil {
+		return errors.Wrap(err, "failed to update task")
+	}
+
+	return nil
+}
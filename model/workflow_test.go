@@ -0,0 +1,96 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func wt(name string, deps ...string) *WorkflowTask {
+	return &WorkflowTask{Name: name, DependsOn: deps}
+}
+
+func TestWorkflowSorted(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		tasks  []*WorkflowTask
+		exp    []string
+		expErr string
+	}{
+		{
+			name:  "independent tasks keep their order",
+			tasks: []*WorkflowTask{wt("c"), wt("a"), wt("b")},
+			exp:   []string{"c", "a", "b"},
+		},
+		{
+			name:  "chain given in reverse",
+			tasks: []*WorkflowTask{wt("c", "b"), wt("b", "a"), wt("a")},
+			exp:   []string{"a", "b", "c"},
+		},
+		{
+			name:  "diamond",
+			tasks: []*WorkflowTask{wt("join", "left", "right"), wt("left", "root"), wt("right", "root"), wt("root")},
+			exp:   []string{"root", "left", "right", "join"},
+		},
+		{
+			name:  "task waits for its last upstream task",
+			tasks: []*WorkflowTask{wt("a"), wt("b", "a"), wt("c", "a", "b")},
+			exp:   []string{"a", "b", "c"},
+		},
+		{
+			name:  "upstream task listed twice",
+			tasks: []*WorkflowTask{wt("a"), wt("b", "a", "a")},
+			exp:   []string{"a", "b"},
+		},
+		{
+			name:  "separate graphs",
+			tasks: []*WorkflowTask{wt("b", "a"), wt("y", "x"), wt("a"), wt("x")},
+			exp:   []string{"a", "x", "b", "y"},
+		},
+		{
+			name:  "no tasks",
+			tasks: nil,
+			exp:   []string{},
+		},
+		{
+			name:   "unknown upstream task",
+			tasks:  []*WorkflowTask{wt("a"), wt("b", "missing")},
+			expErr: "depends on unknown task 'missing'",
+		},
+		{
+			name:   "cycle",
+			tasks:  []*WorkflowTask{wt("root"), wt("a", "root", "c"), wt("b", "a"), wt("c", "b")},
+			expErr: "form a cycle",
+		},
+		{
+			name:   "task depends on itself",
+			tasks:  []*WorkflowTask{wt("a", "a")},
+			expErr: "form a cycle",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			wf := &Workflow{Tasks: c.tasks}
+			sorted, err := wf.Sorted()
+			if c.expErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.expErr) {
+					t.Fatalf("expected error containing '%s', got: %v", c.expErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+
+			act := []string{}
+			for _, task := range sorted {
+				act = append(act, task.Name)
+			}
+
+			if !reflect.DeepEqual(act, c.exp) {
+				t.Fatalf("expected order %v, got: %v", c.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+
+	"github.com/advanderveer/factory/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+//authenticate returns a context that carries the identity of the caller, it
+//presents a bearer token in the "authorization" metadata or a verified
+//client certificate
+func (s *Server) authenticate(ctx context.Context) (context.Context, error) {
+	token := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, v := range md.Get("authorization") {
+			if strings.HasPrefix(v, "Bearer ") {
+				token = strings.TrimSpace(strings.TrimPrefix(v, "Bearer "))
+			}
+		}
+	}
+
+	var chains [][]*x509.Certificate
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			chains = info.State.VerifiedChains
+		}
+	}
+
+	id, err := s.policy.Authenticate(token, chains)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.WithIdentity(ctx, id), nil
+}
+
+//UnaryInterceptor authenticates the caller of unary methods
+func (s *Server) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := s.authenticate(ctx)
+		if err != nil {
+			return nil, s.fail(info.FullMethod, err)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+//StreamInterceptor authenticates the caller of streaming methods
+func (s *Server) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := s.authenticate(ss.Context())
+		if err != nil {
+			return s.fail(info.FullMethod, err)
+		}
+
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+//authStream is a server stream whose context carries the caller's identity
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (as *authStream) Context() context.Context {
+	return as.ctx
+}
@@ -0,0 +1,382 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: factory.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Factory_Submit_FullMethodName     = "/factory.v1.Factory/Submit"
+	Factory_Get_FullMethodName        = "/factory.v1.Factory/Get"
+	Factory_Cancel_FullMethodName     = "/factory.v1.Factory/Cancel"
+	Factory_Logs_FullMethodName       = "/factory.v1.Factory/Logs"
+	Factory_ListNodes_FullMethodName  = "/factory.v1.Factory/ListNodes"
+	Factory_WatchTask_FullMethodName  = "/factory.v1.Factory/WatchTask"
+	Factory_WatchNodes_FullMethodName = "/factory.v1.Factory/WatchNodes"
+)
+
+// FactoryClient is the client API for Factory service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Factory drives the scheduler: tasks are submitted to a pool of nodes and
+// placed on a node with enough capacity. Callers authenticate with a bearer
+// token in the "authorization" metadata or with a client certificate.
+type FactoryClient interface {
+	// Submit records a task and queues it for scheduling.
+	Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*Task, error)
+	// Get returns a task.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Task, error)
+	// Cancel stops a task from being (re)scheduled and removes its claim.
+	Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*Task, error)
+	// Logs returns the tail of the logs of a finished task.
+	Logs(ctx context.Context, in *LogsRequest, opts ...grpc.CallOption) (*LogsResponse, error)
+	// ListNodes returns the registered nodes.
+	ListNodes(ctx context.Context, in *ListNodesRequest, opts ...grpc.CallOption) (*ListNodesResponse, error)
+	// WatchTask sends the task when it is first seen and each time it changes,
+	// the stream ends once the task finished.
+	WatchTask(ctx context.Context, in *WatchTaskRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Task], error)
+	// WatchNodes sends every node when it is first seen and each time it
+	// changes or is removed.
+	WatchNodes(ctx context.Context, in *WatchNodesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[NodeEvent], error)
+}
+
+type factoryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFactoryClient(cc grpc.ClientConnInterface) FactoryClient {
+	return &factoryClient{cc}
+}
+
+func (c *factoryClient) Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*Task, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Task)
+	err := c.cc.Invoke(ctx, Factory_Submit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *factoryClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Task, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Task)
+	err := c.cc.Invoke(ctx, Factory_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *factoryClient) Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*Task, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Task)
+	err := c.cc.Invoke(ctx, Factory_Cancel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *factoryClient) Logs(ctx context.Context, in *LogsRequest, opts ...grpc.CallOption) (*LogsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LogsResponse)
+	err := c.cc.Invoke(ctx, Factory_Logs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *factoryClient) ListNodes(ctx context.Context, in *ListNodesRequest, opts ...grpc.CallOption) (*ListNodesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListNodesResponse)
+	err := c.cc.Invoke(ctx, Factory_ListNodes_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *factoryClient) WatchTask(ctx context.Context, in *WatchTaskRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Task], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Factory_ServiceDesc.Streams[0], Factory_WatchTask_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchTaskRequest, Task]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Factory_WatchTaskClient = grpc.ServerStreamingClient[Task]
+
+func (c *factoryClient) WatchNodes(ctx context.Context, in *WatchNodesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[NodeEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Factory_ServiceDesc.Streams[1], Factory_WatchNodes_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchNodesRequest, NodeEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Factory_WatchNodesClient = grpc.ServerStreamingClient[NodeEvent]
+
+// FactoryServer is the server API for Factory service.
+// All implementations must embed UnimplementedFactoryServer
+// for forward compatibility.
+//
+// Factory drives the scheduler: tasks are submitted to a pool of nodes and
+// placed on a node with enough capacity. Callers authenticate with a bearer
+// token in the "authorization" metadata or with a client certificate.
+type FactoryServer interface {
+	// Submit records a task and queues it for scheduling.
+	Submit(context.Context, *SubmitRequest) (*Task, error)
+	// Get returns a task.
+	Get(context.Context, *GetRequest) (*Task, error)
+	// Cancel stops a task from being (re)scheduled and removes its claim.
+	Cancel(context.Context, *CancelRequest) (*Task, error)
+	// Logs returns the tail of the logs of a finished task.
+	Logs(context.Context, *LogsRequest) (*LogsResponse, error)
+	// ListNodes returns the registered nodes.
+	ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error)
+	// WatchTask sends the task when it is first seen and each time it changes,
+	// the stream ends once the task finished.
+	WatchTask(*WatchTaskRequest, grpc.ServerStreamingServer[Task]) error
+	// WatchNodes sends every node when it is first seen and each time it
+	// changes or is removed.
+	WatchNodes(*WatchNodesRequest, grpc.ServerStreamingServer[NodeEvent]) error
+	mustEmbedUnimplementedFactoryServer()
+}
+
+// UnimplementedFactoryServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedFactoryServer struct{}
+
+func (UnimplementedFactoryServer) Submit(context.Context, *SubmitRequest) (*Task, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Submit not implemented")
+}
+func (UnimplementedFactoryServer) Get(context.Context, *GetRequest) (*Task, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedFactoryServer) Cancel(context.Context, *CancelRequest) (*Task, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedFactoryServer) Logs(context.Context, *LogsRequest) (*LogsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Logs not implemented")
+}
+func (UnimplementedFactoryServer) ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNodes not implemented")
+}
+func (UnimplementedFactoryServer) WatchTask(*WatchTaskRequest, grpc.ServerStreamingServer[Task]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTask not implemented")
+}
+func (UnimplementedFactoryServer) WatchNodes(*WatchNodesRequest, grpc.ServerStreamingServer[NodeEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchNodes not implemented")
+}
+func (UnimplementedFactoryServer) mustEmbedUnimplementedFactoryServer() {}
+func (UnimplementedFactoryServer) testEmbeddedByValue()                 {}
+
+// UnsafeFactoryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FactoryServer will
+// result in compilation errors.
+type UnsafeFactoryServer interface {
+	mustEmbedUnimplementedFactoryServer()
+}
+
+func RegisterFactoryServer(s grpc.ServiceRegistrar, srv FactoryServer) {
+	// If the following call pancis, it indicates UnimplementedFactoryServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Factory_ServiceDesc, srv)
+}
+
+func _Factory_Submit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FactoryServer).Submit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Factory_Submit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FactoryServer).Submit(ctx, req.(*SubmitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Factory_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FactoryServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Factory_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FactoryServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Factory_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FactoryServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Factory_Cancel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FactoryServer).Cancel(ctx, req.(*CancelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Factory_Logs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FactoryServer).Logs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Factory_Logs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FactoryServer).Logs(ctx, req.(*LogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Factory_ListNodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListNodesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FactoryServer).ListNodes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Factory_ListNodes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FactoryServer).ListNodes(ctx, req.(*ListNodesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Factory_WatchTask_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTaskRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FactoryServer).WatchTask(m, &grpc.GenericServerStream[WatchTaskRequest, Task]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Factory_WatchTaskServer = grpc.ServerStreamingServer[Task]
+
+func _Factory_WatchNodes_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchNodesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FactoryServer).WatchNodes(m, &grpc.GenericServerStream[WatchNodesRequest, NodeEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Factory_WatchNodesServer = grpc.ServerStreamingServer[NodeEvent]
+
+// Factory_ServiceDesc is the grpc.ServiceDesc for Factory service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Factory_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "factory.v1.Factory",
+	HandlerType: (*FactoryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Submit",
+			Handler:    _Factory_Submit_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _Factory_Get_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _Factory_Cancel_Handler,
+		},
+		{
+			MethodName: "Logs",
+			Handler:    _Factory_Logs_Handler,
+		},
+		{
+			MethodName: "ListNodes",
+			Handler:    _Factory_ListNodes_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchTask",
+			Handler:       _Factory_WatchTask_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchNodes",
+			Handler:       _Factory_WatchNodes_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "factory.proto",
+}
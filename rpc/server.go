@@ -0,0 +1,243 @@
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative factory.proto
+
+import (
+	"context"
+	"strings"
+
+	"github.com/advanderveer/factory/auth"
+	"github.com/advanderveer/factory/engine"
+	"github.com/advanderveer/factory/model"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+//Server implements the Factory gRPC service on top of the engine
+type Server struct {
+	UnimplementedFactoryServer
+
+	logs   engine.Logger
+	eng    *engine.Engine
+	db     model.DB
+	policy *auth.Policy
+}
+
+//NewServer creates a server that wraps the engine and queries the database
+//directly. Callers are authenticated and authorized with the policy, if it is
+//nil every caller may do everything. The server's interceptors must be
+//installed for the policy to be enforced.
+func NewServer(logs engine.Logger, eng *engine.Engine, db model.DB, policy *auth.Policy) *Server {
+	return &Server{logs: logs, eng: eng, db: db, policy: policy}
+}
+
+//fail turns an error into a status, the code follows from its cause
+func (s *Server) fail(method string, err error) error {
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+		return err //e.g. a stream that failed to send
+	}
+
+	switch errors.Cause(err) {
+	case model.ErrTaskNotExists, model.ErrNodeNotExists, model.ErrClaimNotExists:
+		return status.Error(codes.NotFound, err.Error())
+	case model.ErrTaskCancelled, model.ErrTaskNotFinished:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case auth.ErrUnauthenticated:
+		return status.Error(codes.Unauthenticated, err.Error())
+	case auth.ErrForbidden:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case model.ErrQuotaExceeded:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	s.logs.Printf("[ERROR] Failed to handle %s: %v", method, err)
+	return status.Error(codes.Internal, err.Error())
+}
+
+func taskMsg(task *model.Task) *Task {
+	return &Task{
+		TaskId:    task.TaskID,
+		PoolId:    task.PoolID,
+		Size:      task.Size,
+		State:     task.State,
+		NodeId:    task.NodeID,
+		ClaimId:   task.ClaimID,
+		CreatedAt: &timestamppb.Timestamp{Seconds: task.Created},
+		ExitCode:  task.Exit,
+		Submitter: task.Submitter,
+		Priority:  task.Priority,
+	}
+}
+
+func nodeMsg(node *model.Node) *Node {
+	return &Node{
+		NodeId:    node.NodeID,
+		PoolId:    node.PoolID,
+		Host:      node.Host,
+		Cap:       node.Cap,
+		Max:       node.Max,
+		Drain:     node.Drain,
+		ExpiresAt: &timestamppb.Timestamp{Seconds: node.TTL},
+	}
+}
+
+//Submit records a task and queues it for scheduling
+func (s *Server) Submit(ctx context.Context, req *SubmitRequest) (*Task, error) {
+	if req.PoolId == "" || req.Size < 1 {
+		return nil, status.Error(codes.InvalidArgument, "pool_id and a size of at least 1 are required")
+	}
+
+	if !model.ValidPriority(req.Priority) {
+		return nil, status.Errorf(codes.InvalidArgument, "priority must be one of: %s", strings.Join(model.Priorities, ", "))
+	}
+
+	if err := auth.Authorize(ctx, auth.ActionSubmit, req.PoolId); err != nil {
+		return nil, s.fail("Submit", err)
+	}
+
+	id, _ := auth.FromContext(ctx)
+	taskID, err := s.eng.Submit(ctx, engine.TaskSpec{PoolID: req.PoolId, Size: req.Size, Submitter: id.Name, Priority: req.Priority})
+	if err != nil {
+		return nil, s.fail("Submit", err)
+	}
+
+	task, err := model.GetTask(ctx, s.db, model.TaskPK{TaskID: taskID})
+	if err != nil {
+		return nil, s.fail("Submit", err)
+	}
+
+	return taskMsg(task), nil
+}
+
+//Get returns a task
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*Task, error) {
+	task, err := s.eng.TaskStatus(ctx, req.TaskId)
+	if err != nil {
+		return nil, s.fail("Get", err)
+	}
+
+	if err = auth.Authorize(ctx, auth.ActionRead, task.PoolID); err != nil {
+		return nil, s.fail("Get", err)
+	}
+
+	return taskMsg(task), nil
+}
+
+//Cancel stops a task from being (re)scheduled and removes its claim
+func (s *Server) Cancel(ctx context.Context, req *CancelRequest) (*Task, error) {
+	task, err := model.GetTask(ctx, s.db, model.TaskPK{TaskID: req.TaskId})
+	if err != nil {
+		return nil, s.fail("Cancel", err)
+	}
+
+	if err = auth.Authorize(ctx, auth.ActionCancel, task.PoolID); err != nil {
+		return nil, s.fail("Cancel", err)
+	}
+
+	task, err = s.eng.Cancel(ctx, req.TaskId)
+	if err != nil {
+		return nil, s.fail("Cancel", err)
+	}
+
+	return taskMsg(task), nil
+}
+
+//Logs returns the tail of the logs of a finished task
+func (s *Server) Logs(ctx context.Context, req *LogsRequest) (*LogsResponse, error) {
+	task, err := model.GetTask(ctx, s.db, model.TaskPK{TaskID: req.TaskId})
+	if err != nil {
+		return nil, s.fail("Logs", err)
+	}
+
+	if err = auth.Authorize(ctx, auth.ActionRead, task.PoolID); err != nil {
+		return nil, s.fail("Logs", err)
+	}
+
+	logs, err := s.eng.TaskLogs(ctx, req.TaskId)
+	if err != nil {
+		return nil, s.fail("Logs", err)
+	}
+
+	return &LogsResponse{Logs: logs}, nil
+}
+
+//ListNodes returns the registered nodes, without a pool only those of the
+//pools the caller may read
+func (s *Server) ListNodes(ctx context.Context, req *ListNodesRequest) (*ListNodesResponse, error) {
+	if req.PoolId != "" {
+		if err := auth.Authorize(ctx, auth.ActionRead, req.PoolId); err != nil {
+			return nil, s.fail("ListNodes", err)
+		}
+	}
+
+	nodes, err := model.ListNodes(ctx, s.db)
+	if err != nil {
+		return nil, s.fail("ListNodes", err)
+	}
+
+	id, _ := auth.FromContext(ctx)
+	resp := &ListNodesResponse{}
+	for _, node := range nodes {
+		if (req.PoolId != "" && node.PoolID != req.PoolId) || !id.Allowed(auth.ActionRead, node.PoolID) {
+			continue
+		}
+
+		resp.Nodes = append(resp.Nodes, nodeMsg(node))
+	}
+
+	return resp, nil
+}
+
+//WatchTask streams the task each time it changes
+func (s *Server) WatchTask(req *WatchTaskRequest, stream Factory_WatchTaskServer) error {
+	task, err := model.GetTask(stream.Context(), s.db, model.TaskPK{TaskID: req.TaskId})
+	if err != nil {
+		return s.fail("WatchTask", err)
+	}
+
+	if err = auth.Authorize(stream.Context(), auth.ActionRead, task.PoolID); err != nil {
+		return s.fail("WatchTask", err)
+	}
+
+	if err := s.eng.WatchTask(stream.Context(), req.TaskId, func(task *model.Task) error {
+		return stream.Send(taskMsg(task))
+	}); err != nil {
+		return s.fail("WatchTask", err)
+	}
+
+	return nil
+}
+
+//WatchNodes streams an event each time a node changes or is removed, without
+//a pool only those of the pools the caller may read
+func (s *Server) WatchNodes(req *WatchNodesRequest, stream Factory_WatchNodesServer) error {
+	if req.PoolId != "" {
+		if err := auth.Authorize(stream.Context(), auth.ActionRead, req.PoolId); err != nil {
+			return s.fail("WatchNodes", err)
+		}
+	}
+
+	id, _ := auth.FromContext(stream.Context())
+	if err := s.eng.WatchNodes(stream.Context(), req.PoolId, func(ev engine.NodeEvent) error {
+		if !id.Allowed(auth.ActionRead, ev.Node.PoolID) {
+			return nil
+		}
+
+		typ := NodeEvent_TYPE_UPSERTED
+		if ev.Removed {
+			typ = NodeEvent_TYPE_REMOVED
+		}
+
+		return stream.Send(&NodeEvent{Type: typ, Node: nodeMsg(ev.Node)})
+	}); err != nil {
+		return s.fail("WatchNodes", err)
+	}
+
+	return nil
+}